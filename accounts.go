@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// managerFor returns the IAM manager for the AWS account the namespace is mapped to. Managers for
+// other accounts are created the first time they're needed and reused afterwards, and retried on
+// the next sync if creating them fails.
+func (c *Controller) managerFor(namespace string, cfg *adminConfig) (*iam.Manager, error) {
+	mapping, err := c.accountFor(namespace, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if mapping == nil || mapping.AccountID == c.iam.AccountID() {
+		return c.iam, nil
+	}
+
+	// Only the syncs needing the same account wait for its manager to be created
+	account := c.accountManager(mapping.AccountID + "/" + mapping.RoleARN)
+	account.lock.Lock()
+	defer account.lock.Unlock()
+	if account.manager != nil {
+		return account.manager, nil
+	}
+
+	klog.Infof("Assuming '%s' to manage IAM Roles in account %s", mapping.RoleARN, mapping.AccountID)
+	manager, err := c.iam.ForAccount(mapping.AccountID, mapping.RoleARN)
+	if err != nil {
+		return nil, err
+	}
+	// Roles in the account must trust the cluster's OIDC provider, so it must exist there too
+	if err := manager.EnsureOIDCProvider(
+		createOIDCProvider,
+		oidcThumbprints,
+		oidcClientIDs,
+	); err != nil {
+		return nil, err
+	}
+
+	account.manager = manager
+	return manager, nil
+}
+
+// accountManager holds the IAM manager of an AWS account, once it's created.
+type accountManager struct {
+	lock    sync.Mutex
+	manager *iam.Manager
+}
+
+// accountManager returns the holder of the IAM manager for the account and role ARN in key.
+func (c *Controller) accountManager(key string) *accountManager {
+	c.managersLock.Lock()
+	defer c.managersLock.Unlock()
+
+	account, ok := c.managers[key]
+	if !ok {
+		account = &accountManager{}
+		c.managers[key] = account
+	}
+	return account
+}
+
+// expectedRoleARN returns the ARN the ServiceAccount's role-arn annotation must have, which is in
+// the AWS account its namespace is mapped to. It is the role of its role group if it joins one, or
+// the namespace's role if it has a shared one. Role groups are in the account of the namespace
+// owning them.
+func (c *Controller) expectedRoleARN(sa *corev1.ServiceAccount, cfg *adminConfig) (string, error) {
+	return c.roleARNWithNaming(c.iam, sa, cfg)
+}
+
+// roleARNWithNaming returns the ARN of the ServiceAccount's role, as expectedRoleARN does, for the
+// role prefix of naming, e.g. the one the cluster had before a migration.
+func (c *Controller) roleARNWithNaming(
+	naming *iam.Manager,
+	sa *corev1.ServiceAccount,
+	cfg *adminConfig,
+) (string, error) {
+	group, inGroup, err := roleGroupOf(sa)
+	if err != nil {
+		return "", err
+	}
+
+	namespace := sa.ObjectMeta.Namespace
+	if inGroup {
+		namespace = group.namespace
+	}
+	mapping, err := c.accountFor(namespace, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	accountID := c.iam.AccountID()
+	if mapping != nil {
+		accountID = mapping.AccountID
+	}
+	switch {
+	case inGroup:
+		return naming.MakeGroupRoleARNInAccount(group.namespace, group.name, accountID), nil
+	case cfg.hasNamespaceRole(namespace):
+		return naming.MakeNamespaceRoleARNInAccount(namespace, accountID), nil
+	default:
+		return naming.MakeRoleARNInAccount(sa.ObjectMeta.Name, namespace, accountID), nil
+	}
+}
+
+// accountFor returns the account mapping of the namespace, or nil if its roles are in the
+// controller's own account.
+func (c *Controller) accountFor(namespace string, cfg *adminConfig) (*accountMapping, error) {
+	namespaceLabels, err := c.namespaceLabels(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.accountFor(namespace, namespaceLabels), nil
+}
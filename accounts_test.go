@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	securitylisters "github.com/ovotech/iam-service-account-controller/pkg/generated/listers/security/v1alpha1"
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	ownAccountID       = "123456789012"
+	mappedAccountID    = "210987654321"
+	mappedRoleARN      = "arn:aws:iam::210987654321:role/manager"
+	byNameAccountID    = "333333333333"
+	byNameRoleARN      = "arn:aws:iam::333333333333:role/manager"
+	mappedNamespace    = "team-b"
+	unmappedNamespace  = "team-a"
+	ownMappedNamespace = "own"
+)
+
+// accountsConfig maps the namespaces labelled team=b to mappedAccountID, then team-b and gone to
+// byNameAccountID by name, and own to the controller's own account.
+var accountsConfig = &adminConfig{
+	Accounts: []accountMapping{
+		{
+			AccountID: mappedAccountID,
+			RoleARN:   mappedRoleARN,
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"team": "b"},
+			},
+		},
+		{
+			AccountID:  byNameAccountID,
+			RoleARN:    byNameRoleARN,
+			Namespaces: []string{mappedNamespace, "gone"},
+		},
+		{
+			AccountID:  ownAccountID,
+			RoleARN:    "arn:aws:iam::123456789012:role/manager",
+			Namespaces: []string{ownMappedNamespace},
+		},
+	},
+	NamespaceRoles: map[string]namespaceRole{"shared": {}},
+}
+
+// newAccountsController returns a controller knowing the namespaces of accountsConfig, whose
+// manager for mappedAccountID already exists so no test assumes its management role.
+func newAccountsController(t *testing.T) (*Controller, *iam.Manager) {
+	namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, team := range map[string]string{
+		unmappedNamespace:  "a",
+		mappedNamespace:    "b",
+		"shared":           "b",
+		ownMappedNamespace: "c",
+	} {
+		ns := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      map[string]string{"team": team},
+				Annotations: map[string]string{managedAnnotationKey: "true"},
+			},
+		}
+		if err := namespaces.Add(ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mapped := iam.NewManager(
+		nil,
+		nil,
+		mappedAccountID,
+		controllerName,
+		"k8s-sa",
+		"eu-west-1",
+		"oidc.example.com",
+		nil,
+		"cluster",
+		nil,
+	)
+	c := &Controller{
+		iam: iam.NewManager(
+			nil,
+			nil,
+			ownAccountID,
+			controllerName,
+			"k8s-sa",
+			"eu-west-1",
+			"oidc.example.com",
+			nil,
+			"cluster",
+			nil,
+		),
+		namespacesLister: corelisters.NewNamespaceLister(namespaces),
+		roleResourcesLister: securitylisters.NewIAMServiceAccountRoleLister(
+			cache.NewIndexer(
+				cache.MetaNamespaceKeyFunc,
+				cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+			),
+		),
+		managers: map[string]*accountManager{
+			mappedAccountID + "/" + mappedRoleARN: {manager: mapped},
+		},
+	}
+	return c, mapped
+}
+
+func TestControllerAccountFor(t *testing.T) {
+	c, _ := newAccountsController(t)
+
+	var tests = []struct {
+		name      string
+		namespace string
+		want      string
+	}{
+		{"selector before name", mappedNamespace, mappedAccountID},
+		{"selector", "shared", mappedAccountID},
+		{"name of a deleted namespace", "gone", byNameAccountID},
+		{"own account", ownMappedNamespace, ownAccountID},
+		{"unmapped", unmappedNamespace, ""},
+		{"unmapped deleted namespace", "unknown", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping, err := c.accountFor(tt.namespace, accountsConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if mapping != nil {
+				got = mapping.AccountID
+			}
+			if got != tt.want {
+				t.Errorf("got account %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManagerFor(t *testing.T) {
+	c, mapped := newAccountsController(t)
+
+	var tests = []struct {
+		name      string
+		namespace string
+		want      *iam.Manager
+	}{
+		{"mapped", mappedNamespace, mapped},
+		{"unmapped", unmappedNamespace, c.iam},
+		{"mapped to the own account", ownMappedNamespace, c.iam},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, err := c.managerFor(tt.namespace, accountsConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manager != tt.want {
+				t.Errorf(
+					"got manager of account %s, want %s",
+					manager.AccountID(),
+					tt.want.AccountID(),
+				)
+			}
+		})
+	}
+}
+
+func TestExpectedRoleARN(t *testing.T) {
+	c, _ := newAccountsController(t)
+
+	var tests = []struct {
+		name        string
+		namespace   string
+		annotations map[string]string
+		want        string
+	}{
+		{"own role", unmappedNamespace, nil, "arn:aws:iam::123456789012:role/k8s-sa_team-a_app"},
+		{
+			"mapped own role",
+			mappedNamespace,
+			nil,
+			"arn:aws:iam::210987654321:role/k8s-sa_team-b_app",
+		},
+		{"mapped namespace role", "shared", nil, "arn:aws:iam::210987654321:role/k8s-sa_shared"},
+		{
+			"role group of a mapped namespace",
+			unmappedNamespace,
+			map[string]string{roleGroupAnnotationKey: "team-b/jobs"},
+			"arn:aws:iam::210987654321:role/k8s-sa_team-b_group_jobs",
+		},
+		{
+			"role group of an unmapped namespace",
+			mappedNamespace,
+			map[string]string{roleGroupAnnotationKey: "team-a/jobs"},
+			"arn:aws:iam::123456789012:role/k8s-sa_team-a_group_jobs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "app",
+					Namespace:   tt.namespace,
+					Annotations: tt.annotations,
+				},
+			}
+			got, err := c.expectedRoleARN(sa, accountsConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRoleAccount(t *testing.T) {
+	c, _ := newAccountsController(t)
+
+	var tests = []struct {
+		name      string
+		namespace string
+		accountID string
+		wantRole  bool
+	}{
+		{"mapped account", mappedNamespace, mappedAccountID, true},
+		{"own account", mappedNamespace, ownAccountID, false},
+		{"other mapping", mappedNamespace, byNameAccountID, false},
+		{"unmapped", unmappedNamespace, ownAccountID, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "app",
+					Namespace: tt.namespace,
+					Annotations: map[string]string{
+						roleAnnotationKey: fmt.Sprintf(
+							"arn:aws:iam::%s:role/k8s-sa_%s_app",
+							tt.accountID,
+							tt.namespace,
+						),
+					},
+				},
+			}
+			ns, err := c.namespace(tt.namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wanted, refusal, err := c.checkRole(sa, ns, accountsConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wanted != tt.wantRole {
+				t.Errorf("got wanted %t, want %t", wanted, tt.wantRole)
+			}
+			if !tt.wantRole && (refusal == nil || refusal.status != StatusMisconfigured) {
+				t.Errorf("got refusal %+v, want %s", refusal, StatusMisconfigured)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// adoption is the admin-defined rules of which ServiceAccounts can adopt an existing role which
+// isn't managed by the controller, with modeAdopt. No ServiceAccount can if there are no rules.
+type adoption struct {
+	// Namespaces are the wildcard patterns of the namespaces whose ServiceAccounts can adopt
+	// roles, e.g. "team-*".
+	Namespaces []string `json:"namespaces"`
+}
+
+// allows returns true if the ServiceAccounts of the namespace can adopt roles.
+func (a *adoption) allows(namespace string) bool {
+	return matchesAnyWildcard(a.Namespaces, namespace)
+}
+
+// adoptRole takes over the existing role of the request, which isn't managed by the controller,
+// if the request is in adopt mode, the admins allow adopting roles in its namespace and the
+// role's trust policy trusts nothing broader than the ServiceAccount. It returns the adopted role,
+// or nil with a warning event if the role is left alone.
+func (c *Controller) adoptRole(
+	manager *iam.Manager,
+	req *roleRequest,
+	role *awstypes.Role,
+	spec iam.RoleSpec,
+	cfg *adminConfig,
+) (*awstypes.Role, error) {
+	if req.mode != modeAdopt || !cfg.Adoption.allows(req.namespace) {
+		req.unmanaged = unmanagedRoleMessage(req, cfg)
+		c.recorder.Event(req.object, corev1.EventTypeWarning, SyncWarning, req.unmanaged)
+		return nil, nil
+	}
+
+	// A role with the expected name which trusts more than the ServiceAccount may be squatted,
+	// adopting it would grant the ServiceAccount's permissions to whoever it trusts
+	refusals, err := manager.AdoptionRefusals(role, req.name, req.namespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(refusals) > 0 {
+		klog.Warningf(
+			"IAM Role '%s' for '%s/%s' trusts more than the ServiceAccount, not adopting it: %s",
+			*role.RoleName,
+			req.namespace,
+			req.name,
+			strings.Join(refusals, "; "),
+		)
+		req.unmanaged = fmt.Sprintf(MessageAdoptionRefused, *role.Arn, strings.Join(refusals, "; "))
+		c.recorder.Event(req.object, corev1.EventTypeWarning, AdoptionRefused, req.unmanaged)
+		return nil, nil
+	}
+
+	klog.Infof("Adopting IAM Role '%s' for '%s/%s'", *role.RoleName, req.namespace, req.name)
+	if err := manager.AdoptRole(role, req.name, req.namespace, spec.Mode); err != nil {
+		c.recorder.Event(
+			req.object,
+			corev1.EventTypeWarning,
+			SyncFailed,
+			fmt.Sprintf(MessageRoleAdoptionFailed, err.Error()),
+		)
+		return nil, err
+	}
+	// The trust policy is synced from the tags of the adopted role
+	return manager.GetRole(req.name, req.namespace)
+}
+
+// unmanagedRoleMessage explains that the requested role exists but isn't managed by the
+// controller, and for ServiceAccounts which modes of their managed annotation would resolve it.
+func unmanagedRoleMessage(req *roleRequest, cfg *adminConfig) string {
+	if _, ok := req.object.(*corev1.ServiceAccount); !ok {
+		return MessageUnmanagedRole
+	}
+	if !cfg.Adoption.allows(req.namespace) {
+		return fmt.Sprintf(MessageUnmanagedRoleNoAdoption, managedAnnotationKey, modeObserve)
+	}
+	return fmt.Sprintf(
+		MessageUnmanagedServiceAccountRole,
+		managedAnnotationKey,
+		modeAdopt,
+		modeObserve,
+	)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAdoptionAllows(t *testing.T) {
+	a := adoption{Namespaces: []string{"team-*", "legacy"}}
+
+	var tests = []struct {
+		namespace string
+		want      bool
+	}{
+		{"team-a", true},
+		{"legacy", true},
+		{"legacy-2", false},
+		{"default", false},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%t", tt.namespace, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			if ans := a.allows(tt.namespace); ans != tt.want {
+				t.Errorf("got %t, want %t", ans, tt.want)
+			}
+		})
+	}
+
+	if (&adoption{}).allows("default") {
+		t.Errorf("got adoption allowed without rules")
+	}
+}
+
+func TestUnmanagedRoleMessage(t *testing.T) {
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "bar"}}
+	allowed := &adminConfig{Adoption: adoption{Namespaces: []string{"bar"}}}
+
+	var tests = []struct {
+		name string
+		req  *roleRequest
+		cfg  *adminConfig
+		want string
+	}{
+		{
+			"adoption allowed",
+			serviceAccountRequest(sa),
+			allowed,
+			fmt.Sprintf(MessageUnmanagedServiceAccountRole, managedAnnotationKey, "adopt", "observe"),
+		},
+		{
+			"adoption not allowed",
+			serviceAccountRequest(sa),
+			&adminConfig{},
+			fmt.Sprintf(MessageUnmanagedRoleNoAdoption, managedAnnotationKey, "observe"),
+		},
+		{
+			"not a ServiceAccount",
+			&roleRequest{namespace: "bar", object: &corev1.Namespace{}},
+			allowed,
+			MessageUnmanagedRole,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unmanagedRoleMessage(tt.req, tt.cfg); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
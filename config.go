@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// adminConfigKey is the key in the admin ConfigMap holding the controller configuration.
+	adminConfigKey = "config.yaml"
+	// allNamespaces can be used in namespace lists of the admin configuration to match any
+	// namespace.
+	allNamespaces = "*"
+)
+
+// isValidAccountID matches AWS account IDs.
+var isValidAccountID = regexp.MustCompile(`^[0-9]{12}$`).MatchString
+
+// isValidPolicyName matches the characters IAM allows in inline policy names.
+var isValidPolicyName = regexp.MustCompile(`^[\w+=,.@-]+$`).MatchString
+
+// adminConfig is the configuration owned by cluster admins. It is read from a ConfigMap in the
+// controller namespace, which users with access to their own namespaces cannot change.
+type adminConfig struct {
+	// PolicyGrants maps a grant name, which users request through an annotation on their
+	// ServiceAccount, to an AWS managed policy.
+	PolicyGrants map[string]policyGrant `json:"policyGrants"`
+	// PolicyTemplates maps a template name, which users opt into through an annotation on their
+	// ServiceAccount, to an inline policy template.
+	PolicyTemplates map[string]policyTemplate `json:"policyTemplates"`
+	// Guardrails limit the inline policies users can put on their roles. Users can't supply
+	// inline policies if there are no guardrails.
+	Guardrails *iam.Guardrails `json:"guardrails"`
+	// Accounts maps namespaces to the AWS accounts their roles are managed in. Namespaces that
+	// aren't mapped have their roles in the controller's own account.
+	Accounts []accountMapping `json:"accounts"`
+	// AssumableRoles maps a namespace, or "*" for all namespaces, to the wildcard patterns of the
+	// role ARNs its ServiceAccounts can request to assume.
+	AssumableRoles map[string][]string `json:"assumableRoles"`
+	// RoleMetadata limits the description, maximum session duration and tags users can request
+	// for their roles.
+	RoleMetadata roleMetadata `json:"roleMetadata"`
+	// Eligibility restricts which ServiceAccounts can have a role managed by the controller.
+	Eligibility eligibility `json:"eligibility"`
+	// NamespaceRoles maps namespaces to the settings of the role shared by their ServiceAccounts.
+	// The managed ServiceAccounts of these namespaces use the shared role instead of their own.
+	NamespaceRoles map[string]namespaceRole `json:"namespaceRoles"`
+	// Adoption allows the ServiceAccounts of some namespaces to adopt existing roles which aren't
+	// managed by the controller.
+	Adoption adoption `json:"adoption"`
+}
+
+// policyGrant is an AWS managed policy that can be attached to the roles of ServiceAccounts in
+// the listed namespaces.
+type policyGrant struct {
+	PolicyARN  string   `json:"policyArn"`
+	Namespaces []string `json:"namespaces"`
+}
+
+// policyTemplate is an inline policy document parameterised with the ServiceAccount's name and
+// namespace, the cluster name and the AWS account ID, e.g. "{{ .Namespace }}". See
+// iam.PolicyTemplateData for the available values.
+type policyTemplate struct {
+	Document string `json:"document"`
+}
+
+// accountMapping maps namespaces, by name or labels, to an AWS account and the management role
+// the controller assumes to manage roles in that account.
+type accountMapping struct {
+	AccountID         string                `json:"accountId"`
+	RoleARN           string                `json:"roleArn"`
+	Namespaces        []string              `json:"namespaces"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
+}
+
+// parseAdminConfig reads the admin configuration from the ConfigMap. A nil ConfigMap results in an
+// empty configuration, which grants nothing.
+func parseAdminConfig(cm *corev1.ConfigMap) (*adminConfig, error) {
+	cfg := &adminConfig{}
+	if cm == nil {
+		return cfg, nil
+	}
+
+	data, ok := cm.Data[adminConfigKey]
+	if !ok {
+		return cfg, nil
+	}
+	if err := yaml.UnmarshalStrict([]byte(data), cfg); err != nil {
+		return nil, fmt.Errorf("invalid admin config in ConfigMap '%s/%s': %s", cm.Namespace, cm.Name, err)
+	}
+
+	for grantName, grant := range cfg.PolicyGrants {
+		if grant.PolicyARN == "" {
+			return nil, fmt.Errorf("policy grant '%s' has no policyArn", grantName)
+		}
+	}
+
+	for templateName, policyTemplate := range cfg.PolicyTemplates {
+		if !isValidPolicyName(templateName) {
+			return nil, fmt.Errorf("invalid policy template name '%s'", templateName)
+		}
+		if _, err := template.New(templateName).Parse(policyTemplate.Document); err != nil {
+			return nil, fmt.Errorf("invalid policy template '%s': %s", templateName, err)
+		}
+	}
+
+	for i, mapping := range cfg.Accounts {
+		if !isValidAccountID(mapping.AccountID) {
+			return nil, fmt.Errorf("account mapping %d has invalid accountId '%s'", i, mapping.AccountID)
+		}
+		if mapping.RoleARN == "" {
+			return nil, fmt.Errorf("account mapping %d has no roleArn", i)
+		}
+		if mapping.NamespaceSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(mapping.NamespaceSelector); err != nil {
+				return nil, fmt.Errorf("account mapping %d has invalid namespaceSelector: %s", i, err)
+			}
+		}
+	}
+
+	for namespace, settings := range cfg.NamespaceRoles {
+		if !isValidUserInput(namespace) {
+			return nil, fmt.Errorf("invalid namespace role namespace '%s'", namespace)
+		}
+		if err := settings.validate(&cfg.Eligibility); err != nil {
+			return nil, fmt.Errorf("invalid role for namespace '%s': %s", namespace, err)
+		}
+	}
+
+	if err := cfg.Eligibility.validate(); err != nil {
+		return nil, fmt.Errorf("invalid eligibility: %s", err)
+	}
+
+	if err := cfg.RoleMetadata.validate(); err != nil {
+		return nil, fmt.Errorf("invalid roleMetadata: %s", err)
+	}
+
+	if cfg.Guardrails != nil {
+		if err := cfg.Guardrails.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid guardrails: %s", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// loadAdminConfig fetches the admin ConfigMap from the informer cache and parses it.
+func (c *Controller) loadAdminConfig() (*adminConfig, error) {
+	cm, err := c.configMapsLister.ConfigMaps(controllerNamespace).Get(adminConfigMapName)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return parseAdminConfig(nil)
+		}
+		return nil, err
+	}
+	return parseAdminConfig(cm)
+}
+
+// resolvePolicyGrants returns the policy ARNs for the requested grant names that are allowed in
+// the namespace, as well as the names of the grants that were refused.
+func (cfg *adminConfig) resolvePolicyGrants(
+	requested []string,
+	namespace string,
+) (arns []string, refused []string) {
+	for _, grantName := range requested {
+		grant, ok := cfg.PolicyGrants[grantName]
+		if !ok || !namespaceAllowed(grant.Namespaces, namespace) {
+			refused = append(refused, grantName)
+			continue
+		}
+		arns = append(arns, grant.PolicyARN)
+	}
+	return arns, refused
+}
+
+// grantablePolicies returns the ARNs of all the policies the controller may attach to a role.
+func (cfg *adminConfig) grantablePolicies() []string {
+	var arns []string
+	for _, grant := range cfg.PolicyGrants {
+		arns = append(arns, grant.PolicyARN)
+	}
+	sort.Strings(arns)
+	return arns
+}
+
+// resolvePolicyTemplates returns the requested policy templates keyed by name, as well as the
+// names of the templates that don't exist.
+func (cfg *adminConfig) resolvePolicyTemplates(
+	requested []string,
+) (templates map[string]string, unknown []string) {
+	templates = map[string]string{}
+	for _, templateName := range requested {
+		policyTemplate, ok := cfg.PolicyTemplates[templateName]
+		if !ok {
+			unknown = append(unknown, templateName)
+			continue
+		}
+		templates[templateName] = policyTemplate.Document
+	}
+	return templates, unknown
+}
+
+// accountFor returns the account mapping of the namespace, or nil if its roles are in the
+// controller's own account. The first matching mapping wins. namespaceLabels may be nil if the
+// namespace doesn't exist anymore, in which case only mappings by name match.
+func (cfg *adminConfig) accountFor(namespace string, namespaceLabels map[string]string) *accountMapping {
+	for i, mapping := range cfg.Accounts {
+		if contains(mapping.Namespaces, namespace) {
+			return &cfg.Accounts[i]
+		}
+		if mapping.NamespaceSelector != nil && namespaceLabels != nil {
+			// the selector was validated when parsing the config
+			selector, _ := metav1.LabelSelectorAsSelector(mapping.NamespaceSelector)
+			if selector.Matches(labels.Set(namespaceLabels)) {
+				return &cfg.Accounts[i]
+			}
+		}
+	}
+	return nil
+}
+
+// resolveAssumableRoles returns the requested role ARNs the namespace's ServiceAccounts are
+// allowed to assume, as well as the ARNs which were refused.
+func (cfg *adminConfig) resolveAssumableRoles(
+	requested []string,
+	namespace string,
+) (allowed []string, refused []string) {
+	patterns := append(
+		append([]string(nil), cfg.AssumableRoles[allNamespaces]...),
+		cfg.AssumableRoles[namespace]...,
+	)
+
+	for _, arn := range requested {
+		if !iam.IsValidRoleARN(arn) || !matchesAnyWildcard(patterns, arn) {
+			refused = append(refused, arn)
+			continue
+		}
+		allowed = append(allowed, arn)
+	}
+	return allowed, refused
+}
+
+func matchesAnyWildcard(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if iam.MatchesWildcard(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceAllowed returns true if namespace is in the list of allowed namespaces.
+func namespaceAllowed(allowed []string, namespace string) bool {
+	for _, ns := range allowed {
+		if ns == allNamespaces || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// splitAnnotationList splits a comma-separated annotation value into its trimmed, non-empty items.
+func splitAnnotationList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseAdminConfig(t *testing.T) {
+	var tests = []struct {
+		data    string
+		wantErr bool
+	}{
+		{"", false},
+		{"policyGrants:\n  s3:\n    policyArn: arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess\n", false},
+		{"policyGrants:\n  s3:\n    namespaces: [default]\n", true},
+		{"unknownKey: true\n", true},
+		{"policyTemplates:\n  s3:\n    document: '{\"Resource\": \"{{ .Namespace }}\"}'\n", false},
+		{"policyTemplates:\n  s3:\n    document: '{{ .Namespace '\n", true},
+		{"policyTemplates:\n  s3/read:\n    document: '{}'\n", true},
+		{"guardrails:\n  resourcePatterns: ['arn:aws:s3:::bucket/{{ .Namespace }}/*']\n", false},
+		{"guardrails:\n  resourcePatterns: ['arn:aws:s3:::bucket/*']\n", true},
+		{"accounts:\n- accountId: '210987654321'\n  roleArn: arn:aws:iam::210987654321:role/manager\n", false},
+		{"accounts:\n- accountId: '2109'\n  roleArn: arn:aws:iam::2109:role/manager\n", true},
+		{"accounts:\n- accountId: '210987654321'\n", true},
+		{"roleMetadata:\n  maxSessionDuration: 4h\n  userTagPrefix: team/\n", false},
+		{"roleMetadata:\n  maxSessionDuration: 24h\n", true},
+		{"roleMetadata:\n  userTagPrefix: role.k8s.aws/\n", true},
+		{"roleMetadata:\n  namespaceLabelTags: [team, cost-centre]\n", false},
+		{"roleMetadata:\n  namespaceLabelTags: ['aws:team']\n", true},
+		{"eligibility:\n  excludedNamespaces: ['kube-*']\n  excludedServiceAccounts: [default]\n", false},
+		{"namespaceRoles:\n  team-a: {}\n  team-b:\n    trust: serviceAccounts\n", false},
+		{"namespaceRoles:\n  team-a:\n    trust: everyone\n", true},
+		{"namespaceRoles:\n  team-a:\n    trust: namespace\n", false},
+		{
+			"eligibility:\n  excludedServiceAccounts: [default]\n" +
+				"namespaceRoles:\n  team-a:\n    trust: namespace\n",
+			true,
+		},
+		{
+			"eligibility:\n  serviceAccounts: ['app-*']\nnamespaceRoles:\n  team-a: {}\n",
+			false,
+		},
+		{"eligibility:\n  namespaceSelector:\n    matchExpressions:\n    - {key: team, operator: Bad}\n", true},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%q,%t", tt.data, tt.wantErr)
+		t.Run(testname, func(t *testing.T) {
+			cm := &corev1.ConfigMap{Data: map[string]string{adminConfigKey: tt.data}}
+			_, err := parseAdminConfig(cm)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolvePolicyGrants(t *testing.T) {
+	cfg := &adminConfig{
+		PolicyGrants: map[string]policyGrant{
+			"s3-read": {PolicyARN: "arn:s3", Namespaces: []string{"team-a"}},
+			"sqs":     {PolicyARN: "arn:sqs", Namespaces: []string{allNamespaces}},
+		},
+	}
+
+	var tests = []struct {
+		requested   []string
+		namespace   string
+		wantARNs    []string
+		wantRefused []string
+	}{
+		{[]string{"s3-read", "sqs"}, "team-a", []string{"arn:s3", "arn:sqs"}, nil},
+		{[]string{"s3-read", "sqs"}, "team-b", []string{"arn:sqs"}, []string{"s3-read"}},
+		{[]string{"unknown"}, "team-a", nil, []string{"unknown"}},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%v,%s", tt.requested, tt.namespace)
+		t.Run(testname, func(t *testing.T) {
+			arns, refused := cfg.resolvePolicyGrants(tt.requested, tt.namespace)
+			if !reflect.DeepEqual(arns, tt.wantARNs) {
+				t.Errorf("got %v, want %v", arns, tt.wantARNs)
+			}
+			if !reflect.DeepEqual(refused, tt.wantRefused) {
+				t.Errorf("got refused %v, want %v", refused, tt.wantRefused)
+			}
+		})
+	}
+}
+
+func TestAccountFor(t *testing.T) {
+	cfg := &adminConfig{
+		Accounts: []accountMapping{
+			{AccountID: "111111111111", Namespaces: []string{"team-a"}},
+			{
+				AccountID: "222222222222",
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"team": "b"},
+				},
+			},
+		},
+	}
+
+	var tests = []struct {
+		namespace string
+		labels    map[string]string
+		want      string
+	}{
+		{"team-a", nil, "111111111111"},
+		{"team-a", map[string]string{"team": "b"}, "111111111111"},
+		{"other", map[string]string{"team": "b"}, "222222222222"},
+		{"other", map[string]string{"team": "c"}, ""},
+		{"other", nil, ""},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%v,%s", tt.namespace, tt.labels, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			ans := ""
+			if mapping := cfg.accountFor(tt.namespace, tt.labels); mapping != nil {
+				ans = mapping.AccountID
+			}
+			if ans != tt.want {
+				t.Errorf("got %s, want %s", ans, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveAssumableRoles(t *testing.T) {
+	cfg := &adminConfig{
+		AssumableRoles: map[string][]string{
+			"team-a":      {"arn:aws:iam::210987654321:role/team-a-*"},
+			allNamespaces: {"arn:aws:iam::210987654321:role/shared"},
+		},
+	}
+
+	var tests = []struct {
+		requested   []string
+		namespace   string
+		wantAllowed []string
+		wantRefused []string
+	}{
+		{
+			[]string{"arn:aws:iam::210987654321:role/team-a-reader", "arn:aws:iam::210987654321:role/shared"},
+			"team-a",
+			[]string{"arn:aws:iam::210987654321:role/team-a-reader", "arn:aws:iam::210987654321:role/shared"},
+			nil,
+		},
+		{
+			[]string{"arn:aws:iam::210987654321:role/team-a-reader", "arn:aws:iam::210987654321:role/shared"},
+			"team-b",
+			[]string{"arn:aws:iam::210987654321:role/shared"},
+			[]string{"arn:aws:iam::210987654321:role/team-a-reader"},
+		},
+		{
+			[]string{"arn:aws:iam::210987654321:role/team-a-*"},
+			"team-a",
+			nil,
+			[]string{"arn:aws:iam::210987654321:role/team-a-*"},
+		},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%v,%s", tt.requested, tt.namespace)
+		t.Run(testname, func(t *testing.T) {
+			allowed, refused := cfg.resolveAssumableRoles(tt.requested, tt.namespace)
+			if !reflect.DeepEqual(allowed, tt.wantAllowed) {
+				t.Errorf("got %v, want %v", allowed, tt.wantAllowed)
+			}
+			if !reflect.DeepEqual(refused, tt.wantRefused) {
+				t.Errorf("got refused %v, want %v", refused, tt.wantRefused)
+			}
+		})
+	}
+}
@@ -2,14 +2,23 @@ package main
 
 import (
 	"fmt"
-	"regexp"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
+	securityclientset "github.com/ovotech/iam-service-account-controller/pkg/generated/clientset/versioned"
+	securityscheme "github.com/ovotech/iam-service-account-controller/pkg/generated/clientset/versioned/scheme"
+	securityinformers "github.com/ovotech/iam-service-account-controller/pkg/generated/informers/externalversions/security/v1alpha1"
+	securitylisters "github.com/ovotech/iam-service-account-controller/pkg/generated/listers/security/v1alpha1"
 	"github.com/ovotech/iam-service-account-controller/pkg/iam"
 	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
 
+	awstypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	coreinformers "k8s.io/client-go/informers/core/v1"
@@ -24,32 +33,145 @@ import (
 )
 
 const (
-	managedAnnotationKey      = "security.kaluza.com/iam-role-managed"
-	roleAnnotationKey         = "eks.amazonaws.com/role-arn"
-	SyncSuccess               = "Synced"
-	MessageResourceSynced     = "Successfully synced AWS IAM role"
-	SyncFailed                = "SyncFailed"
-	MessageRoleCreationFailed = "Failed to create AWS IAM role due to: %s"
-	SyncWarning               = "SyncWarning"
-	MessageUnmanagedRole      = "AWS IAM role exists but is not managed by controller"
-	MessageMisconfiguredARN   = "ServiceAccount is managed but ARN doesn't match spec"
+	managedAnnotationKey               = "security.kaluza.com/iam-role-managed"
+	managedExclusionsAnnotationKey     = "security.kaluza.com/iam-role-managed-exclusions"
+	policyGrantsAnnotationKey          = "security.kaluza.com/iam-role-policy-grants"
+	policyTemplatesAnnotationKey       = "security.kaluza.com/iam-role-policy-templates"
+	policyTemplatePrefix               = "template-"
+	secretsAccessAnnotationKey         = "security.kaluza.com/iam-role-secrets-access"
+	builtinPolicyPrefix                = "builtin-"
+	secretsAccessPolicyName            = "secrets-access"
+	assumeRolesAnnotationKey           = "security.kaluza.com/iam-role-assume-roles"
+	assumeRolesPolicyName              = "assume-roles"
+	inlinePolicyAnnotationKey          = "security.kaluza.com/iam-role-inline-policy"
+	inlinePolicyConfigMapAnnotationKey = "security.kaluza.com/iam-role-inline-policy-configmap"
+	inlinePolicyConfigMapKey           = "policy.json"
+	userPolicyPrefix                   = "user-"
+	userPolicyName                     = "policy"
+	roleAnnotationKey                  = "eks.amazonaws.com/role-arn"
+	audienceAnnotationKey              = "security.kaluza.com/iam-role-audience"
+	roleDescriptionAnnotationKey       = "security.kaluza.com/iam-role-description"
+	maxSessionDurationAnnotationKey    = "security.kaluza.com/iam-role-max-session-duration"
+	roleTagsAnnotationKey              = "security.kaluza.com/iam-role-tags"
+	roleGroupAnnotationKey             = "security.kaluza.com/iam-role-group"
+	roleGroupGrantLabelKey             = "security.kaluza.com/iam-role-group-grant"
+	roleGroupGrantKey                  = "from"
+	SyncSuccess                        = "Synced"
+	MessageResourceSynced              = "Successfully synced AWS IAM role"
+	MessageNamespaceRoleSynced         = "Successfully synced AWS IAM role shared by the namespace's ServiceAccounts"
+	SyncFailed                         = "SyncFailed"
+	MessageRoleCreationFailed          = "Failed to create AWS IAM role due to: %s"
+	MessageTrustPolicySyncFailed       = "Failed to sync AWS IAM role trust policy due to: %s"
+	MessagePermissionsSyncFailed       = "Failed to sync AWS IAM role permissions due to: %s"
+	MessageRoleMetadataSyncFailed      = "Failed to sync AWS IAM role metadata due to: %s"
+	MessageAnnotationFailed            = "Failed to annotate ServiceAccount with its AWS IAM role ARN due to: %s"
+	MessageAdminConfigInvalid          = "Failed to load controller configuration due to: %s"
+	MessageAccountUnavailable          = "Failed to manage AWS IAM role in the namespace's AWS account due to: %s"
+	SyncWarning                        = "SyncWarning"
+	MessageUnmanagedRole               = "AWS IAM role exists but is not managed by controller"
+	RoleNotOwned                       = "RoleNotOwned"
+	AnnotationsIgnored                 = "AnnotationsIgnored"
+	MessageAnnotationsIgnored          = "Annotations %s don't apply to the shared AWS IAM role %s and are ignored"
+	MessageRoleNotOwned                = "AWS IAM role %s is managed by the controller for another cluster or identity, its name collides with this one's, leaving it alone: %s"
+	MessageUnmanagedServiceAccountRole = "AWS IAM role exists but is not managed by controller: set %s to \"%s\" to take it over, or to \"%s\" to only report on it"
+	MessageUnmanagedRoleNoAdoption     = "AWS IAM role exists but is not managed by controller, and the admins don't allow adopting roles in this namespace: set %s to \"%s\" to only report on it"
+	MessageMisconfiguredARN            = "ServiceAccount is managed but its %s annotation '%s' doesn't match its AWS IAM role '%s': set the annotation to the expected ARN, or set %s to \"false\" to stop managing its role"
+	PolicyGrantRefused                 = "PolicyGrantRefused"
+	MessagePolicyGrantRefused          = "Policy grants not allowed in this namespace: %s"
+	PolicyTemplateRefused              = "PolicyTemplateRefused"
+	MessagePolicyTemplateRefused       = "Policy templates don't exist: %s"
+	InlinePolicyRefused                = "InlinePolicyRefused"
+	MessageInlinePolicyRefused         = "Inline policy refused: %s"
+	OIDCProviderRotated                = "OIDCProviderRotated"
+	MessageOIDCProviderRotated         = "AWS IAM role now trusts OIDC provider %s instead of: %s"
+	AssumeRoleRefused                  = "AssumeRoleRefused"
+	MessageAssumeRoleRefused           = "Assuming these roles is not allowed in this namespace: %s"
+	RoleMetadataRefused                = "RoleMetadataRefused"
+	MessageRoleMetadataRefused         = "Role metadata refused: %s"
+	ServiceAccountNotEligible          = "NotEligible"
+	MessageServiceAccountNotEligible   = "ServiceAccount is not eligible for a managed AWS IAM role: %s"
+	RoleGroupRefused                   = "RoleGroupRefused"
+	MessageRoleGroupRefused            = "Not allowed to join role group: %s"
+	RoleResourceConflict               = "Conflict"
+	MessageRoleResourceConflict        = "ServiceAccount's AWS IAM role is already declared by IAMServiceAccountRole %s"
+	RoleCreated                        = "Created"
+	MessageRoleCreated                 = "Created AWS IAM role %s"
+	RoleUpdated                        = "Updated"
+	MessageRoleUpdated                 = "Updated AWS IAM role to match its spec: %s"
+	ServiceAccountChanged              = "ServiceAccountChanged"
+	RoleResourceShared                 = "SharedRole"
+	MessageRoleResourceNamespaceRole   = "ServiceAccounts of namespace %s share an AWS IAM role chosen by the admins, they can't have their own: delete the IAMServiceAccountRole to use the shared role"
+	MessageRoleResourceRoleGroup       = "ServiceAccount joins role group %s and shares its AWS IAM role, it can't have its own: delete the IAMServiceAccountRole or leave the group"
+	MessageServiceAccountChanged       = "serviceAccountName can't change from %s, create another IAMServiceAccountRole instead"
+	MessageInvalidServiceAccountName   = "Invalid serviceAccountName '%s'"
+	RoleAdopted                        = "Adopted"
+	MessageRoleAdopted                 = "Adopted existing AWS IAM role %s"
+	MessageRoleAdoptionFailed          = "Failed to adopt AWS IAM role due to: %s"
+	AdoptionRefused                    = "AdoptionRefused"
+	MessageAdoptionRefused             = "Refusing to adopt AWS IAM role %s as its trust policy is broader than the ServiceAccount, it may have been created to get the permissions the ServiceAccount would be granted: %s"
+	RoleObserved                       = "Observed"
+	MessageRoleObserved                = "AWS IAM role %s exists and is managed by controller"
+	MessageUnmanagedRoleObserved       = "AWS IAM role %s exists but is not managed by controller"
+	MessageMissingRoleObserved         = "AWS IAM role %s doesn't exist"
+	RoleDeleted                        = "Deleted"
+	MessageRoleDeleted                 = "Deleted AWS IAM role %s as the ServiceAccount is no longer managed"
+	RoleReleased                       = "Released"
+	MessageRoleReleased                = "AWS IAM role %s is no longer managed by controller and was untagged, it is left in place"
+	RoleDetached                       = "Detached"
+	MessageRoleDetached                = "AWS IAM role %s is no longer managed by controller and was tagged as detached, set %s again to manage it"
+	MessageUnmanagedRoleFailed         = "Failed to apply the '%s' policy to the AWS IAM role of a ServiceAccount no longer managed due to: %s"
+	RoleARNRestored                    = "RoleARNRestored"
+	MessageRoleARNRestored             = "Restored the %s annotation, which was %s, to the ServiceAccount's AWS IAM role '%s': set %s to \"false\" to stop managing its role"
+	RoleARNChanged                     = "RoleARNChanged"
+	MessageRoleARNChanged              = "The %s annotation was %s and no longer references the ServiceAccount's AWS IAM role %s, which is no longer managed"
+	RoleMigrated                       = "Migrated"
+	MessageRoleMigrated                = "Migrated AWS IAM role %s to %s"
+	MessageRoleMigrationFailed         = "Failed to migrate AWS IAM role due to: %s"
+	PreviousRoleDeleted                = "PreviousRoleDeleted"
+	MessagePreviousRoleDeleted         = "Deleted AWS IAM role %s after its migration to %s"
+	RequestRefused                     = "Refused"
 )
 
 type Controller struct {
 	kubeclientset         kubernetes.Interface
+	securityclientset     securityclientset.Interface
 	serviceAccountsLister corelisters.ServiceAccountLister
 	serviceAccountsSynced cache.InformerSynced
-	workqueue             workqueue.RateLimitingInterface
-	recorder              record.EventRecorder
-	iam                   *iam.Manager
+	configMapsLister      corelisters.ConfigMapLister
+	configMapsSynced      cache.InformerSynced
+	// namespaceConfigMaps are the ConfigMaps of every namespace, e.g. inline policies and role
+	// group grants, whereas configMaps are those of the controller's namespace
+	namespaceConfigMapsLister corelisters.ConfigMapLister
+	namespaceConfigMapsSynced cache.InformerSynced
+	namespacesLister          corelisters.NamespaceLister
+	namespacesSynced          cache.InformerSynced
+	roleResourcesLister       securitylisters.IAMServiceAccountRoleLister
+	roleResourcesSynced       cache.InformerSynced
+	workqueue                 workqueue.RateLimitingInterface
+	recorder                  *transitionRecorder
+	iam                       *iam.Manager
+	// managers for the AWS accounts namespaces are mapped to, keyed by account and role ARN
+	managers     map[string]*accountManager
+	managersLock sync.Mutex
+	// observed are the ServiceAccounts last known in observe mode, whose role outlives them
+	observed *observedSet
 }
 
 func NewController(
 	kubeclientset kubernetes.Interface,
+	securityclientset securityclientset.Interface,
 	serviceAccountInformer coreinformers.ServiceAccountInformer,
+	configMapInformer coreinformers.ConfigMapInformer,
+	namespaceConfigMapInformer coreinformers.ConfigMapInformer,
+	namespaceInformer coreinformers.NamespaceInformer,
+	roleResourceInformer securityinformers.IAMServiceAccountRoleInformer,
 	iamManager *iam.Manager,
 ) *Controller {
 
+	// Add the custom resource types to the default Kubernetes Scheme so events can be logged for
+	// them
+	utilruntime.Must(securityscheme.AddToScheme(scheme.Scheme))
+
 	klog.Info("Creating event broadcaster")
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartStructuredLogging(0)
@@ -62,15 +184,26 @@ func NewController(
 	)
 
 	controller := &Controller{
-		kubeclientset:         kubeclientset,
-		serviceAccountsLister: serviceAccountInformer.Lister(),
-		serviceAccountsSynced: serviceAccountInformer.Informer().HasSynced,
+		kubeclientset:             kubeclientset,
+		securityclientset:         securityclientset,
+		serviceAccountsLister:     serviceAccountInformer.Lister(),
+		serviceAccountsSynced:     serviceAccountInformer.Informer().HasSynced,
+		configMapsLister:          configMapInformer.Lister(),
+		configMapsSynced:          configMapInformer.Informer().HasSynced,
+		namespaceConfigMapsLister: namespaceConfigMapInformer.Lister(),
+		namespaceConfigMapsSynced: namespaceConfigMapInformer.Informer().HasSynced,
+		namespacesLister:          namespaceInformer.Lister(),
+		namespacesSynced:          namespaceInformer.Informer().HasSynced,
+		roleResourcesLister:       roleResourceInformer.Lister(),
+		roleResourcesSynced:       roleResourceInformer.Informer().HasSynced,
 		workqueue: workqueue.NewNamedRateLimitingQueue(
 			workqueue.DefaultControllerRateLimiter(),
 			"ServiceAccounts",
 		),
-		recorder: recorder,
+		recorder: newTransitionRecorder(recorder),
 		iam:      iamManager,
+		managers: map[string]*accountManager{},
+		observed: newObservedSet(),
 	}
 
 	klog.Info("Setting up event handlers")
@@ -78,9 +211,53 @@ func NewController(
 	serviceAccountInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.enqueueServiceAccount,
 		UpdateFunc: func(old, new interface{}) {
+			// The controller writing the status annotations doesn't need another sync
+			if onlyStatusChanged(old.(*corev1.ServiceAccount), new.(*corev1.ServiceAccount)) {
+				return
+			}
 			controller.enqueueServiceAccount(new)
+			controller.enqueueFormerRoleGroup(old, new)
+			controller.handleServiceAccountUnmanaged(old, new)
+			controller.handleRoleARNChange(old, new)
+		},
+		DeleteFunc: controller.handleServiceAccountDelete,
+	})
+
+	// Changes to the admin configuration can affect every managed ServiceAccount
+	configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleConfigMap,
+		UpdateFunc: func(old, new interface{}) {
+			controller.handleConfigMap(new)
 		},
-		DeleteFunc: controller.enqueueServiceAccount,
+		DeleteFunc: controller.handleConfigMap,
+	})
+
+	// Inline policies and role group grants are read from the ConfigMaps of the namespaces, so
+	// their changes are applied without waiting for the next resync
+	namespaceConfigMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleNamespaceConfigMap,
+		UpdateFunc: func(old, new interface{}) {
+			if old.(*corev1.ConfigMap).ObjectMeta.ResourceVersion ==
+				new.(*corev1.ConfigMap).ObjectMeta.ResourceVersion {
+				return
+			}
+			// A grant whose label changed no longer applies to the group it was for
+			controller.handleNamespaceConfigMap(old)
+			controller.handleNamespaceConfigMap(new)
+		},
+		DeleteFunc: controller.handleNamespaceConfigMap,
+	})
+
+	// Namespace labels can change the AWS account of the namespace's ServiceAccounts, and its
+	// annotations whether they are managed
+	namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: controller.handleNamespaceUpdate,
+	})
+
+	roleResourceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    controller.enqueueRoleResource,
+		UpdateFunc: controller.handleRoleResourceUpdate,
+		DeleteFunc: controller.enqueueRoleResource,
 	})
 
 	return controller
@@ -99,7 +276,14 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	// Wait for the caches to be synced before starting workers
 	klog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.serviceAccountsSynced); !ok {
+	if ok := cache.WaitForCacheSync(
+		stopCh,
+		c.serviceAccountsSynced,
+		c.configMapsSynced,
+		c.namespaceConfigMapsSynced,
+		c.namespacesSynced,
+		c.roleResourcesSynced,
+	); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -184,6 +368,22 @@ func (c *Controller) processNextWorkItem() bool {
 func (c *Controller) syncHandler(serviceAccountKey string) error {
 	klog.Infof("Syncing %s\n", serviceAccountKey)
 
+	// Role groups have keys of their own
+	if group, ok := parseRoleGroupKey(serviceAccountKey); ok {
+		return c.syncRoleGroup(group)
+	}
+	// And so do IAMServiceAccountRoles
+	if key, ok := parseRoleResourceKey(serviceAccountKey); ok {
+		return c.syncRoleResource(key)
+	}
+	// And ServiceAccounts which stopped being managed, or referencing their role
+	if key, ok := parseUnmanagedKey(serviceAccountKey); ok {
+		return c.syncUnmanaged(key)
+	}
+	if key, ok := parseRoleARNKey(serviceAccountKey); ok {
+		return c.syncRoleARN(key)
+	}
+
 	// Convert the namespace/name string into a distinct namespace and name
 	namespace, name, err := cache.SplitMetaNamespaceKey(serviceAccountKey)
 	if err != nil {
@@ -191,6 +391,11 @@ func (c *Controller) syncHandler(serviceAccountKey string) error {
 		return nil
 	}
 
+	// Keys without a namespace are namespaces, for their shared role
+	if namespace == "" {
+		return c.syncNamespaceRole(name)
+	}
+
 	// name and namespace are input from outside our trust boundary and we use them in a few
 	// sensitive places including when constructing access policies.
 	// We make sure they don't contain any sneaky characters here.
@@ -205,68 +410,544 @@ func (c *Controller) syncHandler(serviceAccountKey string) error {
 
 	// Get the ServiceAccount resource with this namespace/name.
 	sa, err := c.serviceAccountsLister.ServiceAccounts(namespace).Get(name)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		// Requeue to try again
+		return err
+	}
+
+	// An IAMServiceAccountRole declaring the ServiceAccount's role takes over from its annotations
+	r, err := c.roleResourceFor(namespace, name)
 	if err != nil {
-		// The ServiceAccount no longer exists (i.e. it's been deleted from the cluster).
-		// We ensure its IAM Role is removed from AWS.
-		if k8serrors.IsNotFound(err) {
+		return err
+	}
+	if r != nil {
+		c.workqueue.Add(roleResourceKey(r))
+		return nil
+	}
+
+	cfg, err := c.loadAdminConfig()
+	if err != nil {
+		if sa != nil {
+			message := fmt.Sprintf(MessageAdminConfigInvalid, err.Error())
+			c.recorder.Event(sa, corev1.EventTypeWarning, SyncFailed, message)
+			c.writeStatus(sa, StatusFailed, message, nil)
+		}
+		return err
+	}
+
+	// ServiceAccounts which want a role but can't have one are told why, and those which don't
+	// want one are left alone
+	if sa != nil {
+		ns, err := c.namespace(namespace)
+		if err != nil {
+			return err
+		}
+		wanted, refusal, err := c.checkRole(sa, ns, cfg)
+		if err != nil {
+			return err
+		}
+		if refusal != nil {
+			c.reportRefusal(sa, refusal)
+			return nil
+		}
+		if !wanted {
+			return nil
+		}
+	}
+
+	// ServiceAccounts in a role group, or of namespaces with a shared role, don't have their own
+	if group, inGroup, _ := roleGroupOf(sa); sa != nil && inGroup {
+		c.workqueue.Add(roleGroupKey(group))
+		return nil
+	}
+	if cfg.hasNamespaceRole(namespace) {
+		c.workqueue.Add(namespaceRoleKey(namespace))
+		return nil
+	}
+
+	// The IAM Role lives in the AWS account the namespace is mapped to
+	manager, err := c.managerFor(namespace, cfg)
+	if err != nil {
+		if sa != nil {
+			message := fmt.Sprintf(MessageAccountUnavailable, err.Error())
+			c.recorder.Event(sa, corev1.EventTypeWarning, SyncFailed, message)
+			c.writeStatus(sa, StatusFailed, message, nil)
+		}
+		return err
+	}
+
+	// The ServiceAccount no longer exists (i.e. it's been deleted from the cluster).
+	// We ensure its IAM Role is removed from AWS.
+	if sa == nil {
+		c.recorder.Forget(&corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		})
+		// Observing only reports on the role, which may have been created in another mode
+		if c.observed.pop(serviceAccountKey) {
 			klog.Infof(
-				"ServiceAccount '%s' no longer exists, will delete its IAM Role",
+				"Observed ServiceAccount '%s' no longer exists, leaving its IAM Role alone",
 				serviceAccountKey,
 			)
-			if err := c.iam.DeleteRole(name, namespace); err != nil {
+			return nil
+		}
+		klog.Infof(
+			"ServiceAccount '%s' no longer exists, will delete its IAM Role",
+			serviceAccountKey,
+		)
+		// Roles which aren't managed, e.g. those the ServiceAccount only observed, or owned by
+		// another cluster are left alone
+		err := manager.DeleteRole(name, namespace)
+		if err := ignoreForeignRole(err, serviceAccountKey); err != nil {
+			return err
+		}
+		// and so is its role before a migration, which would otherwise never be deleted
+		if migrateRoles {
+			previous := manager.WithNaming(previousRolePrefix, previousClusterName)
+			err := previous.DeleteRole(name, namespace)
+			if err := ignoreForeignRole(err, serviceAccountKey); err != nil {
 				return err
 			}
-			return nil
 		}
-		// Requeue to try again
+		return nil
+	}
+
+	// The managed annotation of the ServiceAccount, or of its namespace, sets how its role is
+	// managed
+	req := serviceAccountRequest(sa)
+	ns, err := c.namespace(namespace)
+	if err != nil {
+		return err
+	}
+	if mode, managed := managementModeOf(sa, ns); managed {
+		req.mode = mode
+	}
+	c.observed.set(serviceAccountKey, req.mode == modeObserve)
+	if req.mode == modeObserve {
+		return c.observeRole(manager, sa)
+	}
+	if err := c.migrateRole(manager, sa); err != nil {
+		c.writeStatus(sa, StatusFailed, err.Error(), nil)
 		return err
 	}
 
-	role, err := c.iam.GetRole(name, namespace)
+	role, err := c.syncRole(manager, req, cfg)
+	switch {
+	case err != nil:
+		c.writeStatus(sa, StatusFailed, err.Error(), nil)
+		return err
+	case role == nil:
+		c.writeStatus(sa, StatusUnmanaged, req.unmanaged, nil)
+		return nil
+	}
+
+	// Only ServiceAccounts managed through their namespace get here without the role-arn
+	// annotation, we set it so their pods can assume the role. Those whose role was migrated get
+	// the new ARN.
+	val, ok := sa.ObjectMeta.Annotations[roleAnnotationKey]
+	if !ok || (val != *role.Arn && c.isPreviousRoleARN(sa, cfg, val)) {
+		if err := c.annotateRoleARN(sa, *role.Arn); err != nil {
+			message := fmt.Sprintf(MessageAnnotationFailed, err.Error())
+			c.recorder.Event(sa, corev1.EventTypeWarning, SyncFailed, message)
+			c.writeStatus(sa, StatusFailed, message, nil)
+			return err
+		}
+	}
+
+	c.writeStatus(sa, StatusSynced, "", role)
+	c.recorder.Event(sa, corev1.EventTypeNormal, SyncSuccess, MessageResourceSynced)
+	return nil
+}
+
+// syncRole converges the IAM Role of the ServiceAccount with the request, in the AWS account of
+// manager, and returns it. It returns nil, without changing anything, if the role exists but isn't
+// managed by the controller.
+func (c *Controller) syncRole(
+	manager *iam.Manager,
+	req *roleRequest,
+	cfg *adminConfig,
+) (*awstypes.Role, error) {
+	namespaceLabels, err := c.namespaceLabels(req.namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record what the sync changes, to report updates of the role
+	manager, changes := manager.Tracked()
+
+	role, err := manager.GetRole(req.name, req.namespace)
+	if err != nil && !iamerrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	// The user's tags share the role with the controller's, which depend on the role
+	spec, refused := roleSpec(req, namespaceLabels, manager.ControllerTags(role), cfg)
+	if len(refused) > 0 {
+		c.refuse(
+			req,
+			RoleMetadataRefused,
+			fmt.Sprintf(MessageRoleMetadataRefused, strings.Join(refused, "; ")),
+		)
+	}
+
+	// Roles in create-only mode are only tagged with the mode once their first sync succeeded, so
+	// they're synced again until they have their permissions
+	if req.mode == modeCreateOnly {
+		spec.Mode = ""
+	}
+
+	created, adopted := false, false
 	switch {
 	case err == nil:
-		// The role already exists, check if it's managed by us
-		if c.iam.IsManaged(role) {
-			c.recorder.Event(sa, corev1.EventTypeNormal, SyncSuccess, MessageResourceSynced)
-		} else {
-			c.recorder.Event(sa, corev1.EventTypeWarning, SyncWarning, MessageUnmanagedRole)
+		// The role already exists, check if it's managed by us, or should be
+		if !manager.IsManaged(role) {
+			if role, err = c.adoptRole(manager, req, role, spec, cfg); err != nil || role == nil {
+				return nil, err
+			}
+			adopted = true
+		}
+		// Roles with the same name managed for another cluster, e.g. sharing the AWS account and
+		// role prefix, are never updated
+		if err := manager.CheckOwnership(role, req.name, req.namespace); err != nil {
+			if !iamerrors.IsNotOwned(err) {
+				return nil, err
+			}
+			req.unmanaged = notOwnedMessage(role, err)
+			c.recorder.Event(req.object, corev1.EventTypeWarning, RoleNotOwned, req.unmanaged)
+			return nil, nil
+		}
+		// Roles in create-only mode are never updated once created, only tagged with the mode so
+		// they outlive their ServiceAccount
+		if isCreated(req.mode, role) {
+			if err := c.syncCreateOnlyMode(manager, req, role); err != nil {
+				return nil, err
+			}
+			return role, nil
+		}
+		if err := c.syncTrustPolicy(manager, req, role, spec.Audience); err != nil {
+			c.recorder.Event(
+				req.object,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				fmt.Sprintf(MessageTrustPolicySyncFailed, err.Error()),
+			)
+			return nil, err
+		}
+		err := manager.SyncRoleMetadata(role, spec, cfg.RoleMetadata.managedTag)
+		if err != nil {
+			c.recorder.Event(
+				req.object,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				fmt.Sprintf(MessageRoleMetadataSyncFailed, err.Error()),
+			)
+			return nil, err
 		}
 
 	case iamerrors.IsNotFound(err):
 		// The role doesn't exist yet, we need to create it
-		klog.Infof("No IAM Role for '%s'; creating it", serviceAccountKey)
-		if err := c.iam.CreateRole(name, namespace); err != nil {
+		klog.Infof("No IAM Role for '%s/%s'; creating it", req.namespace, req.name)
+		if err := manager.CreateRole(req.name, req.namespace, spec); err != nil {
 			// Failed to create the role for some reason
 			// We log an error event and requeue
 			c.recorder.Event(
-				sa,
+				req.object,
 				corev1.EventTypeWarning,
 				SyncFailed,
 				fmt.Sprintf(MessageRoleCreationFailed, err.Error()),
 			)
-			return err
+			return nil, err
 		}
-		c.recorder.Event(sa, corev1.EventTypeNormal, SyncSuccess, MessageResourceSynced)
-		return nil
+		created = true
+		// The role's ID is only known once it exists
+		if role, err = manager.GetRole(req.name, req.namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.syncPermissions(manager, req, cfg); err != nil {
+		c.recorder.Event(
+			req.object,
+			corev1.EventTypeWarning,
+			SyncFailed,
+			fmt.Sprintf(MessagePermissionsSyncFailed, err.Error()),
+		)
+		return nil, err
+	}
+	if req.mode == modeCreateOnly {
+		if err := c.syncCreateOnlyMode(manager, req, role); err != nil {
+			return nil, err
+		}
+	}
 
-	default:
-		// Some other error we can't handle now, requeue
+	// Permissions of a new role are part of its creation, and so are the updates of an adopted
+	// role part of its adoption
+	switch {
+	case created:
+		c.recorder.Event(
+			req.object,
+			corev1.EventTypeNormal,
+			RoleCreated,
+			fmt.Sprintf(MessageRoleCreated, *role.Arn),
+		)
+	case adopted:
+		c.recorder.Event(
+			req.object,
+			corev1.EventTypeNormal,
+			RoleAdopted,
+			fmt.Sprintf(MessageRoleAdopted, *role.Arn),
+		)
+	case len(changes.Actions()) > 0:
+		c.recorder.Event(
+			req.object,
+			corev1.EventTypeNormal,
+			RoleUpdated,
+			fmt.Sprintf(MessageRoleUpdated, strings.Join(changes.Actions(), ", ")),
+		)
+	}
+	return role, nil
+}
+
+// syncTrustPolicy converges the trust policy of the ServiceAccount's existing IAM Role, and reports
+// the rotation of the cluster's OIDC provider on the role.
+func (c *Controller) syncTrustPolicy(
+	manager *iam.Manager,
+	req *roleRequest,
+	role *awstypes.Role,
+	audience string,
+) error {
+	stale, err := manager.StaleOIDCProviders(role)
+	if err != nil {
 		return err
 	}
 
+	if err := manager.SyncTrustPolicy(role, req.name, req.namespace, audience); err != nil {
+		return err
+	}
+
+	var removed, kept []string
+	for _, provider := range stale {
+		if manager.IsPreviousOIDCProvider(provider) {
+			kept = append(kept, provider)
+		} else {
+			removed = append(removed, provider)
+		}
+	}
+
+	if len(kept) > 0 {
+		klog.Infof(
+			"IAM Role '%s' still trusts previous OIDC providers during rotation: %s",
+			*role.RoleName,
+			strings.Join(kept, ", "),
+		)
+	}
+	if len(removed) > 0 {
+		klog.Infof(
+			"IAM Role '%s' rotated to OIDC provider '%s', removed: %s",
+			*role.RoleName,
+			oidcProvider,
+			strings.Join(removed, ", "),
+		)
+		c.recorder.Event(
+			req.object,
+			corev1.EventTypeNormal,
+			OIDCProviderRotated,
+			fmt.Sprintf(MessageOIDCProviderRotated, oidcProvider, strings.Join(removed, ", ")),
+		)
+	}
+
 	return nil
 }
 
+// handleConfigMap enqueues every ServiceAccount when the admin ConfigMap changes, so the new
+// configuration is applied to all the IAM Roles without waiting for the next resync.
+func (c *Controller) handleConfigMap(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if cm, ok := obj.(*corev1.ConfigMap); !ok || cm.ObjectMeta.Name != adminConfigMapName {
+		return
+	}
+
+	c.enqueueAllServiceAccounts()
+	c.enqueueAllNamespaceRoles()
+	c.enqueueAllRoleResources(metav1.NamespaceAll)
+}
+
+// handleNamespaceConfigMap enqueues what reads a ConfigMap of a namespace when it changes: the
+// ServiceAccounts whose inline policy it holds, or the role group it grants joining.
+func (c *Controller) handleNamespaceConfigMap(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+	c.enqueueInlinePolicyUsers(cm)
+	c.enqueueGrantedRoleGroup(cm)
+}
+
+// handleNamespaceUpdate enqueues the ServiceAccounts of a namespace when its labels change, as they
+// can map it to another AWS account or change the tags of its roles, or when it starts or stops
+// managing its ServiceAccounts.
+func (c *Controller) handleNamespaceUpdate(old, new interface{}) {
+	oldNamespace := old.(*corev1.Namespace)
+	newNamespace := new.(*corev1.Namespace)
+	if reflect.DeepEqual(oldNamespace.ObjectMeta.Labels, newNamespace.ObjectMeta.Labels) &&
+		!namespaceManagementChanged(oldNamespace, newNamespace) {
+		return
+	}
+
+	serviceAccounts, err := c.serviceAccountsLister.ServiceAccounts(
+		newNamespace.ObjectMeta.Name,
+	).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, sa := range serviceAccounts {
+		c.enqueueServiceAccount(sa)
+		c.enqueueUnmanaged(sa, sa, oldNamespace, newNamespace)
+	}
+	c.enqueueAllRoleResources(newNamespace.ObjectMeta.Name)
+}
+
+// enqueueAllServiceAccounts puts every managed ServiceAccount onto the work queue.
+func (c *Controller) enqueueAllServiceAccounts() {
+	serviceAccounts, err := c.serviceAccountsLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, sa := range serviceAccounts {
+		c.enqueueServiceAccount(sa)
+	}
+}
+
 // enqueueServiceAccount takes a ServiceAccount resource and converts it into a namespace/name
 // string which is then put onto the work queue. It first checks the ServiceAccount's annotations to
-// see if this SA should be managed by this controller.
+// see if this SA should be managed by this controller, and the admin configuration to see if it
+// is eligible. ServiceAccounts in a role group enqueue their group instead, and those of namespaces
+// with a shared role their namespace.
 func (c *Controller) enqueueServiceAccount(obj interface{}) {
 	var sa *corev1.ServiceAccount = obj.(*corev1.ServiceAccount)
 
-	// Don't proceed if this doesn't have annotation indicating it's managed by this controller
-	if val, ok := sa.ObjectMeta.Annotations[managedAnnotationKey]; !ok || val != "true" {
+	// ServiceAccounts with an IAMServiceAccountRole are managed through it, whatever their
+	// annotations say
+	r, err := c.roleResourceFor(sa.ObjectMeta.Namespace, sa.ObjectMeta.Name)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	if r != nil {
+		c.workqueue.Add(roleResourceKey(r))
+		return
+	}
+
+	ns, err := c.namespace(sa.ObjectMeta.Namespace)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	cfg, err := c.loadAdminConfig()
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	wanted, refusal, err := c.checkRole(sa, ns, cfg)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	// The ServiceAccount's sync reports why it can't have a role
+	if refusal != nil {
+		c.workqueue.Add(key)
+		return
+	}
+	if !wanted {
+		return
+	}
+
+	// checkRole refuses ServiceAccounts with an invalid role group
+	if group, inGroup, _ := roleGroupOf(sa); inGroup {
+		c.workqueue.Add(roleGroupKey(group))
+		return
+	}
+	if cfg.hasNamespaceRole(sa.ObjectMeta.Namespace) {
+		c.workqueue.Add(namespaceRoleKey(sa.ObjectMeta.Namespace))
 		return
 	}
+	c.workqueue.Add(key)
+}
+
+// roleRefusal is why a ServiceAccount which wants to be managed by the controller can't have a
+// role, see reportRefusal.
+type roleRefusal struct {
+	reason  string
+	message string
+	// status is the status written on the ServiceAccount, none if empty
+	status string
+}
+
+// wantsRole returns true if the ServiceAccount should have a role managed by the controller. It
+// doesn't report anything, so it can be called from event handlers and for every member of a
+// shared role.
+func (c *Controller) wantsRole(
+	sa *corev1.ServiceAccount,
+	ns *corev1.Namespace,
+	cfg *adminConfig,
+) (bool, error) {
+	wanted, _, err := c.checkRole(sa, ns, cfg)
+	return wanted, err
+}
+
+// checkRole returns true if the ServiceAccount should have a role managed by the controller.
+// Otherwise it returns why, if the ServiceAccount wants to be managed but can't.
+func (c *Controller) checkRole(
+	sa *corev1.ServiceAccount,
+	ns *corev1.Namespace,
+	cfg *adminConfig,
+) (bool, *roleRefusal, error) {
+	// Don't proceed if neither the ServiceAccount nor its namespace have the annotation
+	// indicating it's managed by this controller
+	mode, managed := managementModeOf(sa, ns)
+	if !managed {
+		return false, nil, nil
+	}
+
+	// ServiceAccounts with an IAMServiceAccountRole have the role it declares instead
+	r, err := c.roleResourceFor(sa.ObjectMeta.Namespace, sa.ObjectMeta.Name)
+	if err != nil || r != nil {
+		return false, nil, err
+	}
+
+	// Admins decide which namespaces and ServiceAccounts can have a role at all
+	var namespaceLabels map[string]string
+	if ns != nil {
+		namespaceLabels = ns.ObjectMeta.Labels
+	}
+	reason := cfg.Eligibility.refusal(sa.ObjectMeta.Name, sa.ObjectMeta.Namespace, namespaceLabels)
+	if reason != "" {
+		return false, &roleRefusal{
+			reason:  ServiceAccountNotEligible,
+			message: fmt.Sprintf(MessageServiceAccountNotEligible, reason),
+			status:  StatusNotEligible,
+		}, nil
+	}
+
+	_, inGroup, err := roleGroupOf(sa)
+	if err != nil {
+		return false, &roleRefusal{
+			reason:  RoleGroupRefused,
+			message: fmt.Sprintf(MessageRoleGroupRefused, err.Error()),
+		}, nil
+	}
 
 	// We only treat ServiceAccounts that have an annotation of the form:
 	//     eks.amazonaws.com/role-arn: arn:aws:iam::<ACCOUNT_ID>:role/<IAM_ROLE_NAME>
@@ -274,38 +955,59 @@ func (c *Controller) enqueueServiceAccount(obj interface{}) {
 	// We also have a strict naming convention for the IAM_ROLE_NAME. If the IAM_ROLE_NAME in this
 	// ServiceAccount's annotation doesn't match
 	//     (prefix_)namespace_name
-	// then we ignore log an warning and ignore the event.
-	if val, ok := sa.ObjectMeta.Annotations[roleAnnotationKey]; ok {
-		if val != c.iam.MakeRoleARN(
-			sa.ObjectMeta.Name,
-			sa.ObjectMeta.Namespace,
-		) {
-			klog.Infof(
-				"ServiceAccount '%s/%s' wants to be managed by controller but ARN doesn't match spec",
-				sa.ObjectMeta.Namespace,
-				sa.ObjectMeta.Name,
-			)
-			c.recorder.Event(sa, corev1.EventTypeWarning, SyncWarning, MessageMisconfiguredARN)
-			return
-		}
+	// the role of its role group, or (prefix_)namespace for namespaces with a shared role, then we
+	// ignore log an warning and ignore the event.
+	//
+	// ServiceAccounts managed through their namespace, or using a shared role, don't need the
+	// annotation, the controller sets it once their role exists. Nor do those only observing
+	// their role.
+	val, ok := sa.ObjectMeta.Annotations[roleAnnotationKey]
+	if !ok {
+		return inGroup ||
+			isManagedByNamespace(sa, ns) ||
+			cfg.hasNamespaceRole(sa.ObjectMeta.Namespace) ||
+			mode == modeObserve, nil, nil
+	}
 
-		var key string
-		var err error
-		if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
-			utilruntime.HandleError(err)
-			return
-		}
-		c.workqueue.Add(key)
+	// The role lives in the AWS account the namespace is mapped to
+	expectedARN, err := c.expectedRoleARN(sa, cfg)
+	if err != nil {
+		return false, nil, err
+	}
+	if val != expectedARN && !c.isPreviousRoleARN(sa, cfg, val) {
+		return false, &roleRefusal{
+			reason: SyncWarning,
+			message: fmt.Sprintf(
+				MessageMisconfiguredARN,
+				roleAnnotationKey,
+				val,
+				expectedARN,
+				managedAnnotationKey,
+			),
+			status: StatusMisconfigured,
+		}, nil
+	}
+	return true, nil, nil
+}
+
+// reportRefusal tells a ServiceAccount which wants to be managed by the controller why it can't
+// have a role, in a warning event and its status. Only syncHandler reports refusals, once per
+// sync of the ServiceAccount.
+func (c *Controller) reportRefusal(sa *corev1.ServiceAccount, refusal *roleRefusal) {
+	klog.Infof(
+		"ServiceAccount '%s/%s' wants to be managed by controller but can't: %s",
+		sa.ObjectMeta.Namespace,
+		sa.ObjectMeta.Name,
+		refusal.message,
+	)
+	c.recorder.Event(sa, corev1.EventTypeWarning, refusal.reason, refusal.message)
+	if refusal.status != "" {
+		c.writeStatus(sa, refusal.status, refusal.message, nil)
 	}
 }
 
 // validateUserInput takes a user input string and returns true if the input is acceptable from a
 // security point of view.
 func isValidUserInput(input string) bool {
-	var isValidString = regexp.MustCompile(`^[1-9a-z-]+$`).MatchString
-
-	if isValidString(input) {
-		return true
-	}
-	return false
+	return iam.IsValidUserInput(input)
 }
@@ -2,7 +2,17 @@ package main
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
+
+	securityv1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/apis/security/v1alpha1"
+	securitylisters "github.com/ovotech/iam-service-account-controller/pkg/generated/listers/security/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 func TestIsValidUserInput(t *testing.T) {
@@ -25,3 +35,82 @@ func TestIsValidUserInput(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleNamespaceConfigMap(t *testing.T) {
+	indexer := func() cache.Indexer {
+		return cache.NewIndexer(
+			cache.MetaNamespaceKeyFunc,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		)
+	}
+	resources := indexer()
+	for _, r := range []*securityv1alpha1.IAMServiceAccountRole{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "bar"},
+			Spec: securityv1alpha1.IAMServiceAccountRoleSpec{
+				ServiceAccountName:    "app",
+				InlinePolicyConfigMap: "app-policy",
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "bar"},
+			Spec:       securityv1alpha1.IAMServiceAccountRoleSpec{ServiceAccountName: "other"},
+		},
+	} {
+		if err := resources.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		name string
+		cm   *corev1.ConfigMap
+		want []string
+	}{
+		{
+			"inline policy",
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "app-policy", Namespace: "bar"}},
+			[]string{"iamserviceaccountrole:bar/app"},
+		},
+		{
+			"role group grant",
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "jobs-grant",
+					Namespace: "bar",
+					Labels:    map[string]string{roleGroupGrantLabelKey: "jobs"},
+				},
+			},
+			[]string{"rolegroup:bar/jobs"},
+		},
+		{
+			"unrelated",
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "bar"}},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{
+				serviceAccountsLister: corelisters.NewServiceAccountLister(indexer()),
+				roleResourcesLister:   securitylisters.NewIAMServiceAccountRoleLister(resources),
+				workqueue: workqueue.NewRateLimitingQueue(
+					workqueue.DefaultControllerRateLimiter(),
+				),
+			}
+			defer c.workqueue.ShutDown()
+
+			c.handleNamespaceConfigMap(cache.DeletedFinalStateUnknown{Obj: tt.cm})
+			var got []string
+			for c.workqueue.Len() > 0 {
+				key, _ := c.workqueue.Get()
+				got = append(got, key.(string))
+				c.workqueue.Done(key)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
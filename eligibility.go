@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// eligibility is the admin-defined rules of which ServiceAccounts can have a role managed by the
+// controller. Every ServiceAccount is eligible if there are no rules.
+type eligibility struct {
+	// NamespaceSelector selects the namespaces by label whose ServiceAccounts are eligible.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
+	// Namespaces are the wildcard patterns of the namespaces whose ServiceAccounts are eligible,
+	// e.g. "team-*". All namespaces are allowed if empty.
+	Namespaces []string `json:"namespaces"`
+	// ExcludedNamespaces are the wildcard patterns of the namespaces whose ServiceAccounts are
+	// never eligible, e.g. "kube-*".
+	ExcludedNamespaces []string `json:"excludedNamespaces"`
+	// ServiceAccounts are the wildcard patterns of the names of the eligible ServiceAccounts. All
+	// names are allowed if empty.
+	ServiceAccounts []string `json:"serviceAccounts"`
+	// ExcludedServiceAccounts are the wildcard patterns of the names of the ServiceAccounts which
+	// are never eligible, e.g. "default".
+	ExcludedServiceAccounts []string `json:"excludedServiceAccounts"`
+}
+
+// validate returns an error if the rules can't be evaluated.
+func (e *eligibility) validate() error {
+	if e.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(e.NamespaceSelector); err != nil {
+			return fmt.Errorf("invalid namespaceSelector: %s", err)
+		}
+	}
+	return nil
+}
+
+// refusal returns why the ServiceAccount namespace/name isn't eligible for a managed role, or an
+// empty string if it is. namespaceLabels may be nil if the namespace doesn't exist.
+func (e *eligibility) refusal(
+	name string,
+	namespace string,
+	namespaceLabels map[string]string,
+) string {
+	switch {
+	case matchesAnyWildcard(e.ExcludedNamespaces, namespace):
+		return fmt.Sprintf("namespace '%s' is excluded", namespace)
+	case len(e.Namespaces) > 0 && !matchesAnyWildcard(e.Namespaces, namespace):
+		return fmt.Sprintf("namespace '%s' is not in the allowed namespaces", namespace)
+	case matchesAnyWildcard(e.ExcludedServiceAccounts, name):
+		return fmt.Sprintf("ServiceAccount name '%s' is excluded", name)
+	case len(e.ServiceAccounts) > 0 && !matchesAnyWildcard(e.ServiceAccounts, name):
+		return fmt.Sprintf("ServiceAccount name '%s' is not in the allowed names", name)
+	}
+
+	if e.NamespaceSelector != nil {
+		// the selector was validated when parsing the config
+		selector, _ := metav1.LabelSelectorAsSelector(e.NamespaceSelector)
+		if !selector.Matches(labels.Set(namespaceLabels)) {
+			return fmt.Sprintf(
+				"namespace '%s' doesn't match the namespace selector '%s'",
+				namespace,
+				selector,
+			)
+		}
+	}
+	return ""
+}
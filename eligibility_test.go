@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	securitylisters "github.com/ovotech/iam-service-account-controller/pkg/generated/listers/security/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestEligibilityRefusal(t *testing.T) {
+	var tests = []struct {
+		rules           eligibility
+		name            string
+		namespace       string
+		namespaceLabels map[string]string
+		wantRefused     bool
+	}{
+		{eligibility{}, "default", "kube-system", nil, false},
+		{eligibility{ExcludedNamespaces: []string{"kube-*"}}, "app", "kube-system", nil, true},
+		{eligibility{ExcludedNamespaces: []string{"kube-*"}}, "app", "team-a", nil, false},
+		{eligibility{Namespaces: []string{"team-*"}}, "app", "team-a", nil, false},
+		{eligibility{Namespaces: []string{"team-*"}}, "app", "tenant-a", nil, true},
+		{eligibility{ExcludedServiceAccounts: []string{"default"}}, "default", "team-a", nil, true},
+		{eligibility{ServiceAccounts: []string{"app-*"}}, "app-web", "team-a", nil, false},
+		{eligibility{ServiceAccounts: []string{"app-*"}}, "builder", "team-a", nil, true},
+		{
+			eligibility{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"iam.k8s.aws/enabled": "true"},
+				},
+			},
+			"app",
+			"team-a",
+			map[string]string{"iam.k8s.aws/enabled": "true"},
+			false,
+		},
+		{
+			eligibility{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"iam.k8s.aws/enabled": "true"},
+				},
+			},
+			"app",
+			"team-a",
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%s,%v,%t", tt.name, tt.namespace, tt.namespaceLabels, tt.wantRefused)
+		t.Run(testname, func(t *testing.T) {
+			reason := tt.rules.refusal(tt.name, tt.namespace, tt.namespaceLabels)
+			if (reason != "") != tt.wantRefused {
+				t.Errorf("got refusal %q, want refused %t", reason, tt.wantRefused)
+			}
+		})
+	}
+}
+
+func TestCheckRole(t *testing.T) {
+	// A controller without a recorder nor a client, so reporting anything would panic
+	c := &Controller{
+		roleResourcesLister: securitylisters.NewIAMServiceAccountRoleLister(
+			cache.NewIndexer(
+				cache.MetaNamespaceKeyFunc,
+				cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+			),
+		),
+	}
+	managedNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "bar",
+			Annotations: map[string]string{managedAnnotationKey: "true"},
+		},
+	}
+
+	var tests = []struct {
+		name        string
+		annotations map[string]string
+		cfg         *adminConfig
+		wantRole    bool
+		wantRefusal string
+	}{
+		{"managed", nil, &adminConfig{}, true, ""},
+		{
+			"not eligible",
+			nil,
+			&adminConfig{Eligibility: eligibility{ExcludedNamespaces: []string{"bar"}}},
+			false,
+			ServiceAccountNotEligible,
+		},
+		{
+			"invalid role group",
+			map[string]string{roleGroupAnnotationKey: "Not_Valid"},
+			&adminConfig{},
+			false,
+			RoleGroupRefused,
+		},
+		{"not managed", map[string]string{managedAnnotationKey: "false"}, &adminConfig{}, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "app",
+					Namespace:   "bar",
+					Annotations: tt.annotations,
+				},
+			}
+			wanted, refusal, err := c.checkRole(sa, managedNamespace, tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wanted != tt.wantRole {
+				t.Errorf("got wanted %t, want %t", wanted, tt.wantRole)
+			}
+			switch {
+			case refusal == nil && tt.wantRefusal != "":
+				t.Errorf("got no refusal, want %s", tt.wantRefusal)
+			case refusal != nil && refusal.reason != tt.wantRefusal:
+				t.Errorf("got refusal %+v, want %s", refusal, tt.wantRefusal)
+			}
+		})
+	}
+}
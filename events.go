@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// outcomeReasons are the reasons of the events ending the sync of an object, successfully or not.
+var outcomeReasons = map[string]bool{
+	SyncSuccess:               true,
+	SyncFailed:                true,
+	SyncWarning:               true,
+	ServiceAccountNotEligible: true,
+	RoleGroupRefused:          true,
+	RoleResourceConflict:      true,
+	ServiceAccountChanged:     true,
+	RoleResourceShared:        true,
+	RoleObserved:              true,
+	AdoptionRefused:           true,
+	RoleNotOwned:              true,
+}
+
+// changeReasons are the reasons of the events reporting a change the controller made, which are
+// always recorded.
+var changeReasons = map[string]bool{
+	RoleCreated:         true,
+	RoleUpdated:         true,
+	RoleAdopted:         true,
+	RoleDeleted:         true,
+	RoleReleased:        true,
+	RoleDetached:        true,
+	RoleARNRestored:     true,
+	RoleARNChanged:      true,
+	RoleMigrated:        true,
+	PreviousRoleDeleted: true,
+	OIDCProviderRotated: true,
+}
+
+// requestIDPattern matches the request IDs the AWS SDK puts in the messages of its errors, which
+// are different for every request.
+var requestIDPattern = regexp.MustCompile(`RequestID: [^,\s]*, `)
+
+// withoutRequestIDs returns the message without the AWS request IDs of the errors it quotes, so
+// the same error in different syncs gives the same message.
+func withoutRequestIDs(message string) string {
+	return requestIDPattern.ReplaceAllString(message, "")
+}
+
+// transitionRecorder records the events of an object only when they change, so periodic syncs of
+// an object whose state doesn't change don't record the same events again and push useful events
+// out. The events of an object's sync are compared with those of its previous sync, which ended
+// with an outcome event (see outcomeReasons).
+type transitionRecorder struct {
+	record.EventRecorder
+	lock sync.Mutex
+	// last holds the events of the previous sync of each object, and pending those of its ongoing
+	// sync
+	last    map[string]map[string]bool
+	pending map[string]map[string]bool
+}
+
+func newTransitionRecorder(recorder record.EventRecorder) *transitionRecorder {
+	return &transitionRecorder{
+		EventRecorder: recorder,
+		last:          map[string]map[string]bool{},
+		pending:       map[string]map[string]bool{},
+	}
+}
+
+// Event records the event unless the previous sync of the object recorded it too.
+func (r *transitionRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.transition(eventObjectKey(object), eventtype, reason, message) {
+		r.EventRecorder.Event(object, eventtype, reason, message)
+	}
+}
+
+// transition returns true if the event wasn't recorded by the previous sync of the object. Events
+// whose messages only differ by AWS request IDs are the same.
+func (r *transitionRecorder) transition(objectKey, eventtype, reason, message string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	event := eventtype + "/" + reason + "/" + withoutRequestIDs(message)
+	if r.pending[objectKey] == nil {
+		r.pending[objectKey] = map[string]bool{}
+	}
+	r.pending[objectKey][event] = true
+	changed := changeReasons[reason] || !r.last[objectKey][event]
+
+	if outcomeReasons[reason] {
+		r.last[objectKey] = r.pending[objectKey]
+		delete(r.pending, objectKey)
+	}
+	return changed
+}
+
+// Forget drops what the recorder remembers of an object which was deleted.
+func (r *transitionRecorder) Forget(object runtime.Object) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	objectKey := eventObjectKey(object)
+	delete(r.last, objectKey)
+	delete(r.pending, objectKey)
+}
+
+// eventObjectKey identifies the object of an event by its type, namespace and name.
+func eventObjectKey(object runtime.Object) string {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return fmt.Sprintf("%T", object)
+	}
+	return fmt.Sprintf("%T/%s/%s", object, accessor.GetNamespace(), accessor.GetName())
+}
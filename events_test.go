@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestTransitionRecorder(t *testing.T) {
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "bar", Namespace: "foo"}}
+	other := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "baz", Namespace: "foo"}}
+
+	type event struct {
+		object    *corev1.ServiceAccount
+		eventtype string
+		reason    string
+		message   string
+		want      bool
+	}
+	syncs := [][]event{
+		// first sync, everything is new
+		{
+			{sa, corev1.EventTypeWarning, PolicyGrantRefused, "s3", true},
+			{sa, corev1.EventTypeNormal, RoleCreated, "created", true},
+			{sa, corev1.EventTypeNormal, SyncSuccess, MessageResourceSynced, true},
+		},
+		// nothing changed
+		{
+			{sa, corev1.EventTypeWarning, PolicyGrantRefused, "s3", false},
+			{sa, corev1.EventTypeNormal, SyncSuccess, MessageResourceSynced, false},
+		},
+		// the role was updated, which is always recorded, and another object synced
+		{
+			{sa, corev1.EventTypeWarning, PolicyGrantRefused, "s3", false},
+			{other, corev1.EventTypeNormal, SyncSuccess, MessageResourceSynced, true},
+			{sa, corev1.EventTypeNormal, RoleUpdated, "updated", true},
+			{sa, corev1.EventTypeNormal, SyncSuccess, MessageResourceSynced, false},
+		},
+		// the refusal changed, then the sync failed
+		{
+			{sa, corev1.EventTypeWarning, PolicyGrantRefused, "sqs", true},
+			{sa, corev1.EventTypeWarning, SyncFailed, "AccessDenied", true},
+		},
+		// the error changed
+		{
+			{sa, corev1.EventTypeWarning, PolicyGrantRefused, "sqs", false},
+			{
+				sa,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				"StatusCode: 400, RequestID: 0b3f0c56-1e7d-4c4b-9bd1-2f4c9a3b1e01, Throttling",
+				true,
+			},
+		},
+		// the same error, from another request
+		{
+			{sa, corev1.EventTypeWarning, PolicyGrantRefused, "sqs", false},
+			{
+				sa,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				"StatusCode: 400, RequestID: 7c1e2a90-5d3b-4f0e-8a2c-6b9d0e4f3a12, Throttling",
+				false,
+			},
+		},
+		// recovered, the refusal is gone
+		{
+			{sa, corev1.EventTypeNormal, SyncSuccess, MessageResourceSynced, true},
+		},
+		// and back
+		{
+			{sa, corev1.EventTypeWarning, PolicyGrantRefused, "sqs", true},
+			{sa, corev1.EventTypeNormal, SyncSuccess, MessageResourceSynced, false},
+		},
+	}
+
+	fake := record.NewFakeRecorder(100)
+	recorder := newTransitionRecorder(fake)
+	for i, sync := range syncs {
+		for _, e := range sync {
+			recorder.Event(e.object, e.eventtype, e.reason, e.message)
+			var recorded bool
+			select {
+			case <-fake.Events:
+				recorded = true
+			default:
+			}
+			if recorded != e.want {
+				t.Errorf("sync %d: %s %s recorded %t, want %t", i, e.reason, e.message, recorded, e.want)
+			}
+		}
+	}
+
+	// Deleted objects start over
+	recorder.Forget(sa)
+	recorder.Event(sa, corev1.EventTypeNormal, SyncSuccess, MessageResourceSynced)
+	if len(fake.Events) != 1 {
+		t.Errorf("event of a forgotten object not recorded")
+	}
+}
+
+func TestWithoutRequestIDs(t *testing.T) {
+	var tests = []struct {
+		message string
+		want    string
+	}{
+		{MessageResourceSynced, MessageResourceSynced},
+		{
+			"Failed to sync AWS IAM role permissions due to: operation error IAM: GetRole, " +
+				"https response error StatusCode: 403, RequestID: 4f6e9d1c-2b3a-4c5d-8e7f-9a0b1c2d3e4f, " +
+				"api error AccessDenied: denied",
+			"Failed to sync AWS IAM role permissions due to: operation error IAM: GetRole, " +
+				"https response error StatusCode: 403, api error AccessDenied: denied",
+		},
+		{"StatusCode: 500, RequestID: , InternalFailure", "StatusCode: 500, InternalFailure"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			if got := withoutRequestIDs(tt.message); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
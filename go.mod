@@ -15,5 +15,7 @@ require (
 	k8s.io/api v0.21.1
 	k8s.io/apimachinery v0.21.1
 	k8s.io/client-go v0.21.1
+	k8s.io/code-generator v0.21.1
 	k8s.io/klog v1.0.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,7 @@
+//go:build tools
+// +build tools
+
+// Package tools imports the code generators so they're tracked in go.mod.
+package tools
+
+import _ "k8s.io/code-generator"
@@ -0,0 +1,47 @@
+#!/usr/bin/env bash
+
+# Regenerates the deepcopy functions, clientset, listers and informers of the custom resources in
+# pkg/apis. Run from the repository root.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+MODULE=github.com/ovotech/iam-service-account-controller
+APIS=${MODULE}/pkg/apis/security/v1alpha1
+OUTPUT=${MODULE}/pkg/generated
+HEADER=hack/boilerplate.go.txt
+OUTPUT_BASE=$(mktemp -d)
+trap 'rm -rf "${OUTPUT_BASE}"' EXIT
+
+go run k8s.io/code-generator/cmd/deepcopy-gen \
+  --input-dirs "${APIS}" \
+  -O zz_generated.deepcopy \
+  --go-header-file "${HEADER}" \
+  --output-base "${OUTPUT_BASE}"
+
+go run k8s.io/code-generator/cmd/client-gen \
+  --clientset-name versioned \
+  --input-base "" \
+  --input "${APIS}" \
+  --output-package "${OUTPUT}/clientset" \
+  --go-header-file "${HEADER}" \
+  --output-base "${OUTPUT_BASE}"
+
+go run k8s.io/code-generator/cmd/lister-gen \
+  --input-dirs "${APIS}" \
+  --output-package "${OUTPUT}/listers" \
+  --go-header-file "${HEADER}" \
+  --output-base "${OUTPUT_BASE}"
+
+go run k8s.io/code-generator/cmd/informer-gen \
+  --input-dirs "${APIS}" \
+  --versioned-clientset-package "${OUTPUT}/clientset/versioned" \
+  --listers-package "${OUTPUT}/listers" \
+  --output-package "${OUTPUT}/informers" \
+  --go-header-file "${HEADER}" \
+  --output-base "${OUTPUT_BASE}"
+
+cp "${OUTPUT_BASE}/${APIS}/zz_generated.deepcopy.go" pkg/apis/security/v1alpha1/
+rm -rf pkg/generated
+cp -r "${OUTPUT_BASE}/${OUTPUT}" pkg/generated
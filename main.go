@@ -2,10 +2,14 @@ package main
 
 import (
 	"flag"
+	"strings"
+	"text/template"
 	"time"
 
 	kubeinformers "k8s.io/client-go/informers"
 
+	securityclientset "github.com/ovotech/iam-service-account-controller/pkg/generated/clientset/versioned"
+	securityinformers "github.com/ovotech/iam-service-account-controller/pkg/generated/informers/externalversions"
 	"github.com/ovotech/iam-service-account-controller/pkg/iam"
 	"github.com/ovotech/iam-service-account-controller/pkg/signals"
 	"k8s.io/client-go/kubernetes"
@@ -25,15 +29,36 @@ var (
 	awsRegion                string
 	iamRolePrefix            string
 	oidcProvider             string
+	previousOIDCProviders    stringList
+	sharedClusters           stringList
+	createOIDCProvider       bool
+	oidcThumbprints          stringList
+	oidcClientIDs            = stringList{"sts.amazonaws.com"}
 	clusterName              string
 	controllerIAMRoleARN     string
 	controllerWebIdTokenPath string
+	controllerNamespace      string
+	adminConfigMapName       string
+	secretsPath              string
+	secretsKMSKeyARN         string
+	defaultAudience          string
+	unmanagedRolePolicy      string
+	roleARNChangePolicy      string
+	migrateRoles             bool
+	previousRolePrefix       string
+	previousClusterName      string
+	migrationGracePeriod     time.Duration
 )
 
 func main() {
 	flag.Parse()
 	stopCh := signals.SetupSignalHandler()
 
+	oidcProvider = iam.NormaliseOIDCProvider(oidcProvider)
+	for i, provider := range previousOIDCProviders {
+		previousOIDCProviders[i] = iam.NormaliseOIDCProvider(provider)
+	}
+
 	if oidcProvider == "" {
 		klog.Fatalf(
 			"Invalid OIDC provider: '%s'. See help for more information.",
@@ -41,6 +66,36 @@ func main() {
 		)
 	}
 
+	if _, err := template.New("secrets-path").Parse(secretsPath); err != nil {
+		klog.Fatalf("Invalid secrets path: '%s': %s", secretsPath, err.Error())
+	}
+
+	if !isValidUnmanagedRolePolicy(unmanagedRolePolicy) {
+		klog.Fatalf(
+			"Invalid unmanaged role policy: '%s'. See help for more information.",
+			unmanagedRolePolicy,
+		)
+	}
+	if !isValidRoleARNChangePolicy(roleARNChangePolicy) {
+		klog.Fatalf(
+			"Invalid role ARN change policy: '%s'. See help for more information.",
+			roleARNChangePolicy,
+		)
+	}
+
+	// Roles are migrated from the naming the cluster had before, which defaults to the current one
+	if !isFlagSet("previous-role-prefix") {
+		previousRolePrefix = iamRolePrefix
+	}
+	if !isFlagSet("previous-cluster-name") {
+		previousClusterName = clusterName
+	}
+	if migrateRoles && previousRolePrefix == iamRolePrefix && previousClusterName == clusterName {
+		klog.Fatalf(
+			"Nothing to migrate: '-previous-role-prefix' or '-previous-cluster-name' must differ from the current ones.",
+		)
+	}
+
 	var iamManager *iam.Manager
 	if controllerWebIdTokenPath == "" {
 		iamManager = iam.NewManagerWithDefaultConfig(
@@ -48,7 +103,9 @@ func main() {
 			iamRolePrefix,
 			awsRegion,
 			oidcProvider,
+			previousOIDCProviders,
 			clusterName,
+			sharedClusters,
 		)
 	} else {
 		// ARN is required for web id token auth
@@ -63,12 +120,24 @@ func main() {
 			iamRolePrefix,
 			awsRegion,
 			oidcProvider,
+			previousOIDCProviders,
 			clusterName,
+			sharedClusters,
 			controllerIAMRoleARN,
 			controllerWebIdTokenPath,
 		)
 	}
 
+	// Roles trusting an OIDC provider that doesn't exist can't be assumed, so we refuse to start
+	// rather than create them
+	if err := iamManager.EnsureOIDCProvider(
+		createOIDCProvider,
+		oidcThumbprints,
+		oidcClientIDs,
+	); err != nil {
+		klog.Fatalf("Invalid OIDC provider: '%s': %s", oidcProvider, err.Error())
+	}
+
 	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
 	if err != nil {
 		klog.Fatalf("Error building kubeconfig: %s", err.Error())
@@ -79,19 +148,65 @@ func main() {
 		klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
 	}
 
+	securityClient, err := securityclientset.NewForConfig(cfg)
+	if err != nil {
+		klog.Fatalf("Error building security clientset: %s", err.Error())
+	}
+
 	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, syncInterval)
+	// The admin configuration only lives in the controller namespace, whose ConfigMaps are watched
+	// on their own so changes to them resync the ServiceAccounts
+	adminInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(
+		kubeClient,
+		syncInterval,
+		kubeinformers.WithNamespace(controllerNamespace),
+	)
+	securityInformerFactory := securityinformers.NewSharedInformerFactory(
+		securityClient,
+		syncInterval,
+	)
 	controller := NewController(
 		kubeClient,
+		securityClient,
 		kubeInformerFactory.Core().V1().ServiceAccounts(),
+		adminInformerFactory.Core().V1().ConfigMaps(),
+		kubeInformerFactory.Core().V1().ConfigMaps(),
+		kubeInformerFactory.Core().V1().Namespaces(),
+		securityInformerFactory.Security().V1alpha1().IAMServiceAccountRoles(),
 		iamManager,
 	)
 	kubeInformerFactory.Start(stopCh)
+	adminInformerFactory.Start(stopCh)
+	securityInformerFactory.Start(stopCh)
 
 	if err = controller.Run(workerThreads, stopCh); err != nil {
 		klog.Fatalf("Error running controller: %s", err.Error())
 	}
 }
 
+// stringList is a flag holding a comma-separated list of strings.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = splitAnnotationList(value)
+	return nil
+}
+
+// isFlagSet returns true if the flag was set on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func init() {
 	flag.StringVar(
 		&kubeconfig,
@@ -145,7 +260,39 @@ func init() {
 		&oidcProvider,
 		"oidc-provider",
 		"",
-		"This should be the OIDC provider, for example: 'oidc.eks.eu-west-1.amazonaws.com/id/14758F1AFD44C09B7992073CCF00B43D'. You can get this with 'aws eks describe-cluster --name <cluster_name> --query \"cluster.identity.oidc.issuer\" --output text | sed -e \"s/^https:\\/\\///\"'.",
+		"This should be the OIDC provider, for example: 'oidc.eks.eu-west-1.amazonaws.com/id/14758F1AFD44C09B7992073CCF00B43D'. You can get this with 'aws eks describe-cluster --name <cluster_name> --query \"cluster.identity.oidc.issuer\" --output text'; a leading 'https://' is removed. It must exist as an IAM OIDC identity provider in the account, see '-create-oidc-provider'.",
+	)
+	flag.StringVar(
+		&defaultAudience,
+		"audience",
+		"sts.amazonaws.com",
+		"The audience of the web identity tokens allowed to assume the roles, unless a ServiceAccount sets its own.",
+	)
+	flag.Var(
+		&previousOIDCProviders,
+		"previous-oidc-providers",
+		"Comma-separated OIDC providers this cluster used before '-oidc-provider'. While rotating the OIDC provider, roles keep trusting these until they are removed from this list.",
+	)
+	flag.Var(
+		&sharedClusters,
+		"shared-clusters",
+		"Comma-separated names of the other clusters this cluster shares roles with. Roles managed by any other cluster are never updated or deleted.",
+	)
+	flag.BoolVar(
+		&createOIDCProvider,
+		"create-oidc-provider",
+		false,
+		"Create the IAM OIDC identity provider for '-oidc-provider' if it doesn't exist in the account. Requires '-oidc-thumbprints'.",
+	)
+	flag.Var(
+		&oidcThumbprints,
+		"oidc-thumbprints",
+		"Comma-separated thumbprints of the OIDC provider's certificate, used when creating the IAM OIDC identity provider.",
+	)
+	flag.Var(
+		&oidcClientIDs,
+		"oidc-client-ids",
+		"Comma-separated client IDs (audiences), used when creating the IAM OIDC identity provider.",
 	)
 	flag.StringVar(
 		&clusterName,
@@ -153,4 +300,64 @@ func init() {
 		"cluster",
 		"Name of the cluster.",
 	)
+	flag.StringVar(
+		&controllerNamespace,
+		"namespace",
+		controllerName,
+		"The namespace the controller runs in. The admin ConfigMap is read from this namespace.",
+	)
+	flag.StringVar(
+		&adminConfigMapName,
+		"config-map",
+		controllerName,
+		"Name of the admin ConfigMap holding the controller configuration, such as policy grants.",
+	)
+	flag.StringVar(
+		&secretsPath,
+		"secrets-path",
+		"{{ .Cluster }}/{{ .Namespace }}/{{ .Name }}/*",
+		"Path of the AWS Secrets Manager secrets ServiceAccounts can read when they request secrets access. Can reference {{ .Cluster }}, {{ .Namespace }}, {{ .Name }} and {{ .AccountID }}.",
+	)
+	flag.StringVar(
+		&secretsKMSKeyARN,
+		"secrets-kms-key-arn",
+		"",
+		"ARN of the KMS key encrypting the AWS Secrets Manager secrets. If set, ServiceAccounts with secrets access can decrypt with it through Secrets Manager.",
+	)
+	flag.StringVar(
+		&unmanagedRolePolicy,
+		"unmanaged-role-policy",
+		unmanagedRoleDetach,
+		"What to do with the role of a ServiceAccount which stops being managed: 'delete' it, 'retain' it without the controller's tags, or 'detach' it by tagging it as detached.",
+	)
+	flag.StringVar(
+		&roleARNChangePolicy,
+		"role-arn-change-policy",
+		roleARNRestore,
+		"What to do when the eks.amazonaws.com/role-arn annotation of a managed ServiceAccount is removed or no longer references its role: 'restore' the annotation, or 'cleanup' the role with '-unmanaged-role-policy'.",
+	)
+	flag.BoolVar(
+		&migrateRoles,
+		"migrate-roles",
+		false,
+		"Migrate the roles of managed ServiceAccounts from '-previous-role-prefix' and '-previous-cluster-name' to the current ones, and rewrite their eks.amazonaws.com/role-arn annotation.",
+	)
+	flag.StringVar(
+		&previousRolePrefix,
+		"previous-role-prefix",
+		"",
+		"The role prefix roles are migrated from with '-migrate-roles'. Defaults to '-role-prefix'.",
+	)
+	flag.StringVar(
+		&previousClusterName,
+		"previous-cluster-name",
+		"",
+		"The cluster name roles are migrated from with '-migrate-roles'. Defaults to '-cluster-name'.",
+	)
+	flag.DurationVar(
+		&migrationGracePeriod,
+		"migration-grace-period",
+		24*time.Hour,
+		"How long roles are kept after being migrated with '-migrate-roles', so pods still using them have time to restart.",
+	)
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	securityv1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/apis/security/v1alpha1"
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+)
+
+// migrateRole migrates the role of the ServiceAccount from the role prefix and cluster name the
+// cluster had before, when -migrate-roles is set, so it's synced under its new name. Migrations
+// are idempotent and resume on the next sync if interrupted.
+func (c *Controller) migrateRole(manager *iam.Manager, sa *corev1.ServiceAccount) error {
+	owner := sa.ObjectMeta.Namespace + "/" + sa.ObjectMeta.Name
+	record := c.eventRecorderFor(sa)
+	return c.migrate(manager, owner, record, func(previous *iam.Manager) (*iam.Migration, error) {
+		return manager.MigrateRole(
+			previous,
+			sa.ObjectMeta.Name,
+			sa.ObjectMeta.Namespace,
+			migrationGracePeriod,
+			time.Now(),
+		)
+	})
+}
+
+// migrateResourceRole migrates the role of the ServiceAccount saName declared by the
+// IAMServiceAccountRole, as migrateRole does.
+func (c *Controller) migrateResourceRole(
+	manager *iam.Manager,
+	r *securityv1alpha1.IAMServiceAccountRole,
+	saName string,
+) error {
+	owner := r.ObjectMeta.Namespace + "/" + saName
+	record := c.eventRecorderFor(r)
+	return c.migrate(manager, owner, record, func(previous *iam.Manager) (*iam.Migration, error) {
+		return manager.MigrateRole(
+			previous,
+			saName,
+			r.ObjectMeta.Namespace,
+			migrationGracePeriod,
+			time.Now(),
+		)
+	})
+}
+
+// migrateNamespaceRole migrates the role shared by the ServiceAccounts of the namespace, as
+// migrateRole does.
+func (c *Controller) migrateNamespaceRole(manager *iam.Manager, ns *corev1.Namespace) error {
+	owner := ns.ObjectMeta.Name
+	record := c.eventRecorderFor(ns)
+	return c.migrate(manager, owner, record, func(previous *iam.Manager) (*iam.Migration, error) {
+		return manager.MigrateNamespaceRole(previous, owner, migrationGracePeriod, time.Now())
+	})
+}
+
+// migrateGroupRole migrates the role of the role group, as migrateRole does, and records its
+// events on the members.
+func (c *Controller) migrateGroupRole(
+	manager *iam.Manager,
+	group roleGroup,
+	members []*corev1.ServiceAccount,
+) error {
+	record := func(eventtype string, reason string, message string) {
+		c.recordGroupEvent(members, eventtype, reason, message)
+	}
+	owner := group.String()
+	return c.migrate(manager, owner, record, func(previous *iam.Manager) (*iam.Migration, error) {
+		return manager.MigrateGroupRole(
+			previous,
+			group.namespace,
+			group.name,
+			migrationGracePeriod,
+			time.Now(),
+		)
+	})
+}
+
+// migrate runs the migration of the role of owner in the AWS account of manager, when
+// -migrate-roles is set, with the manager of the roles named as before, and reports what it did
+// with record.
+func (c *Controller) migrate(
+	manager *iam.Manager,
+	owner string,
+	record func(eventtype string, reason string, message string),
+	migrateRole func(previous *iam.Manager) (*iam.Migration, error),
+) error {
+	if !migrateRoles {
+		return nil
+	}
+
+	migration, err := migrateRole(manager.WithNaming(previousRolePrefix, previousClusterName))
+	if err != nil {
+		record(
+			corev1.EventTypeWarning,
+			SyncFailed,
+			fmt.Sprintf(MessageRoleMigrationFailed, err.Error()),
+		)
+		return err
+	}
+	if migration == nil {
+		return nil
+	}
+
+	if migration.Copied {
+		klog.Infof("Migrated IAM Role '%s' to '%s' for '%s'", migration.From, migration.To, owner)
+		record(
+			corev1.EventTypeNormal,
+			RoleMigrated,
+			fmt.Sprintf(MessageRoleMigrated, migration.From, migration.To),
+		)
+	}
+	if migration.Deleted {
+		record(
+			corev1.EventTypeNormal,
+			PreviousRoleDeleted,
+			fmt.Sprintf(MessagePreviousRoleDeleted, migration.From, migration.To),
+		)
+	}
+	return nil
+}
+
+// eventRecorderFor returns a function recording events on the object, see migrate.
+func (c *Controller) eventRecorderFor(
+	object runtime.Object,
+) func(eventtype string, reason string, message string) {
+	return func(eventtype string, reason string, message string) {
+		c.recorder.Event(object, eventtype, reason, message)
+	}
+}
+
+// previousRoleARN returns the ARN the ServiceAccount's role had before -migrate-roles changed its
+// name, whether it's its own or a shared role.
+func (c *Controller) previousRoleARN(sa *corev1.ServiceAccount, cfg *adminConfig) (string, error) {
+	previous := c.iam.WithNaming(previousRolePrefix, previousClusterName)
+	return c.roleARNWithNaming(previous, sa, cfg)
+}
+
+// isPreviousRoleARN returns true if the ARN is the one the ServiceAccount's role had before
+// -migrate-roles changed its name, which is rewritten once the role is migrated.
+func (c *Controller) isPreviousRoleARN(sa *corev1.ServiceAccount, cfg *adminConfig, arn string) bool {
+	if !migrateRoles {
+		return false
+	}
+	previousARN, err := c.previousRoleARN(sa, cfg)
+	return err == nil && arn == previousARN
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// managementMode is how the controller manages the role of a ServiceAccount, set by the value of
+// the managed annotation of the ServiceAccount or its namespace.
+type managementMode string
+
+const (
+	// modeFull creates the role, keeps it in sync with its spec and deletes it with the
+	// ServiceAccount. The annotation value "true" is the full mode.
+	modeFull managementMode = "full"
+	// modeCreateOnly creates the role but never updates or deletes it
+	modeCreateOnly managementMode = iam.CreateOnlyMode
+	// modeObserve only reports on the role, without changing anything in AWS
+	modeObserve managementMode = "observe"
+	// modeAdopt takes over an existing role with the expected name which isn't managed by any
+	// controller, then manages it like modeFull
+	modeAdopt managementMode = "adopt"
+)
+
+// parseManagementMode returns the mode of a managed annotation value, and false if the value
+// doesn't make the role managed.
+func parseManagementMode(value string) (managementMode, bool) {
+	switch mode := managementMode(value); mode {
+	case "true":
+		return modeFull, true
+	case modeFull, modeCreateOnly, modeObserve, modeAdopt:
+		return mode, true
+	default:
+		return "", false
+	}
+}
+
+// managementModeOf returns the mode the ServiceAccount's role is managed in, and false if it isn't
+// managed. The ServiceAccount's own annotation wins over its namespace's, like in isManaged.
+func managementModeOf(sa *corev1.ServiceAccount, ns *corev1.Namespace) (managementMode, bool) {
+	if val, ok := sa.ObjectMeta.Annotations[managedAnnotationKey]; ok {
+		return parseManagementMode(val)
+	}
+	if !isManagedByNamespace(sa, ns) {
+		return "", false
+	}
+	return parseManagementMode(ns.ObjectMeta.Annotations[managedAnnotationKey])
+}
+
+// isCreated returns true if the existing role of a ServiceAccount in create-only mode was
+// created, i.e. its first sync succeeded, so it must not be updated anymore. Roles are tagged with
+// their mode once their first sync succeeded: roles without the tag are synced until they are.
+func isCreated(mode managementMode, role *awstypes.Role) bool {
+	return mode == modeCreateOnly && iam.RoleMode(role) != ""
+}
+
+// syncCreateOnlyMode tags the role of a ServiceAccount in create-only mode with the mode, so it
+// outlives the ServiceAccount.
+func (c *Controller) syncCreateOnlyMode(
+	manager *iam.Manager,
+	req *roleRequest,
+	role *awstypes.Role,
+) error {
+	if err := manager.SyncRoleMode(role, string(modeCreateOnly)); err != nil {
+		c.recorder.Event(
+			req.object,
+			corev1.EventTypeWarning,
+			SyncFailed,
+			fmt.Sprintf(MessageRoleMetadataSyncFailed, err.Error()),
+		)
+		return err
+	}
+	return nil
+}
+
+// observeRole reports on the role of a ServiceAccount in observe mode, in its status and events,
+// without changing anything in AWS.
+func (c *Controller) observeRole(manager *iam.Manager, sa *corev1.ServiceAccount) error {
+	var message string
+	role, err := manager.GetRole(sa.ObjectMeta.Name, sa.ObjectMeta.Namespace)
+	switch {
+	case err == nil && manager.IsManaged(role):
+		message = fmt.Sprintf(MessageRoleObserved, *role.Arn)
+	case err == nil:
+		message = fmt.Sprintf(MessageUnmanagedRoleObserved, *role.Arn)
+	case iamerrors.IsNotFound(err):
+		role = nil
+		message = fmt.Sprintf(
+			MessageMissingRoleObserved,
+			manager.MakeRoleARN(sa.ObjectMeta.Name, sa.ObjectMeta.Namespace),
+		)
+	default:
+		c.writeStatus(sa, StatusFailed, err.Error(), nil)
+		return err
+	}
+
+	c.writeStatus(sa, StatusObserved, message, role)
+	c.recorder.Event(sa, corev1.EventTypeNormal, RoleObserved, message)
+	return nil
+}
+
+// observedSet is the set of the keys of the ServiceAccounts last known in observe mode. Their role
+// isn't deleted with them, as it's still tagged with the mode it was created in when a
+// ServiceAccount switches to observe mode.
+type observedSet struct {
+	lock sync.Mutex
+	keys map[string]bool
+}
+
+func newObservedSet() *observedSet {
+	return &observedSet{keys: map[string]bool{}}
+}
+
+// set records whether the ServiceAccount with the key is in observe mode.
+func (s *observedSet) set(key string, observed bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if observed {
+		s.keys[key] = true
+	} else {
+		delete(s.keys, key)
+	}
+}
+
+// pop returns true if the ServiceAccount with the key was last known in observe mode, and forgets
+// it.
+func (s *observedSet) pop(key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	observed := s.keys[key]
+	delete(s.keys, key)
+	return observed
+}
+
+// handleServiceAccountDelete enqueues a deleted ServiceAccount so its role is deleted with it,
+// after recording whether it was last in observe mode, as it may have switched to it since its
+// last sync.
+func (c *Controller) handleServiceAccountDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	sa, ok := obj.(*corev1.ServiceAccount)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("unexpected deleted object: %v", obj))
+		return
+	}
+
+	ns, err := c.namespace(sa.ObjectMeta.Namespace)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	cfg, err := c.loadAdminConfig()
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	// ServiceAccounts which couldn't have a role have none to delete, and can't be told why
+	// anymore
+	_, refusal, err := c.checkRole(sa, ns, cfg)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	if refusal != nil {
+		return
+	}
+	c.recordObserved(sa, ns)
+	c.enqueueServiceAccount(sa)
+}
+
+// recordObserved records whether the ServiceAccount is in observe mode, see observedSet.
+func (c *Controller) recordObserved(sa *corev1.ServiceAccount, ns *corev1.Namespace) {
+	mode, managed := managementModeOf(sa, ns)
+	key := sa.ObjectMeta.Namespace + "/" + sa.ObjectMeta.Name
+	c.observed.set(key, managed && mode == modeObserve)
+}
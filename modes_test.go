@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseManagementMode(t *testing.T) {
+	var tests = []struct {
+		value   string
+		want    managementMode
+		managed bool
+	}{
+		{"true", modeFull, true},
+		{"full", modeFull, true},
+		{"create-only", modeCreateOnly, true},
+		{"observe", modeObserve, true},
+		{"adopt", modeAdopt, true},
+		{"false", "", false},
+		{"", "", false},
+		{"yes", "", false},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%s,%t", tt.value, tt.want, tt.managed)
+		t.Run(testname, func(t *testing.T) {
+			mode, managed := parseManagementMode(tt.value)
+			if mode != tt.want || managed != tt.managed {
+				t.Errorf("got %s,%t, want %s,%t", mode, managed, tt.want, tt.managed)
+			}
+		})
+	}
+}
+
+func TestManagementModeOf(t *testing.T) {
+	observedNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "bar",
+			Annotations: map[string]string{
+				managedAnnotationKey:           "observe",
+				managedExclusionsAnnotationKey: "default",
+			},
+		},
+	}
+
+	var tests = []struct {
+		name        string
+		annotations map[string]string
+		ns          *corev1.Namespace
+		want        managementMode
+		managed     bool
+	}{
+		{"app", map[string]string{managedAnnotationKey: "create-only"}, nil, modeCreateOnly, true},
+		{"app", map[string]string{managedAnnotationKey: "adopt"}, observedNamespace, modeAdopt, true},
+		{"app", map[string]string{managedAnnotationKey: "false"}, observedNamespace, "", false},
+		{"app", nil, observedNamespace, modeObserve, true},
+		{"default", nil, observedNamespace, "", false},
+		{"app", nil, nil, "", false},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%v,%t,%s", tt.name, tt.annotations, tt.ns != nil, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        tt.name,
+					Namespace:   "bar",
+					Annotations: tt.annotations,
+				},
+			}
+			mode, managed := managementModeOf(sa, tt.ns)
+			if mode != tt.want || managed != tt.managed {
+				t.Errorf("got %s,%t, want %s,%t", mode, managed, tt.want, tt.managed)
+			}
+		})
+	}
+}
+
+func TestRecordObserved(t *testing.T) {
+	c := &Controller{observed: newObservedSet()}
+	serviceAccount := func(mode string) *corev1.ServiceAccount {
+		return &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "app",
+				Namespace:   "bar",
+				Annotations: map[string]string{managedAnnotationKey: mode},
+			},
+		}
+	}
+
+	var tests = []struct {
+		name  string
+		modes []string
+		want  bool
+	}{
+		{"full", []string{"true"}, false},
+		{"observed", []string{"observe"}, true},
+		// the role is still tagged as managed in full mode, it mustn't be deleted
+		{"switched from full to observe", []string{"true", "observe"}, true},
+		{"switched from observe to full", []string{"observe", "true"}, false},
+		{"no longer managed", []string{"observe", "false"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, mode := range tt.modes {
+				c.recordObserved(serviceAccount(mode), nil)
+			}
+			if got := c.observed.pop("bar/app"); got != tt.want {
+				t.Errorf("got observed %t, want %t", got, tt.want)
+			}
+			if c.observed.pop("bar/app") {
+				t.Errorf("got observed after pop")
+			}
+		})
+	}
+}
+
+func TestIsCreated(t *testing.T) {
+	role := func(mode string) *awstypes.Role {
+		role := &awstypes.Role{RoleName: aws.String("k8s-sa_bar_app")}
+		if mode != "" {
+			role.Tags = []awstypes.Tag{
+				{Key: aws.String("role.k8s.aws/mode"), Value: aws.String(mode)},
+			}
+		}
+		return role
+	}
+
+	var tests = []struct {
+		name string
+		mode managementMode
+		role *awstypes.Role
+		want bool
+	}{
+		{"created", modeCreateOnly, role(iam.CreateOnlyMode), true},
+		// the first sync failed before the role was tagged, its permissions must be synced
+		{"failed first sync", modeCreateOnly, role(""), false},
+		{"switched from full", modeCreateOnly, role("full"), true},
+		{"full", modeFull, role("full"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isCreated(tt.mode, tt.role); got != tt.want {
+				t.Errorf("got created %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog"
+)
+
+const (
+	// trustNamespace makes a namespace's shared role trust every ServiceAccount of the namespace,
+	// whether it's eligible and asks for a role or not.
+	trustNamespace = "namespace"
+	// trustServiceAccounts makes a namespace's shared role only trust the ServiceAccounts using it.
+	trustServiceAccounts = "serviceAccounts"
+)
+
+// namespaceRole is the admin-defined settings of the role shared by the ServiceAccounts of a
+// namespace.
+type namespaceRole struct {
+	// Trust is either "serviceAccounts" (the default), to only trust the ServiceAccounts using the
+	// role, or "namespace", to trust every ServiceAccount of the namespace.
+	Trust string `json:"trust"`
+	// PolicyGrants are the names of the policy grants attached to the role.
+	PolicyGrants []string `json:"policyGrants"`
+}
+
+// validate returns an error if the settings can't be applied to the namespace's role under the
+// eligibility rules.
+func (r *namespaceRole) validate(e *eligibility) error {
+	switch r.Trust {
+	case "", trustServiceAccounts:
+		return nil
+	case trustNamespace:
+		// The role would trust the ServiceAccounts the rules exclude by name
+		if len(e.ServiceAccounts) > 0 || len(e.ExcludedServiceAccounts) > 0 {
+			return fmt.Errorf(
+				"trust can't be '%s' while eligibility restricts ServiceAccount names",
+				trustNamespace,
+			)
+		}
+		return nil
+	default:
+		return fmt.Errorf(
+			"trust must be '%s' or '%s', not '%s'",
+			trustNamespace,
+			trustServiceAccounts,
+			r.Trust,
+		)
+	}
+}
+
+// hasNamespaceRole returns true if the ServiceAccounts of the namespace share a role rather than
+// having their own.
+func (cfg *adminConfig) hasNamespaceRole(namespace string) bool {
+	_, ok := cfg.NamespaceRoles[namespace]
+	return ok
+}
+
+// namespaceRoleKey returns the work queue key of a namespace's shared role. Unlike the keys of
+// ServiceAccounts, it has no namespace part.
+func namespaceRoleKey(namespace string) string {
+	return namespace
+}
+
+// enqueueAllNamespaceRoles puts every namespace onto the work queue, so the shared roles of
+// namespaces which no longer have one are deleted.
+func (c *Controller) enqueueAllNamespaceRoles() {
+	namespaces, err := c.namespacesLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, ns := range namespaces {
+		c.workqueue.Add(namespaceRoleKey(ns.ObjectMeta.Name))
+	}
+}
+
+// syncNamespaceRole converges the role shared by the ServiceAccounts of the namespace with the
+// admin configuration and the ServiceAccounts using it. The role is deleted when no ServiceAccount
+// uses it anymore.
+func (c *Controller) syncNamespaceRole(namespace string) error {
+	// See syncHandler, the namespace ends up in the role's trust policy
+	if !isValidUserInput(namespace) {
+		klog.Infof("Namespace '%s' contains unexpected user input", namespace)
+		return nil
+	}
+
+	ns, err := c.namespace(namespace)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := c.loadAdminConfig()
+	if err != nil {
+		if ns != nil {
+			c.recorder.Event(
+				ns,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				fmt.Sprintf(MessageAdminConfigInvalid, err.Error()),
+			)
+		}
+		return err
+	}
+
+	manager, err := c.managerFor(namespace, cfg)
+	if err != nil {
+		if ns != nil {
+			c.recorder.Event(
+				ns,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				fmt.Sprintf(MessageAccountUnavailable, err.Error()),
+			)
+		}
+		return err
+	}
+
+	var members []*corev1.ServiceAccount
+	if ns != nil && cfg.hasNamespaceRole(namespace) {
+		if members, err = c.namespaceRoleMembers(ns, cfg); err != nil {
+			return err
+		}
+	}
+
+	// The role from before a migration is migrated while the namespace needs a role, and deleted
+	// along with it otherwise
+	if len(members) > 0 {
+		if err := c.migrateNamespaceRole(manager, ns); err != nil {
+			return err
+		}
+	} else if migrateRoles {
+		previous := manager.WithNaming(previousRolePrefix, previousClusterName)
+		if err := ignoreForeignRole(previous.DeleteNamespaceRole(namespace), namespace); err != nil {
+			return err
+		}
+	}
+
+	role, err := manager.GetNamespaceRole(namespace)
+	if err == nil {
+		err = manager.CheckNamespaceRoleOwnership(role, namespace)
+	}
+	switch {
+	case iamerrors.IsNotManaged(err):
+		// Not ours, whether we need it or not
+		if len(members) > 0 {
+			c.recorder.Event(ns, corev1.EventTypeWarning, SyncWarning, MessageUnmanagedRole)
+		}
+		for _, sa := range members {
+			c.writeStatus(sa, StatusUnmanaged, MessageUnmanagedRole, nil)
+		}
+		return nil
+
+	case iamerrors.IsNotOwned(err):
+		// Another cluster's, whether we need it or not
+		message := notOwnedMessage(role, err)
+		if len(members) > 0 {
+			c.recorder.Event(ns, corev1.EventTypeWarning, RoleNotOwned, message)
+		}
+		for _, sa := range members {
+			c.writeStatus(sa, StatusUnmanaged, message, nil)
+		}
+		return nil
+
+	case err == nil && len(members) == 0:
+		klog.Infof("No ServiceAccount uses the role of namespace '%s', will delete it", namespace)
+		return manager.DeleteNamespaceRole(namespace)
+
+	case iamerrors.IsNotFound(err) && len(members) == 0:
+		return nil
+
+	case err != nil && !iamerrors.IsNotFound(err):
+		return err
+	}
+
+	settings := cfg.NamespaceRoles[namespace]
+	var trusted []string
+	if settings.Trust != trustNamespace {
+		for _, sa := range members {
+			trusted = append(trusted, sa.ObjectMeta.Name)
+		}
+		sort.Strings(trusted)
+	}
+	spec := namespaceRoleSpec(ns, cfg)
+
+	if role == nil {
+		klog.Infof("No IAM Role for namespace '%s'; creating it", namespace)
+		if err := manager.CreateNamespaceRole(namespace, trusted, spec); err != nil {
+			c.recorder.Event(
+				ns,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				fmt.Sprintf(MessageRoleCreationFailed, err.Error()),
+			)
+			return err
+		}
+		// The role's ID is only known once it exists
+		if role, err = manager.GetNamespaceRole(namespace); err != nil {
+			return err
+		}
+	} else {
+		err := manager.SyncNamespaceTrustPolicy(role, namespace, trusted, spec.Audience)
+		if err != nil {
+			c.recorder.Event(
+				ns,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				fmt.Sprintf(MessageTrustPolicySyncFailed, err.Error()),
+			)
+			return err
+		}
+		if err := manager.SyncRoleMetadata(role, spec, cfg.RoleMetadata.managedTag); err != nil {
+			c.recorder.Event(
+				ns,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				fmt.Sprintf(MessageRoleMetadataSyncFailed, err.Error()),
+			)
+			return err
+		}
+	}
+
+	arns, refused := cfg.resolvePolicyGrants(settings.PolicyGrants, namespace)
+	if len(refused) > 0 {
+		c.recorder.Event(
+			ns,
+			corev1.EventTypeWarning,
+			PolicyGrantRefused,
+			fmt.Sprintf(MessagePolicyGrantRefused, strings.Join(refused, ", ")),
+		)
+	}
+	err = manager.SyncNamespaceAttachedPolicies(namespace, arns, cfg.grantablePolicies())
+	if err != nil {
+		c.recorder.Event(
+			ns,
+			corev1.EventTypeWarning,
+			SyncFailed,
+			fmt.Sprintf(MessagePermissionsSyncFailed, err.Error()),
+		)
+		return err
+	}
+
+	// The ServiceAccounts using the role need its ARN to assume it
+	for _, sa := range members {
+		c.writeStatus(sa, StatusSynced, c.sharedRoleStatus(sa, role), role)
+		val, ok := sa.ObjectMeta.Annotations[roleAnnotationKey]
+		if ok && (val == *role.Arn || !c.isPreviousRoleARN(sa, cfg, val)) {
+			continue
+		}
+		if err := c.annotateRoleARN(sa, *role.Arn); err != nil {
+			c.recorder.Event(
+				sa,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				fmt.Sprintf(MessageAnnotationFailed, err.Error()),
+			)
+			return err
+		}
+	}
+
+	c.recorder.Event(ns, corev1.EventTypeNormal, SyncSuccess, MessageNamespaceRoleSynced)
+	for _, sa := range members {
+		c.recorder.Event(sa, corev1.EventTypeNormal, SyncSuccess, MessageNamespaceRoleSynced)
+	}
+	return nil
+}
+
+// namespaceRoleMembers returns the ServiceAccounts of the namespace which use its shared role, i.e.
+// its managed ServiceAccounts which aren't in a role group.
+func (c *Controller) namespaceRoleMembers(
+	ns *corev1.Namespace,
+	cfg *adminConfig,
+) ([]*corev1.ServiceAccount, error) {
+	serviceAccounts, err := c.serviceAccountsLister.ServiceAccounts(
+		ns.ObjectMeta.Name,
+	).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var members []*corev1.ServiceAccount
+	for _, sa := range serviceAccounts {
+		// ServiceAccount names end up in the role's trust policy
+		if !isValidUserInput(sa.ObjectMeta.Name) {
+			continue
+		}
+		// ServiceAccounts in a role group use the group's role instead
+		if _, inGroup, _ := roleGroupOf(sa); inGroup {
+			continue
+		}
+		wanted, err := c.wantsRole(sa, ns, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if wanted {
+			members = append(members, sa)
+		}
+	}
+	return members, nil
+}
+
+// namespaceRoleSpec returns the spec of the role shared by the ServiceAccounts of the namespace.
+func namespaceRoleSpec(ns *corev1.Namespace, cfg *adminConfig) iam.RoleSpec {
+	return iam.RoleSpec{
+		Audience: defaultAudience,
+		Description: fmt.Sprintf(
+			"IAM role shared by the ServiceAccounts of namespace %s, managed by %s",
+			ns.ObjectMeta.Name,
+			controllerName,
+		),
+		DefaultDescription: true,
+		MaxSessionDuration: iam.DefaultMaxSessionDuration,
+		Tags:               cfg.RoleMetadata.namespaceTags(ns.ObjectMeta.Labels),
+	}
+}
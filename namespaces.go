@@ -0,0 +1,66 @@
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// namespace returns the namespace from the informer cache, or nil if it doesn't exist.
+func (c *Controller) namespace(name string) (*corev1.Namespace, error) {
+	ns, err := c.namespacesLister.Get(name)
+	switch {
+	case err == nil:
+		return ns, nil
+	case k8serrors.IsNotFound(err):
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// namespaceLabels returns the labels of the namespace from the informer cache, or nil if it
+// doesn't exist.
+func (c *Controller) namespaceLabels(name string) (map[string]string, error) {
+	ns, err := c.namespace(name)
+	if err != nil || ns == nil {
+		return nil, err
+	}
+	return ns.ObjectMeta.Labels, nil
+}
+
+// isManaged returns true if the ServiceAccount wants a role managed by the controller, in any
+// mode, either through its own annotation or because its namespace is managed. The
+// ServiceAccount's own annotation wins, so "false" opts it out of a managed namespace.
+func isManaged(sa *corev1.ServiceAccount, ns *corev1.Namespace) bool {
+	_, managed := managementModeOf(sa, ns)
+	return managed
+}
+
+// isManagedByNamespace returns true if the ServiceAccount's namespace is managed and doesn't
+// exclude it.
+func isManagedByNamespace(sa *corev1.ServiceAccount, ns *corev1.Namespace) bool {
+	if ns == nil {
+		return false
+	}
+	if _, managed := parseManagementMode(ns.ObjectMeta.Annotations[managedAnnotationKey]); !managed {
+		return false
+	}
+	excluded := splitAnnotationList(ns.ObjectMeta.Annotations[managedExclusionsAnnotationKey])
+	return !matchesAnyWildcard(excluded, sa.ObjectMeta.Name)
+}
+
+// namespaceManagementChanged returns true if the annotations making a namespace managed changed.
+func namespaceManagementChanged(old *corev1.Namespace, new *corev1.Namespace) bool {
+	for _, key := range []string{managedAnnotationKey, managedExclusionsAnnotationKey} {
+		if old.ObjectMeta.Annotations[key] != new.ObjectMeta.Annotations[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// annotateRoleARN sets the role-arn annotation of a ServiceAccount managed through its namespace,
+// so its pods get the role without users annotating every ServiceAccount.
+func (c *Controller) annotateRoleARN(sa *corev1.ServiceAccount, arn string) error {
+	return c.patchAnnotations(sa, map[string]interface{}{roleAnnotationKey: arn})
+}
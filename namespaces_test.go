@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsManaged(t *testing.T) {
+	managedNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "bar",
+			Annotations: map[string]string{
+				managedAnnotationKey:           "true",
+				managedExclusionsAnnotationKey: "default, builder-*",
+			},
+		},
+	}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "bar"}}
+
+	var tests = []struct {
+		name        string
+		annotations map[string]string
+		ns          *corev1.Namespace
+		want        bool
+	}{
+		{"app", map[string]string{managedAnnotationKey: "true"}, namespace, true},
+		{"app", map[string]string{managedAnnotationKey: "true"}, nil, true},
+		{"app", nil, namespace, false},
+		{"app", nil, nil, false},
+		{"app", nil, managedNamespace, true},
+		{"app", map[string]string{managedAnnotationKey: "false"}, managedNamespace, false},
+		{"default", nil, managedNamespace, false},
+		{"builder-ci", nil, managedNamespace, false},
+		{"builder-ci", map[string]string{managedAnnotationKey: "true"}, managedNamespace, true},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%v,%t,%t", tt.name, tt.annotations, tt.ns != nil, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        tt.name,
+					Namespace:   "bar",
+					Annotations: tt.annotations,
+				},
+			}
+			ans := isManaged(sa, tt.ns)
+			if ans != tt.want {
+				t.Errorf("got %t, want %t", ans, tt.want)
+			}
+		})
+	}
+}
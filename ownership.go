@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"k8s.io/klog"
+)
+
+// notOwnedMessage explains that the role has the expected name but is owned by another cluster
+// or k8s identity, i.e. their names collide, and logs it.
+func notOwnedMessage(role *awstypes.Role, err error) string {
+	klog.Warningf(
+		"IAM Role '%s' is owned by another cluster or identity, leaving it alone: %s",
+		*role.RoleName,
+		err.Error(),
+	)
+	return fmt.Sprintf(MessageRoleNotOwned, *role.Arn, err.Error())
+}
+
+// ignoreForeignRole returns nil for the errors about deleting a role which isn't ours to delete,
+// because it isn't managed by the controller or is owned by another cluster or k8s identity.
+func ignoreForeignRole(err error, owner string) error {
+	switch {
+	case iamerrors.IsNotOwned(err):
+		klog.Warningf(
+			"Not deleting the IAM Role of '%s' as it's owned by another cluster or identity: %s",
+			owner,
+			err.Error(),
+		)
+		return nil
+	case iamerrors.IsNotManaged(err):
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+)
+
+func TestIgnoreForeignRole(t *testing.T) {
+	other := &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: "throttled"}
+	var tests = []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"deleted", nil, nil},
+		{
+			"not managed",
+			&iamerrors.IAMError{Code: iamerrors.NotManagedErrorCode, Message: "not managed"},
+			nil,
+		},
+		{
+			"not owned",
+			&iamerrors.IAMError{Code: iamerrors.NotOwnedErrorCode, Message: "another cluster"},
+			nil,
+		},
+		{"failed", other, other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ignoreForeignRole(tt.err, "bar/app"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
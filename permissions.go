@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog"
+)
+
+// syncPermissions converges the policies of the ServiceAccount's IAM Role with the policies
+// requested for it that it is allowed to have.
+func (c *Controller) syncPermissions(
+	manager *iam.Manager,
+	req *roleRequest,
+	cfg *adminConfig,
+) error {
+	if err := c.syncPolicyGrants(manager, req, cfg); err != nil {
+		return err
+	}
+	if err := c.syncPolicyTemplates(manager, req, cfg); err != nil {
+		return err
+	}
+	if err := c.syncBuiltinPolicies(manager, req, cfg); err != nil {
+		return err
+	}
+	return c.syncUserPolicy(manager, req, cfg)
+}
+
+// syncPolicyGrants attaches the managed policies for the grants requested by the ServiceAccount
+// to its IAM Role. Grants which are unknown or not allowed in the ServiceAccount's namespace are
+// refused with a warning event. Policies of grants which are no longer requested are detached.
+func (c *Controller) syncPolicyGrants(
+	manager *iam.Manager,
+	req *roleRequest,
+	cfg *adminConfig,
+) error {
+	arns, refused := cfg.resolvePolicyGrants(req.policyGrants, req.namespace)
+	if len(refused) > 0 {
+		klog.Infof(
+			"ServiceAccount '%s/%s' requested policy grants that are not allowed: %s",
+			req.namespace,
+			req.name,
+			strings.Join(refused, ", "),
+		)
+		c.refuse(
+			req,
+			PolicyGrantRefused,
+			fmt.Sprintf(MessagePolicyGrantRefused, strings.Join(refused, ", ")),
+		)
+	}
+
+	return manager.SyncAttachedPolicies(
+		req.name,
+		req.namespace,
+		arns,
+		cfg.grantablePolicies(),
+	)
+}
+
+// syncPolicyTemplates renders the inline policy templates the ServiceAccount opted into and puts
+// them on its IAM Role. Templates which the ServiceAccount no longer opts into are removed.
+func (c *Controller) syncPolicyTemplates(
+	manager *iam.Manager,
+	req *roleRequest,
+	cfg *adminConfig,
+) error {
+	templates, unknown := cfg.resolvePolicyTemplates(req.policyTemplates)
+	if len(unknown) > 0 {
+		c.refuse(
+			req,
+			PolicyTemplateRefused,
+			fmt.Sprintf(MessagePolicyTemplateRefused, strings.Join(unknown, ", ")),
+		)
+	}
+
+	documents := map[string]string{}
+	for templateName, policyTemplate := range templates {
+		document, err := manager.RenderPolicyTemplate(
+			policyTemplate,
+			req.name,
+			req.namespace,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to render policy template '%s': %s", templateName, err)
+		}
+		documents[templateName] = document
+	}
+
+	return manager.SyncInlinePolicies(
+		req.name,
+		req.namespace,
+		policyTemplatePrefix,
+		documents,
+	)
+}
+
+// syncBuiltinPolicies puts the inline policies generated by the controller for the features
+// enabled on the ServiceAccount on its IAM Role, and removes those of disabled features.
+func (c *Controller) syncBuiltinPolicies(
+	manager *iam.Manager,
+	req *roleRequest,
+	cfg *adminConfig,
+) error {
+	documents := map[string]string{}
+
+	if req.secretsAccess {
+		document, err := manager.MakeSecretsAccessPolicy(
+			req.name,
+			req.namespace,
+			secretsPath,
+			secretsKMSKeyARN,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to make secrets access policy: %s", err)
+		}
+		documents[secretsAccessPolicyName] = document
+	}
+
+	roleARNs, refused := cfg.resolveAssumableRoles(req.assumeRoles, req.namespace)
+	if len(refused) > 0 {
+		klog.Infof(
+			"ServiceAccount '%s/%s' requested to assume roles that are not allowed: %s",
+			req.namespace,
+			req.name,
+			strings.Join(refused, ", "),
+		)
+		c.refuse(
+			req,
+			AssumeRoleRefused,
+			fmt.Sprintf(MessageAssumeRoleRefused, strings.Join(refused, ", ")),
+		)
+	}
+	if len(roleARNs) > 0 {
+		document, err := iam.MakeAssumeRolesPolicy(roleARNs)
+		if err != nil {
+			return fmt.Errorf("failed to make assume roles policy: %s", err)
+		}
+		documents[assumeRolesPolicyName] = document
+	}
+
+	return manager.SyncInlinePolicies(
+		req.name,
+		req.namespace,
+		builtinPolicyPrefix,
+		documents,
+	)
+}
+
+// syncUserPolicy puts the inline policy supplied by the ServiceAccount on its IAM Role, if it is
+// within the admin guardrails. Policies which are refused, or no longer supplied, are removed.
+func (c *Controller) syncUserPolicy(
+	manager *iam.Manager,
+	req *roleRequest,
+	cfg *adminConfig,
+) error {
+	documents := map[string]string{}
+
+	document, err := c.getUserPolicy(req)
+	if err != nil {
+		return err
+	}
+
+	if document != "" {
+		checked, violations, err := c.checkUserPolicy(manager, req, cfg, document)
+		if err != nil {
+			return err
+		}
+		if len(violations) == 0 {
+			documents[userPolicyName] = checked
+		} else {
+			klog.Infof(
+				"ServiceAccount '%s/%s' inline policy refused: %s",
+				req.namespace,
+				req.name,
+				strings.Join(violations, "; "),
+			)
+			c.refuse(
+				req,
+				InlinePolicyRefused,
+				fmt.Sprintf(MessageInlinePolicyRefused, strings.Join(violations, "; ")),
+			)
+		}
+	}
+
+	return manager.SyncInlinePolicies(
+		req.name,
+		req.namespace,
+		userPolicyPrefix,
+		documents,
+	)
+}
+
+// getUserPolicy returns the inline policy document supplied for the ServiceAccount, either directly
+// in the request or in a ConfigMap of its namespace. It returns an empty string if there is none.
+func (c *Controller) getUserPolicy(req *roleRequest) (string, error) {
+	if req.inlinePolicy != "" {
+		return req.inlinePolicy, nil
+	}
+
+	cmName := req.inlinePolicyConfigMap
+	if cmName == "" {
+		return "", nil
+	}
+
+	cm, err := c.namespaceConfigMapsLister.ConfigMaps(req.namespace).Get(cmName)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", fmt.Errorf("inline policy ConfigMap '%s' not found", cmName)
+		}
+		return "", err
+	}
+
+	document, ok := cm.Data[inlinePolicyConfigMapKey]
+	if !ok {
+		return "", fmt.Errorf(
+			"inline policy ConfigMap '%s' has no '%s' key",
+			cmName,
+			inlinePolicyConfigMapKey,
+		)
+	}
+	return document, nil
+}
+
+// enqueueInlinePolicyUsers enqueues the ServiceAccounts and IAMServiceAccountRoles whose inline
+// policy is in the ConfigMap, so changes to it are applied without waiting for the next resync.
+func (c *Controller) enqueueInlinePolicyUsers(cm *corev1.ConfigMap) {
+	namespace, name := cm.ObjectMeta.Namespace, cm.ObjectMeta.Name
+
+	serviceAccounts, err := c.serviceAccountsLister.ServiceAccounts(namespace).List(
+		labels.Everything(),
+	)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, sa := range serviceAccounts {
+		if sa.ObjectMeta.Annotations[inlinePolicyConfigMapAnnotationKey] == name {
+			c.enqueueServiceAccount(sa)
+		}
+	}
+
+	resources, err := c.roleResourcesLister.IAMServiceAccountRoles(namespace).List(
+		labels.Everything(),
+	)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, r := range resources {
+		if r.Spec.InlinePolicyConfigMap == name {
+			c.enqueueRoleResource(r)
+		}
+	}
+}
+
+// checkUserPolicy returns the reasons the inline policy document supplied by the ServiceAccount
+// can't be applied. Otherwise it returns the document to apply, written from what was checked so
+// IAM can't read the document differently from the guardrails.
+func (c *Controller) checkUserPolicy(
+	manager *iam.Manager,
+	req *roleRequest,
+	cfg *adminConfig,
+	document string,
+) (string, []string, error) {
+	if cfg.Guardrails == nil {
+		return "", []string{"inline policies are not enabled by the cluster admins"}, nil
+	}
+
+	policy, err := iam.ParsePolicyDocument(document)
+	if err != nil {
+		return "", []string{err.Error()}, nil
+	}
+
+	violations, err := manager.CheckGuardrails(
+		policy,
+		cfg.Guardrails,
+		req.name,
+		req.namespace,
+	)
+	if err != nil || len(violations) > 0 {
+		return "", violations, err
+	}
+	checked, err := policy.String()
+	if err != nil {
+		return "", nil, err
+	}
+	return checked, nil, nil
+}
@@ -0,0 +1,4 @@
+package security
+
+// GroupName is the API group of the controller's custom resources.
+const GroupName = "security.kaluza.com"
@@ -0,0 +1,6 @@
+// +k8s:deepcopy-gen=package
+// +groupName=security.kaluza.com
+
+// Package v1alpha1 is the v1alpha1 version of the security.kaluza.com API, which declares the
+// AWS IAM Roles of ServiceAccounts.
+package v1alpha1
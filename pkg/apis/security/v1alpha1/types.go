@@ -0,0 +1,94 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionReady is true when the role matches the spec.
+	ConditionReady = "Ready"
+	// ConditionDegraded is true when part of the spec couldn't be applied, e.g. a policy grant
+	// that isn't allowed in the namespace, or the role couldn't be synced.
+	ConditionDegraded = "Degraded"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// IAMServiceAccountRole declares the AWS IAM Role of a ServiceAccount of its namespace.
+type IAMServiceAccountRole struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IAMServiceAccountRoleSpec   `json:"spec"`
+	Status IAMServiceAccountRoleStatus `json:"status,omitempty"`
+}
+
+// IAMServiceAccountRoleSpec is the spec of an IAMServiceAccountRole. Its fields match the
+// annotations of the legacy annotation API.
+type IAMServiceAccountRoleSpec struct {
+	// ServiceAccountName is the name of the ServiceAccount the role is for. The role is named
+	// after it: (prefix_)namespace_name.
+	ServiceAccountName string `json:"serviceAccountName"`
+	// Audience of the web identity tokens allowed to assume the role.
+	// +optional
+	Audience string `json:"audience,omitempty"`
+	// Description of the role.
+	// +optional
+	Description string `json:"description,omitempty"`
+	// MaxSessionDuration of the role, within the limit set by the cluster admins.
+	// +optional
+	MaxSessionDuration *metav1.Duration `json:"maxSessionDuration,omitempty"`
+	// Tags of the role, whose keys must have the prefix allowed by the cluster admins.
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+	// PolicyGrants are the names of the admin policy grants to attach to the role.
+	// +optional
+	PolicyGrants []string `json:"policyGrants,omitempty"`
+	// PolicyTemplates are the names of the admin policy templates to put on the role.
+	// +optional
+	PolicyTemplates []string `json:"policyTemplates,omitempty"`
+	// SecretsAccess allows reading the ServiceAccount's AWS Secrets Manager secrets.
+	// +optional
+	SecretsAccess bool `json:"secretsAccess,omitempty"`
+	// AssumeRoles are the ARNs of the roles the role can assume.
+	// +optional
+	AssumeRoles []string `json:"assumeRoles,omitempty"`
+	// InlinePolicy is a policy document to put on the role, within the admin guardrails.
+	// +optional
+	InlinePolicy string `json:"inlinePolicy,omitempty"`
+	// InlinePolicyConfigMap is the name of a ConfigMap of the namespace holding the inline policy
+	// document in its "policy.json" key.
+	// +optional
+	InlinePolicyConfigMap string `json:"inlinePolicyConfigMap,omitempty"`
+}
+
+// IAMServiceAccountRoleStatus is the status of an IAMServiceAccountRole.
+type IAMServiceAccountRoleStatus struct {
+	// RoleARN is the ARN of the role.
+	// +optional
+	RoleARN string `json:"roleArn,omitempty"`
+	// RoleID is the unique ID AWS gave the role.
+	// +optional
+	RoleID string `json:"roleId,omitempty"`
+	// ServiceAccountName is the name of the ServiceAccount the role was created for. The role is
+	// deleted with the IAMServiceAccountRole, and spec.serviceAccountName can't change once set.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// ObservedGeneration is the generation of the spec the status is for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the Ready and Degraded conditions of the role.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// IAMServiceAccountRoleList is a list of IAMServiceAccountRoles.
+type IAMServiceAccountRoleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []IAMServiceAccountRole `json:"items"`
+}
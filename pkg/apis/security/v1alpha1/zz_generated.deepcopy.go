@@ -0,0 +1,141 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+// Copyright (c) 2021 OVO Technology
+// Licensed under the MIT License, see the LICENSE file.
+
+// Code generated by deepcopy-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IAMServiceAccountRole) DeepCopyInto(out *IAMServiceAccountRole) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IAMServiceAccountRole.
+func (in *IAMServiceAccountRole) DeepCopy() *IAMServiceAccountRole {
+	if in == nil {
+		return nil
+	}
+	out := new(IAMServiceAccountRole)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IAMServiceAccountRole) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IAMServiceAccountRoleList) DeepCopyInto(out *IAMServiceAccountRoleList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]IAMServiceAccountRole, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IAMServiceAccountRoleList.
+func (in *IAMServiceAccountRoleList) DeepCopy() *IAMServiceAccountRoleList {
+	if in == nil {
+		return nil
+	}
+	out := new(IAMServiceAccountRoleList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IAMServiceAccountRoleList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IAMServiceAccountRoleSpec) DeepCopyInto(out *IAMServiceAccountRoleSpec) {
+	*out = *in
+	if in.MaxSessionDuration != nil {
+		in, out := &in.MaxSessionDuration, &out.MaxSessionDuration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.PolicyGrants != nil {
+		in, out := &in.PolicyGrants, &out.PolicyGrants
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PolicyTemplates != nil {
+		in, out := &in.PolicyTemplates, &out.PolicyTemplates
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AssumeRoles != nil {
+		in, out := &in.AssumeRoles, &out.AssumeRoles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IAMServiceAccountRoleSpec.
+func (in *IAMServiceAccountRoleSpec) DeepCopy() *IAMServiceAccountRoleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IAMServiceAccountRoleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IAMServiceAccountRoleStatus) DeepCopyInto(out *IAMServiceAccountRoleStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IAMServiceAccountRoleStatus.
+func (in *IAMServiceAccountRoleStatus) DeepCopy() *IAMServiceAccountRoleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IAMServiceAccountRoleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,7 @@
+// Copyright (c) 2021 OVO Technology
+// Licensed under the MIT License, see the LICENSE file.
+
+// Code generated by client-gen. DO NOT EDIT.
+
+// This package has the automatically generated clientset.
+package versioned
@@ -0,0 +1,129 @@
+// Copyright (c) 2021 OVO Technology
+// Licensed under the MIT License, see the LICENSE file.
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	"context"
+
+	v1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/apis/security/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeIAMServiceAccountRoles implements IAMServiceAccountRoleInterface
+type FakeIAMServiceAccountRoles struct {
+	Fake *FakeSecurityV1alpha1
+	ns   string
+}
+
+var iamserviceaccountrolesResource = schema.GroupVersionResource{Group: "security.kaluza.com", Version: "v1alpha1", Resource: "iamserviceaccountroles"}
+
+var iamserviceaccountrolesKind = schema.GroupVersionKind{Group: "security.kaluza.com", Version: "v1alpha1", Kind: "IAMServiceAccountRole"}
+
+// Get takes name of the iAMServiceAccountRole, and returns the corresponding iAMServiceAccountRole object, and an error if there is any.
+func (c *FakeIAMServiceAccountRoles) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.IAMServiceAccountRole, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(iamserviceaccountrolesResource, c.ns, name), &v1alpha1.IAMServiceAccountRole{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.IAMServiceAccountRole), err
+}
+
+// List takes label and field selectors, and returns the list of IAMServiceAccountRoles that match those selectors.
+func (c *FakeIAMServiceAccountRoles) List(ctx context.Context, opts v1.ListOptions) (result *v1alpha1.IAMServiceAccountRoleList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(iamserviceaccountrolesResource, iamserviceaccountrolesKind, c.ns, opts), &v1alpha1.IAMServiceAccountRoleList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &v1alpha1.IAMServiceAccountRoleList{ListMeta: obj.(*v1alpha1.IAMServiceAccountRoleList).ListMeta}
+	for _, item := range obj.(*v1alpha1.IAMServiceAccountRoleList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested iAMServiceAccountRoles.
+func (c *FakeIAMServiceAccountRoles) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(iamserviceaccountrolesResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a iAMServiceAccountRole and creates it.  Returns the server's representation of the iAMServiceAccountRole, and an error, if there is any.
+func (c *FakeIAMServiceAccountRoles) Create(ctx context.Context, iAMServiceAccountRole *v1alpha1.IAMServiceAccountRole, opts v1.CreateOptions) (result *v1alpha1.IAMServiceAccountRole, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(iamserviceaccountrolesResource, c.ns, iAMServiceAccountRole), &v1alpha1.IAMServiceAccountRole{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.IAMServiceAccountRole), err
+}
+
+// Update takes the representation of a iAMServiceAccountRole and updates it. Returns the server's representation of the iAMServiceAccountRole, and an error, if there is any.
+func (c *FakeIAMServiceAccountRoles) Update(ctx context.Context, iAMServiceAccountRole *v1alpha1.IAMServiceAccountRole, opts v1.UpdateOptions) (result *v1alpha1.IAMServiceAccountRole, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(iamserviceaccountrolesResource, c.ns, iAMServiceAccountRole), &v1alpha1.IAMServiceAccountRole{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.IAMServiceAccountRole), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeIAMServiceAccountRoles) UpdateStatus(ctx context.Context, iAMServiceAccountRole *v1alpha1.IAMServiceAccountRole, opts v1.UpdateOptions) (*v1alpha1.IAMServiceAccountRole, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(iamserviceaccountrolesResource, "status", c.ns, iAMServiceAccountRole), &v1alpha1.IAMServiceAccountRole{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.IAMServiceAccountRole), err
+}
+
+// Delete takes name of the iAMServiceAccountRole and deletes it. Returns an error if one occurs.
+func (c *FakeIAMServiceAccountRoles) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteAction(iamserviceaccountrolesResource, c.ns, name), &v1alpha1.IAMServiceAccountRole{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeIAMServiceAccountRoles) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(iamserviceaccountrolesResource, c.ns, listOpts)
+
+	_, err := c.Fake.Invokes(action, &v1alpha1.IAMServiceAccountRoleList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched iAMServiceAccountRole.
+func (c *FakeIAMServiceAccountRoles) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.IAMServiceAccountRole, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(iamserviceaccountrolesResource, c.ns, name, pt, data, subresources...), &v1alpha1.IAMServiceAccountRole{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.IAMServiceAccountRole), err
+}
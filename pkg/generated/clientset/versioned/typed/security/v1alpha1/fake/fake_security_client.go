@@ -0,0 +1,27 @@
+// Copyright (c) 2021 OVO Technology
+// Licensed under the MIT License, see the LICENSE file.
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/generated/clientset/versioned/typed/security/v1alpha1"
+	rest "k8s.io/client-go/rest"
+	testing "k8s.io/client-go/testing"
+)
+
+type FakeSecurityV1alpha1 struct {
+	*testing.Fake
+}
+
+func (c *FakeSecurityV1alpha1) IAMServiceAccountRoles(namespace string) v1alpha1.IAMServiceAccountRoleInterface {
+	return &FakeIAMServiceAccountRoles{c, namespace}
+}
+
+// RESTClient returns a RESTClient that is used to communicate
+// with API server by this client implementation.
+func (c *FakeSecurityV1alpha1) RESTClient() rest.Interface {
+	var ret *rest.RESTClient
+	return ret
+}
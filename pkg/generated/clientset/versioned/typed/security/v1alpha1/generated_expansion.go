@@ -0,0 +1,8 @@
+// Copyright (c) 2021 OVO Technology
+// Licensed under the MIT License, see the LICENSE file.
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+type IAMServiceAccountRoleExpansion interface{}
@@ -0,0 +1,182 @@
+// Copyright (c) 2021 OVO Technology
+// Licensed under the MIT License, see the LICENSE file.
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	"context"
+	"time"
+
+	v1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/apis/security/v1alpha1"
+	scheme "github.com/ovotech/iam-service-account-controller/pkg/generated/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// IAMServiceAccountRolesGetter has a method to return a IAMServiceAccountRoleInterface.
+// A group's client should implement this interface.
+type IAMServiceAccountRolesGetter interface {
+	IAMServiceAccountRoles(namespace string) IAMServiceAccountRoleInterface
+}
+
+// IAMServiceAccountRoleInterface has methods to work with IAMServiceAccountRole resources.
+type IAMServiceAccountRoleInterface interface {
+	Create(ctx context.Context, iAMServiceAccountRole *v1alpha1.IAMServiceAccountRole, opts v1.CreateOptions) (*v1alpha1.IAMServiceAccountRole, error)
+	Update(ctx context.Context, iAMServiceAccountRole *v1alpha1.IAMServiceAccountRole, opts v1.UpdateOptions) (*v1alpha1.IAMServiceAccountRole, error)
+	UpdateStatus(ctx context.Context, iAMServiceAccountRole *v1alpha1.IAMServiceAccountRole, opts v1.UpdateOptions) (*v1alpha1.IAMServiceAccountRole, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*v1alpha1.IAMServiceAccountRole, error)
+	List(ctx context.Context, opts v1.ListOptions) (*v1alpha1.IAMServiceAccountRoleList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.IAMServiceAccountRole, err error)
+	IAMServiceAccountRoleExpansion
+}
+
+// iAMServiceAccountRoles implements IAMServiceAccountRoleInterface
+type iAMServiceAccountRoles struct {
+	client rest.Interface
+	ns     string
+}
+
+// newIAMServiceAccountRoles returns a IAMServiceAccountRoles
+func newIAMServiceAccountRoles(c *SecurityV1alpha1Client, namespace string) *iAMServiceAccountRoles {
+	return &iAMServiceAccountRoles{
+		client: c.RESTClient(),
+		ns:     namespace,
+	}
+}
+
+// Get takes name of the iAMServiceAccountRole, and returns the corresponding iAMServiceAccountRole object, and an error if there is any.
+func (c *iAMServiceAccountRoles) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.IAMServiceAccountRole, err error) {
+	result = &v1alpha1.IAMServiceAccountRole{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("iamserviceaccountroles").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of IAMServiceAccountRoles that match those selectors.
+func (c *iAMServiceAccountRoles) List(ctx context.Context, opts v1.ListOptions) (result *v1alpha1.IAMServiceAccountRoleList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1alpha1.IAMServiceAccountRoleList{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("iamserviceaccountroles").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested iAMServiceAccountRoles.
+func (c *iAMServiceAccountRoles) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Namespace(c.ns).
+		Resource("iamserviceaccountroles").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch(ctx)
+}
+
+// Create takes the representation of a iAMServiceAccountRole and creates it.  Returns the server's representation of the iAMServiceAccountRole, and an error, if there is any.
+func (c *iAMServiceAccountRoles) Create(ctx context.Context, iAMServiceAccountRole *v1alpha1.IAMServiceAccountRole, opts v1.CreateOptions) (result *v1alpha1.IAMServiceAccountRole, err error) {
+	result = &v1alpha1.IAMServiceAccountRole{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("iamserviceaccountroles").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(iAMServiceAccountRole).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Update takes the representation of a iAMServiceAccountRole and updates it. Returns the server's representation of the iAMServiceAccountRole, and an error, if there is any.
+func (c *iAMServiceAccountRoles) Update(ctx context.Context, iAMServiceAccountRole *v1alpha1.IAMServiceAccountRole, opts v1.UpdateOptions) (result *v1alpha1.IAMServiceAccountRole, err error) {
+	result = &v1alpha1.IAMServiceAccountRole{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("iamserviceaccountroles").
+		Name(iAMServiceAccountRole.Name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(iAMServiceAccountRole).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *iAMServiceAccountRoles) UpdateStatus(ctx context.Context, iAMServiceAccountRole *v1alpha1.IAMServiceAccountRole, opts v1.UpdateOptions) (result *v1alpha1.IAMServiceAccountRole, err error) {
+	result = &v1alpha1.IAMServiceAccountRole{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("iamserviceaccountroles").
+		Name(iAMServiceAccountRole.Name).
+		SubResource("status").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(iAMServiceAccountRole).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Delete takes name of the iAMServiceAccountRole and deletes it. Returns an error if one occurs.
+func (c *iAMServiceAccountRoles) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("iamserviceaccountroles").
+		Name(name).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *iAMServiceAccountRoles) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	var timeout time.Duration
+	if listOpts.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOpts.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("iamserviceaccountroles").
+		VersionedParams(&listOpts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// Patch applies the patch and returns the patched iAMServiceAccountRole.
+func (c *iAMServiceAccountRoles) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.IAMServiceAccountRole, err error) {
+	result = &v1alpha1.IAMServiceAccountRole{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("iamserviceaccountroles").
+		Name(name).
+		SubResource(subresources...).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(data).
+		Do(ctx).
+		Into(result)
+	return
+}
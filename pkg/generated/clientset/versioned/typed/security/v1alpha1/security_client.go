@@ -0,0 +1,76 @@
+// Copyright (c) 2021 OVO Technology
+// Licensed under the MIT License, see the LICENSE file.
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/apis/security/v1alpha1"
+	"github.com/ovotech/iam-service-account-controller/pkg/generated/clientset/versioned/scheme"
+	rest "k8s.io/client-go/rest"
+)
+
+type SecurityV1alpha1Interface interface {
+	RESTClient() rest.Interface
+	IAMServiceAccountRolesGetter
+}
+
+// SecurityV1alpha1Client is used to interact with features provided by the security.kaluza.com group.
+type SecurityV1alpha1Client struct {
+	restClient rest.Interface
+}
+
+func (c *SecurityV1alpha1Client) IAMServiceAccountRoles(namespace string) IAMServiceAccountRoleInterface {
+	return newIAMServiceAccountRoles(c, namespace)
+}
+
+// NewForConfig creates a new SecurityV1alpha1Client for the given config.
+func NewForConfig(c *rest.Config) (*SecurityV1alpha1Client, error) {
+	config := *c
+	if err := setConfigDefaults(&config); err != nil {
+		return nil, err
+	}
+	client, err := rest.RESTClientFor(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &SecurityV1alpha1Client{client}, nil
+}
+
+// NewForConfigOrDie creates a new SecurityV1alpha1Client for the given config and
+// panics if there is an error in the config.
+func NewForConfigOrDie(c *rest.Config) *SecurityV1alpha1Client {
+	client, err := NewForConfig(c)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// New creates a new SecurityV1alpha1Client for the given RESTClient.
+func New(c rest.Interface) *SecurityV1alpha1Client {
+	return &SecurityV1alpha1Client{c}
+}
+
+func setConfigDefaults(config *rest.Config) error {
+	gv := v1alpha1.SchemeGroupVersion
+	config.GroupVersion = &gv
+	config.APIPath = "/apis"
+	config.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+
+	if config.UserAgent == "" {
+		config.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+
+	return nil
+}
+
+// RESTClient returns a RESTClient that is used to communicate
+// with API server by this client implementation.
+func (c *SecurityV1alpha1Client) RESTClient() rest.Interface {
+	if c == nil {
+		return nil
+	}
+	return c.restClient
+}
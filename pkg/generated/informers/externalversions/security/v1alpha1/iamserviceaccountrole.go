@@ -0,0 +1,77 @@
+// Copyright (c) 2021 OVO Technology
+// Licensed under the MIT License, see the LICENSE file.
+
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	"context"
+	time "time"
+
+	securityv1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/apis/security/v1alpha1"
+	versioned "github.com/ovotech/iam-service-account-controller/pkg/generated/clientset/versioned"
+	internalinterfaces "github.com/ovotech/iam-service-account-controller/pkg/generated/informers/externalversions/internalinterfaces"
+	v1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/generated/listers/security/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// IAMServiceAccountRoleInformer provides access to a shared informer and lister for
+// IAMServiceAccountRoles.
+type IAMServiceAccountRoleInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1alpha1.IAMServiceAccountRoleLister
+}
+
+type iAMServiceAccountRoleInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+	namespace        string
+}
+
+// NewIAMServiceAccountRoleInformer constructs a new informer for IAMServiceAccountRole type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewIAMServiceAccountRoleInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredIAMServiceAccountRoleInformer(client, namespace, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredIAMServiceAccountRoleInformer constructs a new informer for IAMServiceAccountRole type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredIAMServiceAccountRoleInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.SecurityV1alpha1().IAMServiceAccountRoles(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.SecurityV1alpha1().IAMServiceAccountRoles(namespace).Watch(context.TODO(), options)
+			},
+		},
+		&securityv1alpha1.IAMServiceAccountRole{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *iAMServiceAccountRoleInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredIAMServiceAccountRoleInformer(client, f.namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *iAMServiceAccountRoleInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&securityv1alpha1.IAMServiceAccountRole{}, f.defaultInformer)
+}
+
+func (f *iAMServiceAccountRoleInformer) Lister() v1alpha1.IAMServiceAccountRoleLister {
+	return v1alpha1.NewIAMServiceAccountRoleLister(f.Informer().GetIndexer())
+}
@@ -0,0 +1,14 @@
+// Copyright (c) 2021 OVO Technology
+// Licensed under the MIT License, see the LICENSE file.
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+// IAMServiceAccountRoleListerExpansion allows custom methods to be added to
+// IAMServiceAccountRoleLister.
+type IAMServiceAccountRoleListerExpansion interface{}
+
+// IAMServiceAccountRoleNamespaceListerExpansion allows custom methods to be added to
+// IAMServiceAccountRoleNamespaceLister.
+type IAMServiceAccountRoleNamespaceListerExpansion interface{}
@@ -0,0 +1,86 @@
+// Copyright (c) 2021 OVO Technology
+// Licensed under the MIT License, see the LICENSE file.
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/apis/security/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// IAMServiceAccountRoleLister helps list IAMServiceAccountRoles.
+// All objects returned here must be treated as read-only.
+type IAMServiceAccountRoleLister interface {
+	// List lists all IAMServiceAccountRoles in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*v1alpha1.IAMServiceAccountRole, err error)
+	// IAMServiceAccountRoles returns an object that can list and get IAMServiceAccountRoles.
+	IAMServiceAccountRoles(namespace string) IAMServiceAccountRoleNamespaceLister
+	IAMServiceAccountRoleListerExpansion
+}
+
+// iAMServiceAccountRoleLister implements the IAMServiceAccountRoleLister interface.
+type iAMServiceAccountRoleLister struct {
+	indexer cache.Indexer
+}
+
+// NewIAMServiceAccountRoleLister returns a new IAMServiceAccountRoleLister.
+func NewIAMServiceAccountRoleLister(indexer cache.Indexer) IAMServiceAccountRoleLister {
+	return &iAMServiceAccountRoleLister{indexer: indexer}
+}
+
+// List lists all IAMServiceAccountRoles in the indexer.
+func (s *iAMServiceAccountRoleLister) List(selector labels.Selector) (ret []*v1alpha1.IAMServiceAccountRole, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.IAMServiceAccountRole))
+	})
+	return ret, err
+}
+
+// IAMServiceAccountRoles returns an object that can list and get IAMServiceAccountRoles.
+func (s *iAMServiceAccountRoleLister) IAMServiceAccountRoles(namespace string) IAMServiceAccountRoleNamespaceLister {
+	return iAMServiceAccountRoleNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// IAMServiceAccountRoleNamespaceLister helps list and get IAMServiceAccountRoles.
+// All objects returned here must be treated as read-only.
+type IAMServiceAccountRoleNamespaceLister interface {
+	// List lists all IAMServiceAccountRoles in the indexer for a given namespace.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*v1alpha1.IAMServiceAccountRole, err error)
+	// Get retrieves the IAMServiceAccountRole from the indexer for a given namespace and name.
+	// Objects returned here must be treated as read-only.
+	Get(name string) (*v1alpha1.IAMServiceAccountRole, error)
+	IAMServiceAccountRoleNamespaceListerExpansion
+}
+
+// iAMServiceAccountRoleNamespaceLister implements the IAMServiceAccountRoleNamespaceLister
+// interface.
+type iAMServiceAccountRoleNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all IAMServiceAccountRoles in the indexer for a given namespace.
+func (s iAMServiceAccountRoleNamespaceLister) List(selector labels.Selector) (ret []*v1alpha1.IAMServiceAccountRole, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.IAMServiceAccountRole))
+	})
+	return ret, err
+}
+
+// Get retrieves the IAMServiceAccountRole from the indexer for a given namespace and name.
+func (s iAMServiceAccountRoleNamespaceLister) Get(name string) (*v1alpha1.IAMServiceAccountRole, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1alpha1.Resource("iamserviceaccountrole"), name)
+	}
+	return obj.(*v1alpha1.IAMServiceAccountRole), nil
+}
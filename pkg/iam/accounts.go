@@ -0,0 +1,66 @@
+package iam
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	awsiam "github.com/aws/aws-sdk-go-v2/service/iam"
+	awssts "github.com/aws/aws-sdk-go-v2/service/sts"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+)
+
+// AccountID returns the ID of the AWS account the manager manages roles in.
+func (m *Manager) AccountID() string {
+	return m.accountId
+}
+
+// ForAccount returns a manager for the roles of another AWS account, which assumes the management
+// role with the controller's credentials. It has the same configuration as this manager.
+func (m *Manager) ForAccount(accountID string, managementRoleARN string) (*Manager, error) {
+	stsClient := awssts.New(awssts.Options{Region: m.region, Credentials: m.credentials})
+	creds := aws.NewCredentialsCache(
+		stscreds.NewAssumeRoleProvider(
+			stsClient,
+			managementRoleARN,
+			func(o *stscreds.AssumeRoleOptions) {
+				o.RoleSessionName = m.controllerName
+			},
+		),
+	)
+
+	// make sure the management role is in the expected account, or we'd manage roles in the
+	// wrong place
+	acctSTSClient := awssts.New(awssts.Options{Region: m.region, Credentials: creds})
+	callerIdentity, err := acctSTSClient.GetCallerIdentity(
+		m.ctx,
+		&awssts.GetCallerIdentityInput{},
+	)
+	if err != nil {
+		return nil, &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	if *callerIdentity.Account != accountID {
+		return nil, &iamerrors.IAMError{
+			Code: iamerrors.OtherErrorCode,
+			Message: fmt.Sprintf(
+				"management role '%s' is in account %s, not %s",
+				managementRoleARN,
+				*callerIdentity.Account,
+				accountID,
+			),
+		}
+	}
+
+	account := *m
+	account.client = awsiam.New(awsiam.Options{Region: m.region, Credentials: creds})
+	account.accountId = accountID
+	account.credentials = creds
+	return &account, nil
+}
+
+// MakeRoleARNInAccount returns the AWS ARN the role for the k8s ServiceAccount namespace/name has
+// in the AWS account, see MakeRoleARN.
+func (m *Manager) MakeRoleARNInAccount(name string, namespace string, accountID string) string {
+	roleName := m.makeIAMRoleName(name, namespace)
+	return fmt.Sprintf("arn:aws:iam::%s:role/%s", accountID, roleName)
+}
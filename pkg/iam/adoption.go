@@ -0,0 +1,133 @@
+package iam
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+)
+
+// subjectOperators are the condition operators which can restrict the web identity token subjects
+// trusted by a statement to exactly the ServiceAccount's.
+var subjectOperators = []string{"StringEquals", "StringLike"}
+
+// AdoptRole takes over the existing AWS IAM Role for the k8s ServiceAccount namespace/name, which
+// isn't managed by any controller, by tagging it like the roles the controller creates. Its trust
+// policy, metadata and permissions are then synced like those of any managed role. Roles managed
+// by another controller, or which can't be adopted safely (see AdoptionRefusals), are never
+// adopted.
+func (m *Manager) AdoptRole(role *awsiamtypes.Role, name string, namespace string, mode string) error {
+	if managedBy, ok := roleTags(role)[managedByTagKey]; ok && managedBy != m.controllerName {
+		return &iamerrors.IAMError{
+			Code:    iamerrors.NotManagedErrorCode,
+			Message: fmt.Sprintf("Role managed by another controller: %s", managedBy),
+		}
+	}
+	refusals, err := m.AdoptionRefusals(role, name, namespace)
+	if err != nil {
+		return err
+	}
+	if len(refusals) > 0 {
+		return &iamerrors.IAMError{
+			Code:    iamerrors.NotManagedErrorCode,
+			Message: fmt.Sprintf("Role can't be adopted: %s", strings.Join(refusals, "; ")),
+		}
+	}
+
+	tags := map[string]string{
+		managedByTagKey:                          m.controllerName,
+		stackTagKey:                              serviceAccountStack(name, namespace),
+		clusterTagKey:                            m.clusterName,
+		clusterProviderTagPrefix + m.clusterName: m.oidcProvider,
+	}
+	if mode != "" {
+		tags[modeTagKey] = mode
+	}
+	return m.tagRole(*role.RoleName, tags)
+}
+
+// AdoptionRefusals returns why the existing role for the k8s ServiceAccount namespace/name can't be
+// adopted, or nothing if it can. A role can only be adopted if its trust policy trusts nothing
+// broader than the ServiceAccount in this cluster, otherwise it may have been created by someone
+// else to get the permissions the ServiceAccount will be granted.
+func (m *Manager) AdoptionRefusals(
+	role *awsiamtypes.Role,
+	name string,
+	namespace string,
+) ([]string, error) {
+	if err := validateUserInput(name, namespace); err != nil {
+		return nil, &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+
+	// IAM returns policy documents URL-encoded
+	document, err := url.QueryUnescape(*role.AssumeRolePolicyDocument)
+	if err != nil {
+		return nil, &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	policy, err := ParsePolicyDocument(document)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+
+	subject := fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+	var refusals []string
+	for i, statement := range policy.Statement {
+		if reason := m.statementBroadness(statement, subject); reason != "" {
+			refusals = append(refusals, fmt.Sprintf("statement %d %s", i+1, reason))
+		}
+	}
+	return refusals, nil
+}
+
+// statementBroadness returns how the trust policy statement trusts more than the subject with a
+// web identity token of this cluster, or an empty string if it doesn't.
+func (m *Manager) statementBroadness(statement Statement, subject string) string {
+	// Denying never trusts anyone
+	if statement.Effect != "Allow" {
+		return ""
+	}
+
+	switch {
+	case statement.NotPrincipal != nil || len(statement.NotAction) > 0:
+		return "uses NotPrincipal or NotAction"
+	case statement.Principal == nil ||
+		len(statement.Principal.AWS) > 0 ||
+		len(statement.Principal.Service) > 0:
+		return "trusts principals other than the cluster's OIDC provider"
+	}
+
+	provider := m.statementProvider(statement)
+	if provider != m.oidcProvider && !m.IsPreviousOIDCProvider(provider) {
+		return "trusts an OIDC provider other than the cluster's"
+	}
+	if len(statement.Action) != 1 || statement.Action[0] != "sts:AssumeRoleWithWebIdentity" {
+		return fmt.Sprintf("allows actions %s", strings.Join(statement.Action, ", "))
+	}
+
+	// Conditions are ANDed, a single one restricting the subject to exactly the ServiceAccount
+	// is enough
+	for _, operator := range subjectOperators {
+		for key, values := range statement.Condition[operator] {
+			if strings.EqualFold(key, provider+":sub") && onlyValue(values, subject) {
+				return ""
+			}
+		}
+	}
+	return fmt.Sprintf("doesn't restrict the token subject to %s", subject)
+}
+
+// onlyValue returns true if value is the only value of the list. As the names of ServiceAccounts
+// have no wildcards, StringLike only matches value itself then.
+func onlyValue(values StringList, value string) bool {
+	if len(values) == 0 {
+		return false
+	}
+	for _, v := range values {
+		if v != value {
+			return false
+		}
+	}
+	return true
+}
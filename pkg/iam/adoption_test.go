@@ -0,0 +1,150 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	awsiam "github.com/aws/aws-sdk-go-v2/service/iam"
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+	"github.com/ovotech/iam-service-account-controller/pkg/ref"
+)
+
+func TestAdoptionRefusals(t *testing.T) {
+	provider := "oidc.eks.eu-west-1.amazonaws.com/id/ABCD"
+	providerARN := "arn:aws:iam::123456789012:oidc-provider/" + provider
+	statement := func(principal string, action string, condition string) string {
+		return fmt.Sprintf(
+			`{"Effect":"Allow","Principal":%s,"Action":"%s","Condition":%s}`,
+			principal,
+			action,
+			condition,
+		)
+	}
+	federated := fmt.Sprintf(`{"Federated":"%s"}`, providerARN)
+	exact := fmt.Sprintf(
+		`{"StringEquals":{"%s:aud":"sts.amazonaws.com","%s:sub":"system:serviceaccount:default:test"}}`,
+		provider,
+		provider,
+	)
+
+	var tests = []struct {
+		name       string
+		statements []string
+		refused    bool
+	}{
+		{"eksctl", []string{statement(federated, "sts:AssumeRoleWithWebIdentity", exact)}, false},
+		{
+			"string like without wildcard",
+			[]string{statement(
+				federated,
+				"sts:AssumeRoleWithWebIdentity",
+				fmt.Sprintf(`{"StringLike":{"%s:sub":"system:serviceaccount:default:test"}}`, provider),
+			)},
+			false,
+		},
+		{
+			"deny",
+			[]string{
+				statement(federated, "sts:AssumeRoleWithWebIdentity", exact),
+				`{"Effect":"Deny","Principal":"*","Action":"sts:*"}`,
+			},
+			false,
+		},
+		{
+			"whole namespace",
+			[]string{statement(
+				federated,
+				"sts:AssumeRoleWithWebIdentity",
+				fmt.Sprintf(`{"StringLike":{"%s:sub":"system:serviceaccount:default:*"}}`, provider),
+			)},
+			true,
+		},
+		{
+			"other ServiceAccount",
+			[]string{statement(
+				federated,
+				"sts:AssumeRoleWithWebIdentity",
+				fmt.Sprintf(
+					`{"StringEquals":{"%s:sub":["system:serviceaccount:default:test","system:serviceaccount:default:other"]}}`,
+					provider,
+				),
+			)},
+			true,
+		},
+		{
+			"audience only",
+			[]string{statement(
+				federated,
+				"sts:AssumeRoleWithWebIdentity",
+				fmt.Sprintf(`{"StringEquals":{"%s:aud":"sts.amazonaws.com"}}`, provider),
+			)},
+			true,
+		},
+		{
+			"other provider",
+			[]string{statement(
+				`{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/EFGH"}`,
+				"sts:AssumeRoleWithWebIdentity",
+				`{"StringEquals":{"oidc.eks.eu-west-1.amazonaws.com/id/EFGH:sub":"system:serviceaccount:default:test"}}`,
+			)},
+			true,
+		},
+		{
+			"account principal",
+			[]string{
+				statement(federated, "sts:AssumeRoleWithWebIdentity", exact),
+				`{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::210987654321:root"},"Action":"sts:AssumeRole"}`,
+			},
+			true,
+		},
+		{"wildcard principal", []string{`{"Effect":"Allow","Principal":"*","Action":"sts:AssumeRole"}`}, true},
+		{"any action", []string{statement(federated, "sts:*", exact)}, true},
+	}
+
+	m := Manager{
+		client:         awsiam.New(awsiam.Options{}),
+		accountId:      "123456789012",
+		oidcProvider:   provider,
+		clusterName:    "cluster",
+		controllerName: "iam-service-account-controller",
+		ctx:            context.TODO(),
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document := fmt.Sprintf(`{"Version":"2012-10-17","Statement":[%s]}`, strings.Join(tt.statements, ","))
+			role := &awsiamtypes.Role{AssumeRolePolicyDocument: ref.String(url.QueryEscape(document))}
+			refusals, err := m.AdoptionRefusals(role, "test", "default")
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if (len(refusals) > 0) != tt.refused {
+				t.Errorf("got refusals %v, want refused %t", refusals, tt.refused)
+			}
+		})
+	}
+}
+
+func TestAdoptRoleManagedByAnotherController(t *testing.T) {
+	m := Manager{
+		client:         awsiam.New(awsiam.Options{}),
+		accountId:      "123456789012",
+		clusterName:    "cluster",
+		controllerName: "iam-service-account-controller",
+		ctx:            context.TODO(),
+	}
+	role := &awsiamtypes.Role{
+		RoleName: ref.String("default_test"),
+		Tags: []awsiamtypes.Tag{
+			{Key: ref.String(managedByTagKey), Value: ref.String("other-controller")},
+		},
+	}
+
+	err := m.AdoptRole(role, "test", "default", "adopt")
+	if !iamerrors.IsNotManaged(err) {
+		t.Errorf("got %v, want a not managed error", err)
+	}
+}
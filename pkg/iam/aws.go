@@ -27,13 +27,54 @@ const (
 )
 
 type Manager struct {
-	client         *iam.Client
-	rolePrefix     string
-	accountId      string
-	oidcProvider   string
-	clusterName    string
+	client       *iam.Client
+	rolePrefix   string
+	region       string
+	accountId    string
+	oidcProvider string
+	// previousOIDCProviders are still trusted by the roles of this cluster while rotating its
+	// OIDC provider
+	previousOIDCProviders []string
+	clusterName           string
+	// sharedClusters are the other clusters whose roles this cluster may share, see
+	// checkOwnership
+	sharedClusters []string
 	controllerName string
-	ctx            context.Context
+	// credentials are the controller's own AWS credentials, used to assume the management roles
+	// of other accounts
+	credentials aws.CredentialsProvider
+	ctx         context.Context
+	// changes records the changes made by a tracked manager, see Tracked
+	changes *Changes
+}
+
+// NewManager returns a manager of the roles of the AWS account accountID, which calls IAM with
+// client. credentials are those of client, used to assume the management roles of other accounts.
+func NewManager(
+	client *awsiam.Client,
+	credentials aws.CredentialsProvider,
+	accountID string,
+	controllerName string,
+	rolePrefix string,
+	region string,
+	oidcProvider string,
+	previousOIDCProviders []string,
+	clusterName string,
+	sharedClusters []string,
+) *Manager {
+	return &Manager{
+		client:                client,
+		rolePrefix:            rolePrefix,
+		region:                region,
+		accountId:             accountID,
+		oidcProvider:          oidcProvider,
+		previousOIDCProviders: previousOIDCProviders,
+		clusterName:           clusterName,
+		sharedClusters:        sharedClusters,
+		controllerName:        controllerName,
+		credentials:           credentials,
+		ctx:                   context.Background(),
+	}
 }
 
 func NewManagerWithDefaultConfig(
@@ -41,7 +82,9 @@ func NewManagerWithDefaultConfig(
 	rolePrefix string,
 	region string,
 	oidcProvider string,
+	previousOIDCProviders []string,
 	clusterName string,
+	sharedClusters []string,
 ) *Manager {
 	ctx := context.Background()
 
@@ -59,15 +102,18 @@ func NewManagerWithDefaultConfig(
 		log.Fatalf("Unable to get account identifer from AWS STS: %v", err)
 	}
 
-	return &Manager{
-		client:         awsiam.NewFromConfig(cfg),
-		rolePrefix:     rolePrefix,
-		accountId:      *callerIdentity.Account,
-		oidcProvider:   oidcProvider,
-		clusterName:    clusterName,
-		controllerName: controllerName,
-		ctx:            ctx,
-	}
+	return NewManager(
+		awsiam.NewFromConfig(cfg),
+		cfg.Credentials,
+		*callerIdentity.Account,
+		controllerName,
+		rolePrefix,
+		region,
+		oidcProvider,
+		previousOIDCProviders,
+		clusterName,
+		sharedClusters,
+	)
 }
 
 func NewManagerWithWebIdToken(
@@ -75,7 +121,9 @@ func NewManagerWithWebIdToken(
 	rolePrefix string,
 	region string,
 	oidcProvider string,
+	previousOIDCProviders []string,
 	clusterName string,
+	sharedClusters []string,
 	controllerRoleARN string,
 	tokenPath string,
 ) *Manager {
@@ -108,15 +156,18 @@ func NewManagerWithWebIdToken(
 	// get iam client for manager
 	iamClient := awsiam.New(awsiam.Options{Region: region, Credentials: appCreds})
 
-	return &Manager{
-		client:         iamClient,
-		rolePrefix:     rolePrefix,
-		accountId:      accountId,
-		oidcProvider:   oidcProvider,
-		clusterName:    clusterName,
-		controllerName: controllerName,
-		ctx:            ctx,
-	}
+	return NewManager(
+		iamClient,
+		appCreds,
+		accountId,
+		controllerName,
+		rolePrefix,
+		region,
+		oidcProvider,
+		previousOIDCProviders,
+		clusterName,
+		sharedClusters,
+	)
 }
 
 // makeIAMRoleName returns the fully qualified name for the role. This is a string with the format:
@@ -128,40 +179,19 @@ func (m *Manager) makeIAMRoleName(name string, namespace string) string {
 	return fmt.Sprintf("%s_%s_%s", m.rolePrefix, namespace, name)
 }
 
-// makeAccessPolicy returns a string of an IAM Access Policy that allows AssumeRoleWithWebIdentity
-// for the k8s ServiceAccount with given namespace/name.
-func (m *Manager) makeAccessPolicy(name string, namespace string) string {
-	return fmt.Sprintf(`{
-  "Version": "2012-10-17",
-  "Statement": [
-    {
-      "Effect": "Allow",
-      "Principal": {
-        "Federated": "arn:aws:iam::%s:oidc-provider/%s"
-      },
-      "Action": "sts:AssumeRoleWithWebIdentity",
-      "Condition": {
-        "StringEquals": {
-          "%s:sub": "system:serviceaccount:%s:%s"
-        }
-      }
-    }
-  ]
-}`, m.accountId, m.oidcProvider, m.oidcProvider, namespace, name)
-}
-
 // MakeRoleARN returns the AWS ARN for a role given the k8s ServieAccount namespace/name. Note that
 // this is an ARN generated locally from the name and namespace strings and is not an ARN looked up
 // on AWS. As such this role may or may not exist in AWS.
 func (m *Manager) MakeRoleARN(name string, namespace string) string {
-	roleName := m.makeIAMRoleName(name, namespace)
-	return fmt.Sprintf("arn:aws:iam::%s:role/%s", m.accountId, roleName)
+	return m.MakeRoleARNInAccount(name, namespace, m.accountId)
 }
 
 // GetRole will fetch the AWS IAM Role for the k8s ServiceAccount namespace/name.
 func (m *Manager) GetRole(name string, namespace string) (*awsiamtypes.Role, error) {
-	roleName := m.makeIAMRoleName(name, namespace)
+	return m.getRole(m.makeIAMRoleName(name, namespace))
+}
 
+func (m *Manager) getRole(roleName string) (*awsiamtypes.Role, error) {
 	roleOutput, err := m.client.GetRole(m.ctx, &iam.GetRoleInput{RoleName: &roleName})
 	if err != nil {
 		var ae smithy.APIError
@@ -177,21 +207,44 @@ func (m *Manager) GetRole(name string, namespace string) (*awsiamtypes.Role, err
 	return roleOutput.Role, nil
 }
 
-// CreateRole will create an AWS IAM Role for the k8s ServiceAccount namespace/name.
-func (m *Manager) CreateRole(name string, namespace string) error {
+// CreateRole will create an AWS IAM Role for the k8s ServiceAccount namespace/name with the spec.
+func (m *Manager) CreateRole(name string, namespace string, spec RoleSpec) error {
 	roleName := m.makeIAMRoleName(name, namespace)
-	accessPolicy := m.makeAccessPolicy(name, namespace)
-	stackTagValue := fmt.Sprintf("%s/%s", namespace, name)
+	accessPolicy, err := m.makeAccessPolicy(name, namespace, spec.Audience)
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	return m.createRole(roleName, serviceAccountStack(name, namespace), accessPolicy, spec)
+}
+
+// createRole creates the role with the trust policy and spec, tagged as managed by this cluster
+// for the stack, i.e. the k8s identity the role is for.
+func (m *Manager) createRole(
+	roleName string,
+	stack string,
+	accessPolicy string,
+	spec RoleSpec,
+) error {
 	tags := []awstypes.Tag{
 		{Key: ref.String(managedByTagKey), Value: ref.String(m.controllerName)},
-		{Key: ref.String(stackTagKey), Value: &stackTagValue},
+		{Key: ref.String(stackTagKey), Value: &stack},
 		{Key: ref.String(clusterTagKey), Value: &m.clusterName},
+		{Key: ref.String(clusterProviderTagPrefix + m.clusterName), Value: &m.oidcProvider},
+	}
+	for key, value := range spec.Tags {
+		key, value := key, value
+		tags = append(tags, awstypes.Tag{Key: &key, Value: &value})
+	}
+	if spec.Mode != "" {
+		tags = append(tags, awstypes.Tag{Key: ref.String(modeTagKey), Value: &spec.Mode})
 	}
 
 	_, err := m.client.CreateRole(
 		m.ctx,
 		&iam.CreateRoleInput{
 			AssumeRolePolicyDocument: &accessPolicy,
+			Description:              &spec.Description,
+			MaxSessionDuration:       &spec.MaxSessionDuration,
 			RoleName:                 &roleName,
 			Tags:                     tags,
 		},
@@ -204,9 +257,14 @@ func (m *Manager) CreateRole(name string, namespace string) error {
 }
 
 // DeleteRole will delete an AWS IAM Role for the k8s ServiceAccount namespace/name if it the Role
-// exists and it's managed by this controller.
+// exists and it's owned by this cluster, see CheckOwnership. If other clusters still share the
+// Role, it is kept and only stops trusting this cluster.
 func (m *Manager) DeleteRole(name string, namespace string) error {
-	role, err := m.GetRole(name, namespace)
+	return m.deleteRole(m.makeIAMRoleName(name, namespace), serviceAccountStack(name, namespace))
+}
+
+func (m *Manager) deleteRole(roleName string, stack string) error {
+	role, err := m.getRole(roleName)
 	if err != nil {
 		// if there is no role, nothing to do and this is not an error
 		if iamerrors.IsNotFound(err) {
@@ -215,14 +273,28 @@ func (m *Manager) DeleteRole(name string, namespace string) error {
 		return err
 	}
 
-	if !m.IsManaged(role) {
-		return &iamerrors.IAMError{
-			Code:    iamerrors.NotManagedErrorCode,
-			Message: "Role not managed by controller",
-		}
+	// A role with the same name managed for another cluster or identity isn't ours to delete
+	if err := m.checkOwnership(role, stack); err != nil {
+		return err
 	}
 
-	roleName := m.makeIAMRoleName(name, namespace)
+	// Roles created in create-only mode, or detached, outlive their ServiceAccount
+	if isRetained(role) {
+		return nil
+	}
+
+	// If other clusters share the role, we only stop trusting this cluster
+	released, err := m.releaseTrustPolicy(role)
+	if err != nil || released {
+		return err
+	}
+
+	if err := m.detachAllPolicies(roleName); err != nil {
+		return err
+	}
+	if err := m.deleteAllInlinePolicies(roleName); err != nil {
+		return err
+	}
 
 	_, err = m.client.DeleteRole(m.ctx, &iam.DeleteRoleInput{RoleName: &roleName})
 	if err != nil {
@@ -232,6 +304,42 @@ func (m *Manager) DeleteRole(name string, namespace string) error {
 	return nil
 }
 
+// ReleaseRole stops managing the role without deleting it, by removing the tags marking it as
+// managed by this controller. If other clusters share the role, it stops trusting this cluster
+// and only this cluster's tag is removed, as the other clusters still manage it.
+func (m *Manager) ReleaseRole(role *awsiamtypes.Role) error {
+	if !m.IsManaged(role) {
+		return &iamerrors.IAMError{
+			Code:    iamerrors.NotManagedErrorCode,
+			Message: "Role not managed by controller",
+		}
+	}
+
+	// The ServiceAccount is no longer managed, it mustn't keep assuming a role other clusters
+	// manage
+	released, err := m.releaseTrustPolicy(role)
+	if err != nil || released {
+		return err
+	}
+	clusterTag := clusterProviderTagPrefix + m.clusterName
+	return m.untagRole(
+		*role.RoleName,
+		[]string{managedByTagKey, stackTagKey, clusterTagKey, clusterTag, modeTagKey},
+	)
+}
+
+// DetachRole stops managing the role without deleting it, by tagging it in DetachedMode. The role
+// keeps its other tags, so it's managed again when its ServiceAccount is.
+func (m *Manager) DetachRole(role *awsiamtypes.Role) error {
+	if !m.IsManaged(role) {
+		return &iamerrors.IAMError{
+			Code:    iamerrors.NotManagedErrorCode,
+			Message: "Role not managed by controller",
+		}
+	}
+	return m.SyncRoleMode(role, DetachedMode)
+}
+
 // isManaged checks if an AWS IAM Role for the ServiceAccount namespace/name is managed by this
 // controller. This check is based on AWS tags.
 func (m *Manager) IsManaged(role *awsiamtypes.Role) bool {
@@ -3,9 +3,17 @@ package iam
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	awsiam "github.com/aws/aws-sdk-go-v2/service/iam"
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/ovotech/iam-service-account-controller/pkg/ref"
 )
 
 func TestMakeIAMRoleName(t *testing.T) {
@@ -89,3 +97,166 @@ func TestMakeRoleARN(t *testing.T) {
 		})
 	}
 }
+
+func TestIsRetained(t *testing.T) {
+	var tests = []struct {
+		tags map[string]string
+		want bool
+	}{
+		{map[string]string{modeTagKey: CreateOnlyMode}, true},
+		{map[string]string{modeTagKey: DetachedMode}, true},
+		{map[string]string{modeTagKey: "full"}, false},
+		{map[string]string{}, false},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%v,%t", tt.tags, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			role := &awsiamtypes.Role{}
+			for key, value := range tt.tags {
+				key, value := key, value
+				role.Tags = append(role.Tags, awsiamtypes.Tag{Key: &key, Value: &value})
+			}
+			if ans := isRetained(role); ans != tt.want {
+				t.Errorf("got %t, want %t", ans, tt.want)
+			}
+		})
+	}
+}
+
+// recordingClient is an HTTP client answering every IAM request successfully, which records the
+// parameters of the requests.
+type recordingClient struct {
+	requests []url.Values
+	// respond returns the content of the result of a request, if set, or the code of the error
+	// answering it if failed is true
+	respond func(params url.Values) (result string, failed bool)
+}
+
+func (c *recordingClient) Do(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	params, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	c.requests = append(c.requests, params)
+
+	action := params.Get("Action")
+	var result string
+	if c.respond != nil {
+		var failed bool
+		if result, failed = c.respond(params); failed {
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Header:     http.Header{"Content-Type": []string{"text/xml"}},
+				Body: ioutil.NopCloser(strings.NewReader(fmt.Sprintf(
+					"<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>test</Message>"+
+						"</Error><RequestId>test</RequestId></ErrorResponse>",
+					result,
+				))),
+				Request: req,
+			}, nil
+		}
+	}
+	response := fmt.Sprintf(
+		"<%sResponse><%sResult>%s</%sResult>"+
+			"<ResponseMetadata><RequestId>test</RequestId></ResponseMetadata></%sResponse>",
+		action,
+		action,
+		result,
+		action,
+		action,
+	)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/xml"}},
+		Body:       ioutil.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}, nil
+}
+
+// actions returns the IAM actions of the recorded requests.
+func (c *recordingClient) actions() []string {
+	var actions []string
+	for _, params := range c.requests {
+		actions = append(actions, params.Get("Action"))
+	}
+	return actions
+}
+
+func TestReleaseRole(t *testing.T) {
+	blue := `{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/BLUE"},"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"oidc.eks.eu-west-1.amazonaws.com/id/BLUE:sub":"system:serviceaccount:default:test"}}}`
+	green := `{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/GREEN"},"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"oidc.eks.eu-west-1.amazonaws.com/id/GREEN:sub":"system:serviceaccount:default:test"}}}`
+
+	var tests = []struct {
+		name        string
+		tags        map[string]string
+		wantActions []string
+		wantTrust   string
+	}{
+		{
+			"own role",
+			map[string]string{
+				"role.k8s.aws/cluster/blue": "oidc.eks.eu-west-1.amazonaws.com/id/BLUE",
+			},
+			[]string{"UntagRole"},
+			"",
+		},
+		{
+			"shared role",
+			map[string]string{
+				"role.k8s.aws/cluster/blue":  "oidc.eks.eu-west-1.amazonaws.com/id/BLUE",
+				"role.k8s.aws/cluster/green": "oidc.eks.eu-west-1.amazonaws.com/id/GREEN",
+			},
+			[]string{"UpdateAssumeRolePolicy", "UntagRole"},
+			`{"Version":"2012-10-17","Statement":[` + green + `]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &recordingClient{}
+			m := Manager{
+				client: awsiam.New(awsiam.Options{
+					Region:      "eu-west-1",
+					Credentials: aws.AnonymousCredentials{},
+					HTTPClient:  client,
+				}),
+				rolePrefix:     "k8s-sa",
+				accountId:      "123456789012",
+				oidcProvider:   "oidc.eks.eu-west-1.amazonaws.com/id/BLUE",
+				clusterName:    "blue",
+				controllerName: "iam-service-account-controller",
+				ctx:            context.TODO(),
+			}
+			role := &awsiamtypes.Role{
+				RoleName: ref.String("k8s-sa_default_test"),
+				AssumeRolePolicyDocument: ref.String(url.QueryEscape(
+					`{"Version":"2012-10-17","Statement":[` + blue + `,` + green + `]}`,
+				)),
+				Tags: []awsiamtypes.Tag{
+					{Key: ref.String(managedByTagKey), Value: ref.String(m.controllerName)},
+				},
+			}
+			for key, value := range tt.tags {
+				role.Tags = append(role.Tags, awsiamtypes.Tag{Key: ref.String(key), Value: ref.String(value)})
+			}
+
+			if err := m.ReleaseRole(role); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(client.actions(), tt.wantActions) {
+				t.Fatalf("got %v, want %v", client.actions(), tt.wantActions)
+			}
+			if tt.wantTrust != "" {
+				trust := client.requests[0].Get("PolicyDocument")
+				if !equalPolicyDocuments(trust, tt.wantTrust) {
+					t.Errorf("got %s, want %s", trust, tt.wantTrust)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package iam
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// isValidRoleARN matches the ARN of a single IAM role, without wildcards.
+var isValidRoleARN = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:role/[\w+=,.@/-]+$`).MatchString
+
+// IsValidRoleARN returns true if the ARN is the ARN of a single IAM role.
+func IsValidRoleARN(arn string) bool {
+	return isValidRoleARN(arn)
+}
+
+// MatchesWildcard returns true if the IAM wildcard pattern, e.g. "arn:aws:iam::*:role/team-a-*",
+// covers value.
+func MatchesWildcard(pattern string, value string) bool {
+	return wildcardCovers(pattern, value)
+}
+
+// MakeAssumeRolesPolicy returns a policy document allowing to assume, and tag the sessions of,
+// the roles with the given ARNs.
+func MakeAssumeRolesPolicy(roleARNs []string) (string, error) {
+	resources := StringList{}
+	for _, arn := range roleARNs {
+		if !isValidRoleARN(arn) {
+			return "", fmt.Errorf("invalid role ARN '%s'", arn)
+		}
+		resources = append(resources, arn)
+	}
+	sort.Strings(resources)
+
+	policy := PolicyDocument{
+		Version: policyVersion,
+		Statement: []Statement{
+			{
+				Sid:      "AssumeRoles",
+				Effect:   "Allow",
+				Action:   StringList{"sts:AssumeRole", "sts:TagSession"},
+				Resource: resources,
+			},
+		},
+	}
+	return policy.String()
+}
@@ -0,0 +1,40 @@
+package iam
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMakeAssumeRolesPolicy(t *testing.T) {
+	var tests = []struct {
+		roleARNs []string
+		want     string
+		wantErr  bool
+	}{
+		{
+			[]string{"arn:aws:iam::210987654321:role/target"},
+			`{"Version":"2012-10-17","Statement":[{"Sid":"AssumeRoles","Effect":"Allow","Action":["sts:AssumeRole","sts:TagSession"],"Resource":"arn:aws:iam::210987654321:role/target"}]}`,
+			false,
+		},
+		{
+			[]string{"arn:aws:iam::210987654321:role/b", "arn:aws:iam::210987654321:role/a"},
+			`{"Version":"2012-10-17","Statement":[{"Sid":"AssumeRoles","Effect":"Allow","Action":["sts:AssumeRole","sts:TagSession"],"Resource":["arn:aws:iam::210987654321:role/a","arn:aws:iam::210987654321:role/b"]}]}`,
+			false,
+		},
+		{[]string{"arn:aws:iam::210987654321:role/*"}, "", true},
+		{[]string{`arn:aws:iam::210987654321:role/a"`}, "", true},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%v,%t", tt.roleARNs, tt.wantErr)
+		t.Run(testname, func(t *testing.T) {
+			ans, err := MakeAssumeRolesPolicy(tt.roleARNs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if ans != tt.want {
+				t.Errorf("got %s, want %s", ans, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package iam
+
+import (
+	"sort"
+	"sync"
+)
+
+// Changes records the changes a Manager made to IAM Roles, e.g. to report that it updated a role
+// which drifted from its spec.
+type Changes struct {
+	lock    sync.Mutex
+	actions map[string]bool
+}
+
+// Actions returns the IAM API actions of the changes, sorted and without duplicates.
+func (c *Changes) Actions() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var actions []string
+	for action := range c.actions {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+func (c *Changes) add(action string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.actions == nil {
+		c.actions = map[string]bool{}
+	}
+	c.actions[action] = true
+}
+
+// Tracked returns a copy of the manager which records the changes it makes in the returned
+// Changes.
+func (m *Manager) Tracked() (*Manager, *Changes) {
+	tracked := *m
+	tracked.changes = &Changes{}
+	return &tracked, tracked.changes
+}
+
+// recordChange records a successful IAM API action changing a role, if the manager is tracked.
+func (m *Manager) recordChange(action string) {
+	if m.changes != nil {
+		m.changes.add(action)
+	}
+}
@@ -0,0 +1,28 @@
+package iam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTracked(t *testing.T) {
+	m := &Manager{accountId: "123456789012"}
+	// An untracked manager doesn't record anything
+	m.recordChange("UpdateRole")
+
+	tracked, changes := m.Tracked()
+	tracked.recordChange("TagRole")
+	tracked.recordChange("AttachRolePolicy")
+	tracked.recordChange("TagRole")
+
+	want := []string{"AttachRolePolicy", "TagRole"}
+	if got := changes.Actions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if m.changes != nil {
+		t.Errorf("tracking a copy of the manager tracks the manager")
+	}
+	if tracked.AccountID() != m.AccountID() {
+		t.Errorf("got account %s, want %s", tracked.AccountID(), m.AccountID())
+	}
+}
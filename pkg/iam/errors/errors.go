@@ -5,6 +5,7 @@ import "fmt"
 const (
 	NotFoundErrorCode   = "NotFound"
 	NotManagedErrorCode = "NotManaged"
+	NotOwnedErrorCode   = "NotOwned"
 	OtherErrorCode      = "Other"
 )
 
@@ -25,3 +26,20 @@ func IsNotFound(err error) bool {
 	}
 	return false
 }
+
+// IsNotManaged returns true if the error is about a resource that isn't managed by the controller.
+func IsNotManaged(err error) bool {
+	if err, ok := err.(*IAMError); ok && err.Code == NotManagedErrorCode {
+		return true
+	}
+	return false
+}
+
+// IsNotOwned returns true if the error is about a resource managed by the controller for another
+// cluster or k8s identity.
+func IsNotOwned(err error) bool {
+	if err, ok := err.(*IAMError); ok && err.Code == NotOwnedErrorCode {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package iam
+
+import (
+	"fmt"
+
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+)
+
+// ServiceAccountRef identifies a k8s ServiceAccount.
+type ServiceAccountRef struct {
+	Name      string
+	Namespace string
+}
+
+// makeGroupRoleName returns the name of the role shared by the k8s ServiceAccounts of a role group
+// owned by the namespace. This is a string with the format:
+// (prefix_)namespace_group_group
+// It can't clash with the roles of ServiceAccounts, as ServiceAccount names can't contain "_".
+func (m *Manager) makeGroupRoleName(namespace string, group string) string {
+	if m.rolePrefix == "" {
+		return fmt.Sprintf("%s_group_%s", namespace, group)
+	}
+	return fmt.Sprintf("%s_%s_group_%s", m.rolePrefix, namespace, group)
+}
+
+// MakeGroupRoleARN returns the AWS ARN for the role of the role group owned by the namespace. As
+// with MakeRoleARN, the role may or may not exist in AWS.
+func (m *Manager) MakeGroupRoleARN(namespace string, group string) string {
+	return m.MakeGroupRoleARNInAccount(namespace, group, m.accountId)
+}
+
+// MakeGroupRoleARNInAccount returns the AWS ARN the role of the role group owned by the namespace
+// has in the AWS account, see MakeGroupRoleARN.
+func (m *Manager) MakeGroupRoleARNInAccount(
+	namespace string,
+	group string,
+	accountID string,
+) string {
+	return fmt.Sprintf(
+		"arn:aws:iam::%s:role/%s",
+		accountID,
+		m.makeGroupRoleName(namespace, group),
+	)
+}
+
+// GetGroupRole will fetch the AWS IAM Role of the role group owned by the namespace.
+func (m *Manager) GetGroupRole(namespace string, group string) (*awsiamtypes.Role, error) {
+	return m.getRole(m.makeGroupRoleName(namespace, group))
+}
+
+// makeGroupAccessStatement returns the trust policy statement allowing the members of a role
+// group, which can be in any namespace, to assume the role with web identity tokens from the OIDC
+// provider.
+func (m *Manager) makeGroupAccessStatement(
+	provider string,
+	members []ServiceAccountRef,
+	audience string,
+) (Statement, error) {
+	if len(members) == 0 {
+		return Statement{}, fmt.Errorf("role group has no members")
+	}
+
+	var subjects StringList
+	for _, member := range members {
+		if err := validateUserInput(member.Name, member.Namespace); err != nil {
+			return Statement{}, err
+		}
+		subjects = append(
+			subjects,
+			fmt.Sprintf("system:serviceaccount:%s:%s", member.Namespace, member.Name),
+		)
+	}
+	return m.makeSubjectsStatement(provider, subjects, false, audience), nil
+}
+
+// CreateGroupRole will create the AWS IAM Role of the role group owned by the namespace, trusting
+// its members.
+func (m *Manager) CreateGroupRole(
+	namespace string,
+	group string,
+	members []ServiceAccountRef,
+	spec RoleSpec,
+) error {
+	if err := validateUserInput(namespace, group); err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	statement, err := m.makeGroupAccessStatement(m.oidcProvider, members, spec.Audience)
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	accessPolicy, err := makeTrustPolicy([]Statement{statement})
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+
+	return m.createRole(
+		m.makeGroupRoleName(namespace, group),
+		groupStack(namespace, group),
+		accessPolicy,
+		spec,
+	)
+}
+
+// SyncGroupTrustPolicy updates the AssumeRolePolicyDocument of the AWS IAM Role of a role group so
+// that it trusts exactly its members. Other clusters sharing the role are handled as in
+// SyncTrustPolicy.
+func (m *Manager) SyncGroupTrustPolicy(
+	role *awsiamtypes.Role,
+	members []ServiceAccountRef,
+	audience string,
+) error {
+	statement, err := m.makeGroupAccessStatement(m.oidcProvider, members, audience)
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	return m.syncTrustPolicy(role, statement)
+}
+
+// SyncGroupAttachedPolicies converges the managed policies attached to the AWS IAM Role of the
+// role group owned by the namespace with desired, see SyncAttachedPolicies.
+func (m *Manager) SyncGroupAttachedPolicies(
+	namespace string,
+	group string,
+	desired []string,
+	grantable []string,
+) error {
+	return m.syncAttachedPolicies(m.makeGroupRoleName(namespace, group), desired, grantable)
+}
+
+// DeleteGroupRole will delete the AWS IAM Role of the role group owned by the namespace, as
+// DeleteRole does for the role of a ServiceAccount.
+func (m *Manager) DeleteGroupRole(namespace string, group string) error {
+	return m.deleteRole(m.makeGroupRoleName(namespace, group), groupStack(namespace, group))
+}
@@ -0,0 +1,67 @@
+package iam
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMakeGroupRoleName(t *testing.T) {
+	var tests = []struct {
+		namespace string
+		group     string
+		prefix    string
+		want      string
+	}{
+		{"default", "jobs", "k8s-sa", "k8s-sa_default_group_jobs"},
+		{"default", "jobs", "", "default_group_jobs"},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%s,%s,%s", tt.namespace, tt.group, tt.prefix, tt.want)
+		m := Manager{rolePrefix: tt.prefix}
+		t.Run(testname, func(t *testing.T) {
+			ans := m.makeGroupRoleName(tt.namespace, tt.group)
+			if ans != tt.want {
+				t.Errorf("got %s, want %s", ans, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeGroupAccessStatement(t *testing.T) {
+	var tests = []struct {
+		members []ServiceAccountRef
+		want    string
+		wantErr bool
+	}{
+		{
+			[]ServiceAccountRef{{"runner", "batch"}, {"reporter", "reports"}},
+			`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/ABCD"},"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"oidc.eks.eu-west-1.amazonaws.com/id/ABCD:aud":"sts.amazonaws.com","oidc.eks.eu-west-1.amazonaws.com/id/ABCD:sub":["system:serviceaccount:batch:runner","system:serviceaccount:reports:reporter"]}}}]}`,
+			false,
+		},
+		{nil, "", true},
+		{[]ServiceAccountRef{{"*", "batch"}}, "", true},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%v,%t", tt.members, tt.wantErr)
+		m := Manager{accountId: "123456789012"}
+		t.Run(testname, func(t *testing.T) {
+			provider := "oidc.eks.eu-west-1.amazonaws.com/id/ABCD"
+			statement, err := m.makeGroupAccessStatement(provider, tt.members, "sts.amazonaws.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			ans, err := makeTrustPolicy([]Statement{statement})
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if ans != tt.want {
+				t.Errorf("got %s, want %s", ans, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package iam
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Guardrails are the admin-defined limits of the inline policies users can put on their roles.
+type Guardrails struct {
+	// AllowedActions are the actions users can allow, e.g. "s3:Get*". Actions of the iam service
+	// are never allowed.
+	AllowedActions []string `json:"allowedActions"`
+	// ResourcePatterns are the resources users can allow, e.g.
+	// "arn:aws:s3:::bucket/{{ .Namespace }}/*". They are templates with the same values as admin
+	// policy templates and must reference the namespace.
+	ResourcePatterns []string `json:"resourcePatterns"`
+}
+
+// Validate returns an error if the guardrails could let a namespace's policy reach the resources
+// of other namespaces.
+func (g *Guardrails) Validate() error {
+	for _, pattern := range g.ResourcePatterns {
+		if !strings.Contains(pattern, ".Namespace") {
+			return fmt.Errorf("resource pattern '%s' doesn't include the namespace", pattern)
+		}
+	}
+	return nil
+}
+
+// CheckGuardrails returns the reasons the policy document of the k8s ServiceAccount
+// namespace/name isn't within the guardrails. The policy is acceptable if there are none.
+func (m *Manager) CheckGuardrails(
+	policy *PolicyDocument,
+	guardrails *Guardrails,
+	name string,
+	namespace string,
+) ([]string, error) {
+	var resourcePatterns []string
+	for _, pattern := range guardrails.ResourcePatterns {
+		rendered, err := m.renderTemplate(pattern, name, namespace)
+		if err != nil {
+			return nil, err
+		}
+		resourcePatterns = append(resourcePatterns, rendered)
+	}
+
+	var violations []string
+	if policy.Version != policyVersion {
+		violations = append(violations, fmt.Sprintf("policy version must be '%s'", policyVersion))
+	}
+
+	for i, statement := range policy.Statement {
+		violation := func(format string, args ...interface{}) {
+			violations = append(
+				violations,
+				fmt.Sprintf("statement %d: %s", i, fmt.Sprintf(format, args...)),
+			)
+		}
+
+		if statement.Effect != "Allow" && statement.Effect != "Deny" {
+			violation("invalid effect '%s'", statement.Effect)
+		}
+		// These would let a statement bypass the allowed actions and resources
+		if statement.Principal != nil || statement.NotPrincipal != nil {
+			violation("principals are not allowed")
+		}
+		if len(statement.NotAction) > 0 {
+			violation("NotAction is not allowed")
+		}
+		if len(statement.NotResource) > 0 {
+			violation("NotResource is not allowed")
+		}
+		if len(statement.Action) == 0 {
+			violation("no actions")
+		}
+		if len(statement.Resource) == 0 {
+			violation("no resources")
+		}
+
+		for _, action := range statement.Action {
+			service := strings.SplitN(action, ":", 2)[0]
+			switch {
+			case !strings.Contains(action, ":") || strings.ContainsAny(service, "*?"):
+				violation("action '%s' doesn't name a single service", action)
+			case strings.EqualFold(service, "iam"):
+				violation("action '%s' is an iam action", action)
+			case !matchesAny(guardrails.AllowedActions, action, true):
+				violation("action '%s' is not allowed", action)
+			}
+		}
+
+		for _, resource := range statement.Resource {
+			if !matchesAny(resourcePatterns, resource, false) {
+				violation("resource '%s' is not allowed", resource)
+			}
+		}
+	}
+
+	return violations, nil
+}
+
+// matchesAny returns true if any of the wildcard patterns covers value.
+func matchesAny(patterns []string, value string, ignoreCase bool) bool {
+	for _, pattern := range patterns {
+		if ignoreCase {
+			pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+		}
+		if wildcardCovers(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardCovers returns true if everything the IAM wildcard expression value can match is also
+// matched by pattern. In both, "*" matches any sequence of characters and "?" any single
+// character. Wildcards in value can only be covered by wildcards in pattern: "s3:*" covers
+// "s3:Get*", but "s3:Get*" doesn't cover "s3:*". value comes from users, so this runs in
+// O(len(pattern) * len(value)) whatever the wildcards.
+func wildcardCovers(pattern string, value string) bool {
+	// covers[j] is true if the rest of the pattern, from the current position, covers value[j:].
+	// At the end of the pattern, only the end of value is covered.
+	covers := make([]bool, len(value)+1)
+	covers[len(value)] = true
+
+	next := make([]bool, len(value)+1)
+	for i := len(pattern) - 1; i >= 0; i-- {
+		next, covers = covers, next
+		if pattern[i] == '*' {
+			// "*" in the pattern consumes any number of characters of value, including wildcards
+			covers[len(value)] = next[len(value)]
+			for j := len(value) - 1; j >= 0; j-- {
+				covers[j] = next[j] || covers[j+1]
+			}
+			continue
+		}
+
+		covers[len(value)] = false
+		for j := len(value) - 1; j >= 0; j-- {
+			// only a "*" in the pattern could cover a "*" in value, handled above
+			covers[j] = value[j] != '*' &&
+				(pattern[i] == '?' || pattern[i] == value[j]) &&
+				next[j+1]
+		}
+	}
+	return covers[0]
+}
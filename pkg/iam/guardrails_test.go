@@ -0,0 +1,103 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	awsiam "github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+func TestWildcardCovers(t *testing.T) {
+	var tests = []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"s3:GetObject", "s3:GetObject", true},
+		{"s3:*", "s3:GetObject", true},
+		{"s3:*", "s3:Get*", true},
+		{"s3:Get*", "s3:*", false},
+		{"s3:Get?bject", "s3:GetObject", true},
+		{"s3:?", "s3:*", false},
+		{"arn:aws:s3:::bucket/default/*", "arn:aws:s3:::bucket/default/a/b", true},
+		{"arn:aws:s3:::bucket/default/*", "arn:aws:s3:::bucket/*", false},
+		{"arn:aws:s3:::bucket/default/*", "*", false},
+		{"*", "", true},
+		{"*", "*", true},
+		{"?", "", false},
+		{"**", "s3:*", true},
+		{"*a*a*b", strings.Repeat("a", 2000), false},
+		{"*a*a*b", strings.Repeat("a", 2000) + "b", true},
+		{"*a*a*b", "a*ab", true},
+		{"*a?a*b", "a*ab", false},
+		{"*a*?b", "a?ab", true},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%.40s,%t", tt.pattern, tt.value, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			ans := wildcardCovers(tt.pattern, tt.value)
+			if ans != tt.want {
+				t.Errorf("got %t, want %t", ans, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckGuardrails(t *testing.T) {
+	guardrails := &Guardrails{
+		AllowedActions:   []string{"s3:Get*", "sqs:*", "iam:*"},
+		ResourcePatterns: []string{"arn:aws:s3:::bucket/{{ .Namespace }}/*"},
+	}
+
+	var tests = []struct {
+		document       string
+		wantViolations int
+	}{
+		{
+			`{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/default/*"}]}`,
+			0,
+		},
+		{
+			`{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": ["s3:PutObject", "iam:PassRole", "*"], "Resource": "arn:aws:s3:::bucket/other/*"}]}`,
+			4,
+		},
+		{
+			`{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "NotAction": "s3:GetObject", "NotResource": "arn:aws:s3:::bucket/default/*"}]}`,
+			4,
+		},
+		{
+			`{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "sqs:SendMessage", "Resource": "arn:aws:s3:::bucket/default/*"}]}`,
+			1,
+		},
+	}
+
+	m := Manager{
+		client:         awsiam.New(awsiam.Options{}),
+		rolePrefix:     "k8s-sa",
+		accountId:      "123456789012",
+		oidcProvider:   "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_ABCD",
+		clusterName:    "cluster",
+		controllerName: "iam-service-account-controller",
+		ctx:            context.TODO(),
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%d", tt.document, tt.wantViolations)
+		t.Run(testname, func(t *testing.T) {
+			policy, err := ParsePolicyDocument(tt.document)
+			if err != nil {
+				t.Fatal(err)
+			}
+			violations, err := m.CheckGuardrails(policy, guardrails, "test", "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(violations) != tt.wantViolations {
+				t.Errorf("got %v, want %d violations", violations, tt.wantViolations)
+			}
+		})
+	}
+}
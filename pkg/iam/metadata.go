@@ -0,0 +1,188 @@
+package iam
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+)
+
+const (
+	// DefaultMaxSessionDuration is the maximum session duration of roles, in seconds, unless
+	// requested otherwise.
+	DefaultMaxSessionDuration = 3600
+	// MaxMaxSessionDuration is the longest maximum session duration IAM allows, in seconds.
+	MaxMaxSessionDuration = 43200
+	// MaxRoleTags is the most tags IAM allows on a role.
+	MaxRoleTags = 50
+	// ControllerTagCount is the number of tags the controller puts on every role it manages, out
+	// of MaxRoleTags. Roles may have more, see ControllerTags.
+	ControllerTagCount = 5
+	// CreateOnlyMode is the management mode of roles which are created but never updated or
+	// deleted by the controller.
+	CreateOnlyMode = "create-only"
+	// DetachedMode is the management mode of roles the controller stopped managing, but kept.
+	DetachedMode = "detached"
+	// modeTagKey is the tag holding the management mode of the role, so it's known when deleting
+	// the role of a ServiceAccount which doesn't exist anymore.
+	modeTagKey = "role.k8s.aws/mode"
+)
+
+// RoleSpec holds the settings of an AWS IAM Role requested for a k8s ServiceAccount.
+type RoleSpec struct {
+	// Audience of the web identity tokens allowed to assume the role
+	Audience string
+	// Description of the role
+	Description string
+	// DefaultDescription is true if Description is the controller's default, which is only set on
+	// roles without a description, so existing and adopted roles keep theirs.
+	DefaultDescription bool
+	// MaxSessionDuration in seconds, between 3600 and 43200
+	MaxSessionDuration int32
+	// Tags requested by the user or copied from the namespace's labels
+	Tags map[string]string
+	// Mode is the management mode of the role, if any. Roles in CreateOnlyMode are never deleted.
+	Mode string
+}
+
+// SyncRoleMetadata updates the description, maximum session duration, tags and mode of the role to
+// match the spec. The default description doesn't replace the description of the role. Tags which
+// aren't in the spec are only removed if managedTag returns true for their key, so tags set by
+// others are left alone.
+func (m *Manager) SyncRoleMetadata(
+	role *awsiamtypes.Role,
+	spec RoleSpec,
+	managedTag func(key string) bool,
+) error {
+	description := ""
+	if role.Description != nil {
+		description = *role.Description
+	}
+	maxSessionDuration := int32(0)
+	if role.MaxSessionDuration != nil {
+		maxSessionDuration = *role.MaxSessionDuration
+	}
+	if spec.DefaultDescription && description != "" {
+		spec.Description = description
+	}
+
+	if description != spec.Description || maxSessionDuration != spec.MaxSessionDuration {
+		_, err := m.client.UpdateRole(
+			m.ctx,
+			&iam.UpdateRoleInput{
+				RoleName:           role.RoleName,
+				Description:        &spec.Description,
+				MaxSessionDuration: &spec.MaxSessionDuration,
+			},
+		)
+		if err != nil {
+			return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+		}
+		m.recordChange("UpdateRole")
+	}
+
+	desired := map[string]string{}
+	for key, value := range spec.Tags {
+		desired[key] = value
+	}
+	if spec.Mode != "" {
+		desired[modeTagKey] = spec.Mode
+	}
+	return m.syncTags(role, desired, func(key string) bool {
+		return key == modeTagKey || managedTag(key)
+	})
+}
+
+// SyncRoleMode tags the role with the management mode, if it isn't already.
+func (m *Manager) SyncRoleMode(role *awsiamtypes.Role, mode string) error {
+	if roleTags(role)[modeTagKey] == mode {
+		return nil
+	}
+	return m.tagRole(*role.RoleName, map[string]string{modeTagKey: mode})
+}
+
+// RoleMode returns the management mode tagged on the role, if any.
+func RoleMode(role *awsiamtypes.Role) string {
+	return roleTags(role)[modeTagKey]
+}
+
+// isRetained returns true if the role was created in CreateOnlyMode, or detached, so it must not
+// be deleted.
+func isRetained(role *awsiamtypes.Role) bool {
+	mode := RoleMode(role)
+	return mode == CreateOnlyMode || mode == DetachedMode
+}
+
+// syncTags converges the tags of the role for which owned returns true with desired. Tags which
+// are not owned are left alone.
+func (m *Manager) syncTags(
+	role *awsiamtypes.Role,
+	desired map[string]string,
+	owned func(key string) bool,
+) error {
+	current := roleTags(role)
+
+	changed := map[string]string{}
+	for key, value := range desired {
+		if currentValue, ok := current[key]; !ok || currentValue != value {
+			changed[key] = value
+		}
+	}
+
+	var removed []string
+	for key := range current {
+		if _, ok := desired[key]; !ok && owned(key) {
+			removed = append(removed, key)
+		}
+	}
+
+	if err := m.tagRole(*role.RoleName, changed); err != nil {
+		return err
+	}
+	return m.untagRole(*role.RoleName, removed)
+}
+
+// ControllerTags returns the number of tags of the controller the role has or will have once
+// synced, out of MaxRoleTags: ControllerTagCount, plus the tags of the other clusters sharing it and
+// those of its migration. Tags recording attached policies aren't counted. role is nil if it
+// doesn't exist yet.
+func (m *Manager) ControllerTags(role *awsiamtypes.Role) int {
+	count := ControllerTagCount
+	if role == nil {
+		return count
+	}
+	ownTags := map[string]bool{
+		managedByTagKey:                          true,
+		stackTagKey:                              true,
+		clusterTagKey:                            true,
+		clusterProviderTagPrefix + m.clusterName: true,
+		modeTagKey:                               true,
+	}
+	for key := range roleTags(role) {
+		if ownTags[key] || strings.HasPrefix(key, attachedPolicyTagPrefix) {
+			continue
+		}
+		controllerTag := strings.HasPrefix(key, "role.k8s.aws/") ||
+			strings.HasPrefix(key, "serviceaccount.k8s.aws/")
+		if controllerTag {
+			count++
+		}
+	}
+	return count
+}
+
+// reservedTagPrefixes are the prefixes of the tag keys users can't set, because they're used by
+// the controller or AWS.
+var reservedTagPrefixes = []string{"role.k8s.aws/", "serviceaccount.k8s.aws/", "aws:"}
+
+// IsReservedTagKey returns true if the tag key is used by the controller or AWS and can't be set
+// by users.
+func IsReservedTagKey(key string) bool {
+	for _, prefix := range reservedTagPrefixes {
+		if strings.HasPrefix(strings.ToLower(key), prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package iam
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsiam "github.com/aws/aws-sdk-go-v2/service/iam"
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/ovotech/iam-service-account-controller/pkg/ref"
+)
+
+func TestSyncRoleMetadataDescription(t *testing.T) {
+	var tests = []struct {
+		name            string
+		current         *string
+		spec            RoleSpec
+		wantDescription string
+	}{
+		{
+			"default keeps existing",
+			ref.String("Adopted role"),
+			RoleSpec{Description: "Default", DefaultDescription: true},
+			"",
+		},
+		{
+			"default describes role without description",
+			nil,
+			RoleSpec{Description: "Default", DefaultDescription: true},
+			"Default",
+		},
+		{
+			"requested replaces existing",
+			ref.String("Adopted role"),
+			RoleSpec{Description: "Requested"},
+			"Requested",
+		},
+		{
+			"requested already set",
+			ref.String("Requested"),
+			RoleSpec{Description: "Requested"},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &recordingClient{}
+			m := Manager{
+				client: awsiam.New(awsiam.Options{
+					Region:      "eu-west-1",
+					Credentials: aws.AnonymousCredentials{},
+					HTTPClient:  client,
+				}),
+				ctx: context.TODO(),
+			}
+			tt.spec.MaxSessionDuration = DefaultMaxSessionDuration
+			role := &awsiamtypes.Role{
+				RoleName:           ref.String("k8s-sa_default_test"),
+				Description:        tt.current,
+				MaxSessionDuration: aws.Int32(DefaultMaxSessionDuration),
+			}
+
+			err := m.SyncRoleMetadata(role, tt.spec, func(string) bool { return false })
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wantActions []string
+			if tt.wantDescription != "" {
+				wantActions = []string{"UpdateRole"}
+			}
+			if actions := client.actions(); !reflect.DeepEqual(actions, wantActions) {
+				t.Fatalf("got actions %v, want %v", actions, wantActions)
+			}
+			if tt.wantDescription != "" {
+				if got := client.requests[0].Get("Description"); got != tt.wantDescription {
+					t.Errorf("got description %q, want %q", got, tt.wantDescription)
+				}
+			}
+		})
+	}
+}
+
+func TestControllerTags(t *testing.T) {
+	tag := func(key string) awsiamtypes.Tag {
+		return awsiamtypes.Tag{Key: ref.String(key), Value: ref.String("value")}
+	}
+
+	var tests = []struct {
+		name string
+		role *awsiamtypes.Role
+		want int
+	}{
+		{"new role", nil, ControllerTagCount},
+		{
+			"own tags",
+			&awsiamtypes.Role{Tags: []awsiamtypes.Tag{
+				tag(managedByTagKey),
+				tag(stackTagKey),
+				tag(clusterTagKey),
+				tag(clusterProviderTagPrefix + "cluster"),
+				tag(attachedPolicyTagKey("arn:aws:iam::aws:policy/ReadOnlyAccess")),
+				tag("team"),
+			}},
+			ControllerTagCount,
+		},
+		{
+			"shared and migrated",
+			&awsiamtypes.Role{Tags: []awsiamtypes.Tag{
+				tag(managedByTagKey),
+				tag(clusterProviderTagPrefix + "cluster"),
+				tag(clusterProviderTagPrefix + "other"),
+				tag(migratedToTagKey),
+				tag(migratedAtTagKey),
+			}},
+			ControllerTagCount + 3,
+		},
+	}
+
+	m := &Manager{clusterName: "cluster"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.ControllerTags(tt.role); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
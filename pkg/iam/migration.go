@@ -0,0 +1,313 @@
+package iam
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+)
+
+const (
+	// migratedToTagKey is tagged on a role once it has been copied to the role with the name in
+	// its value, and migratedAtTagKey with the time it was, so an interrupted migration resumes
+	// where it stopped.
+	migratedToTagKey = "role.k8s.aws/migrated-to"
+	migratedAtTagKey = "role.k8s.aws/migrated-at"
+)
+
+// Migration is what MigrateRole, MigrateNamespaceRole or MigrateGroupRole did to migrate a role.
+type Migration struct {
+	// From and To are the ARNs of the previous role and of the role it was migrated to
+	From string
+	To   string
+	// Copied is true if the previous role was copied to the new one by this call
+	Copied bool
+	// Deleted is true if the previous role was deleted by this call, its grace period being
+	// over. Otherwise DeleteAfter is when it will be, unless it's never deleted.
+	Deleted     bool
+	DeleteAfter time.Time
+}
+
+// WithNaming returns a manager for the roles named with the role prefix and tagged with the
+// cluster name, e.g. those the cluster had before either was changed. It has the same
+// configuration as this manager otherwise.
+func (m *Manager) WithNaming(rolePrefix string, clusterName string) *Manager {
+	previous := *m
+	previous.rolePrefix = rolePrefix
+	previous.clusterName = clusterName
+	return &previous
+}
+
+// MigrateRole migrates the role of the k8s ServiceAccount namespace/name managed by previous, a
+// manager with the role prefix and cluster name the cluster had before, to this manager's. The
+// previous role is copied to the new name with its trust policy, attached and inline policies,
+// permissions boundary and tags, then deleted once gracePeriod is over so pods still using it
+// have time to pick up the new role. Each call does what's left, so migrations resume where they
+// stopped if interrupted. It returns nil if there is no previous role managed by the controller.
+func (m *Manager) MigrateRole(
+	previous *Manager,
+	name string,
+	namespace string,
+	gracePeriod time.Duration,
+	now time.Time,
+) (*Migration, error) {
+	if err := validateUserInput(name, namespace); err != nil {
+		return nil, &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	return m.migrateRole(
+		previous,
+		previous.makeIAMRoleName(name, namespace),
+		m.makeIAMRoleName(name, namespace),
+		serviceAccountStack(name, namespace),
+		gracePeriod,
+		now,
+	)
+}
+
+// MigrateNamespaceRole migrates the role shared by the k8s ServiceAccounts of the namespace, as
+// MigrateRole does for the role of a ServiceAccount.
+func (m *Manager) MigrateNamespaceRole(
+	previous *Manager,
+	namespace string,
+	gracePeriod time.Duration,
+	now time.Time,
+) (*Migration, error) {
+	if err := validateUserInput(namespace); err != nil {
+		return nil, &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	return m.migrateRole(
+		previous,
+		previous.makeNamespaceRoleName(namespace),
+		m.makeNamespaceRoleName(namespace),
+		namespace,
+		gracePeriod,
+		now,
+	)
+}
+
+// MigrateGroupRole migrates the role of the role group owned by the namespace, as MigrateRole does
+// for the role of a ServiceAccount.
+func (m *Manager) MigrateGroupRole(
+	previous *Manager,
+	namespace string,
+	group string,
+	gracePeriod time.Duration,
+	now time.Time,
+) (*Migration, error) {
+	if err := validateUserInput(namespace, group); err != nil {
+		return nil, &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	return m.migrateRole(
+		previous,
+		previous.makeGroupRoleName(namespace, group),
+		m.makeGroupRoleName(namespace, group),
+		groupStack(namespace, group),
+		gracePeriod,
+		now,
+	)
+}
+
+// migrateRole migrates the role previousName managed by previous for the stack to roleName, see
+// MigrateRole.
+func (m *Manager) migrateRole(
+	previous *Manager,
+	previousName string,
+	roleName string,
+	stack string,
+	gracePeriod time.Duration,
+	now time.Time,
+) (*Migration, error) {
+	role, err := previous.getRole(previousName)
+	if err != nil {
+		if iamerrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if previous.checkOwnership(role, stack) != nil {
+		return nil, nil
+	}
+
+	// Only the cluster name changed, the role stays where it is
+	if previousName == roleName {
+		return nil, m.retagCluster(role, previous.clusterName)
+	}
+
+	migration := &Migration{
+		From: *role.Arn,
+		To:   fmt.Sprintf("arn:aws:iam::%s:role/%s", m.accountId, roleName),
+	}
+	tags := roleTags(role)
+	migratedAt, err := time.Parse(time.RFC3339, tags[migratedAtTagKey])
+	if tags[migratedToTagKey] != roleName || err != nil {
+		if err := m.copyRole(role, roleName, previous.clusterName); err != nil {
+			return nil, err
+		}
+		migratedAt = now
+		if err := previous.tagRole(previousName, map[string]string{
+			migratedToTagKey: roleName,
+			migratedAtTagKey: migratedAt.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return nil, err
+		}
+		migration.Copied = true
+	}
+
+	// Roles created in create-only mode, or detached, are never deleted
+	if isRetained(role) {
+		return migration, nil
+	}
+	migration.DeleteAfter = migratedAt.Add(gracePeriod)
+	if now.Before(migration.DeleteAfter) {
+		return migration, nil
+	}
+	if err := previous.deleteRole(previousName, stack); err != nil {
+		return nil, err
+	}
+	migration.Deleted = true
+	migration.DeleteAfter = time.Time{}
+	return migration, nil
+}
+
+// copyRole copies the role to roleName, creating it if needed. The tags of the role are copied
+// except the migration's and those of the previous cluster name, which become this cluster's.
+func (m *Manager) copyRole(role *awsiamtypes.Role, roleName string, previousClusterName string) error {
+	tags := migratedTags(roleTags(role), previousClusterName, m.clusterName, m.oidcProvider)
+
+	current, err := m.getRole(roleName)
+	switch {
+	case iamerrors.IsNotFound(err):
+		// IAM returns policy documents URL-encoded
+		trustPolicy, err := url.QueryUnescape(*role.AssumeRolePolicyDocument)
+		if err != nil {
+			return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+		}
+		input := &iam.CreateRoleInput{
+			AssumeRolePolicyDocument: &trustPolicy,
+			Description:              role.Description,
+			MaxSessionDuration:       role.MaxSessionDuration,
+			RoleName:                 &roleName,
+		}
+		for key, value := range tags {
+			key, value := key, value
+			input.Tags = append(input.Tags, awsiamtypes.Tag{Key: &key, Value: &value})
+		}
+		if role.PermissionsBoundary != nil {
+			input.PermissionsBoundary = role.PermissionsBoundary.PermissionsBoundaryArn
+		}
+		if _, err := m.client.CreateRole(m.ctx, input); err != nil {
+			return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+		}
+
+	case err != nil:
+		return err
+
+	// A previous attempt created the role, finish copying, unless it's another's
+	default:
+		if err := m.checkOwnership(current, tags[stackTagKey]); err != nil {
+			return err
+		}
+		if err := m.tagRole(roleName, tags); err != nil {
+			return err
+		}
+		if role.PermissionsBoundary != nil && current.PermissionsBoundary == nil {
+			if _, err := m.client.PutRolePermissionsBoundary(m.ctx, &iam.PutRolePermissionsBoundaryInput{
+				PermissionsBoundary: role.PermissionsBoundary.PermissionsBoundaryArn,
+				RoleName:            &roleName,
+			}); err != nil {
+				return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+			}
+		}
+	}
+
+	// The policies the controller attached are recorded in the copied tags
+	attached, err := m.listAttachedPolicies(*role.RoleName)
+	if err != nil {
+		return err
+	}
+	copied, err := m.listAttachedPolicies(roleName)
+	if err != nil {
+		return err
+	}
+	for _, arn := range attached {
+		if contains(copied, arn) {
+			continue
+		}
+		if err := m.attachPolicy(roleName, arn); err != nil {
+			return err
+		}
+	}
+
+	inline, err := m.listInlinePolicies(*role.RoleName)
+	if err != nil {
+		return err
+	}
+	for _, policyName := range inline {
+		document, err := m.getInlinePolicy(*role.RoleName, policyName)
+		if err != nil {
+			return err
+		}
+		policyName := policyName
+		if _, err := m.client.PutRolePolicy(m.ctx, &iam.PutRolePolicyInput{
+			PolicyDocument: &document,
+			PolicyName:     &policyName,
+			RoleName:       &roleName,
+		}); err != nil {
+			return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+// retagCluster moves the role from the previous cluster name to this manager's, for clusters
+// which were renamed.
+func (m *Manager) retagCluster(role *awsiamtypes.Role, previousClusterName string) error {
+	if previousClusterName == m.clusterName {
+		return nil
+	}
+	current := roleTags(role)
+	desired := migratedTags(current, previousClusterName, m.clusterName, m.oidcProvider)
+
+	changed := map[string]string{}
+	for key, value := range desired {
+		if current[key] != value {
+			changed[key] = value
+		}
+	}
+	if err := m.tagRole(*role.RoleName, changed); err != nil {
+		return err
+	}
+	previousTag := clusterProviderTagPrefix + previousClusterName
+	if _, ok := current[previousTag]; !ok {
+		return nil
+	}
+	return m.untagRole(*role.RoleName, []string{previousTag})
+}
+
+// migratedTags returns the tags of a role migrated from the previous cluster name to the cluster,
+// without the tags of the migration itself.
+func migratedTags(
+	tags map[string]string,
+	previousClusterName string,
+	clusterName string,
+	oidcProvider string,
+) map[string]string {
+	migrated := map[string]string{}
+	for key, value := range tags {
+		switch key {
+		case migratedToTagKey, migratedAtTagKey, clusterProviderTagPrefix + previousClusterName:
+			continue
+		}
+		migrated[key] = value
+	}
+	if tags[clusterTagKey] == previousClusterName {
+		migrated[clusterTagKey] = clusterName
+	}
+	if _, ok := tags[clusterProviderTagPrefix+previousClusterName]; ok {
+		migrated[clusterProviderTagPrefix+clusterName] = oidcProvider
+	}
+	return migrated
+}
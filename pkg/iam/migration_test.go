@@ -0,0 +1,240 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsiam "github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+func TestWithNaming(t *testing.T) {
+	m := &Manager{
+		client:         awsiam.New(awsiam.Options{}),
+		rolePrefix:     "k8s-sa",
+		accountId:      "123456789012",
+		oidcProvider:   "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_ABCD",
+		clusterName:    "cluster",
+		controllerName: "iam-service-account-controller",
+		ctx:            context.TODO(),
+	}
+
+	previous := m.WithNaming("old", "old-cluster")
+	if got := previous.makeIAMRoleName("app", "bar"); got != "old_bar_app" {
+		t.Errorf("got %s, want old_bar_app", got)
+	}
+	if previous.clusterName != "old-cluster" {
+		t.Errorf("got %s, want old-cluster", previous.clusterName)
+	}
+	if m.rolePrefix != "k8s-sa" || m.clusterName != "cluster" {
+		t.Errorf("manager changed: %s, %s", m.rolePrefix, m.clusterName)
+	}
+}
+
+func TestMigratedTags(t *testing.T) {
+	provider := "oidc.eks.eu-west-1.amazonaws.com/id/NEW"
+	var tests = []struct {
+		name     string
+		tags     map[string]string
+		previous string
+		want     map[string]string
+	}{
+		{
+			"renamed cluster",
+			map[string]string{
+				managedByTagKey:                          "iam-service-account-controller",
+				stackTagKey:                              "bar/app",
+				clusterTagKey:                            "old-cluster",
+				clusterProviderTagPrefix + "old-cluster": "oidc.eks.eu-west-1.amazonaws.com/id/OLD",
+				"team":                                   "platform",
+			},
+			"old-cluster",
+			map[string]string{
+				managedByTagKey:                      "iam-service-account-controller",
+				stackTagKey:                          "bar/app",
+				clusterTagKey:                        "cluster",
+				clusterProviderTagPrefix + "cluster": provider,
+				"team":                               "platform",
+			},
+		},
+		{
+			"same cluster",
+			map[string]string{
+				managedByTagKey:                      "iam-service-account-controller",
+				clusterTagKey:                        "cluster",
+				clusterProviderTagPrefix + "cluster": "oidc.eks.eu-west-1.amazonaws.com/id/OLD",
+				modeTagKey:                           CreateOnlyMode,
+			},
+			"cluster",
+			map[string]string{
+				managedByTagKey:                      "iam-service-account-controller",
+				clusterTagKey:                        "cluster",
+				clusterProviderTagPrefix + "cluster": provider,
+				modeTagKey:                           CreateOnlyMode,
+			},
+		},
+		{
+			"shared with another cluster",
+			map[string]string{
+				clusterTagKey:                            "other",
+				clusterProviderTagPrefix + "other":       "oidc.eks.eu-west-1.amazonaws.com/id/OTHER",
+				clusterProviderTagPrefix + "old-cluster": "oidc.eks.eu-west-1.amazonaws.com/id/OLD",
+			},
+			"old-cluster",
+			map[string]string{
+				clusterTagKey:                        "other",
+				clusterProviderTagPrefix + "other":   "oidc.eks.eu-west-1.amazonaws.com/id/OTHER",
+				clusterProviderTagPrefix + "cluster": provider,
+			},
+		},
+		{
+			"migration tags",
+			map[string]string{
+				clusterTagKey:    "old-cluster",
+				migratedToTagKey: "k8s-sa_bar_app",
+				migratedAtTagKey: "2021-06-01T00:00:00Z",
+			},
+			"old-cluster",
+			map[string]string{clusterTagKey: "cluster"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := migratedTags(tt.tags, tt.previous, "cluster", provider)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateSharedRoles(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	role := func(roleName string, stack string) string {
+		return fmt.Sprintf(
+			"<Role><RoleName>%s</RoleName><Arn>arn:aws:iam::123456789012:role/%s</Arn>"+
+				"<AssumeRolePolicyDocument>%%7B%%7D</AssumeRolePolicyDocument>"+
+				"<Tags>"+
+				"<member><Key>%s</Key><Value>iam-service-account-controller</Value></member>"+
+				"<member><Key>%s</Key><Value>%s</Value></member>"+
+				"<member><Key>%s</Key><Value>cluster</Value></member>"+
+				"</Tags></Role>",
+			roleName,
+			roleName,
+			managedByTagKey,
+			stackTagKey,
+			stack,
+			clusterTagKey,
+		)
+	}
+
+	var tests = []struct {
+		name        string
+		previous    string
+		stack       string
+		migrate     func(m *Manager, previous *Manager) (*Migration, error)
+		wantActions []string
+		wantCreated string
+	}{
+		{
+			"namespace role",
+			"old_bar",
+			"bar",
+			func(m *Manager, previous *Manager) (*Migration, error) {
+				return m.MigrateNamespaceRole(previous, "bar", time.Hour, now)
+			},
+			[]string{
+				"GetRole",
+				"GetRole",
+				"CreateRole",
+				"ListAttachedRolePolicies",
+				"ListAttachedRolePolicies",
+				"ListRolePolicies",
+				"TagRole",
+			},
+			"k8s-sa_bar",
+		},
+		{
+			"group role",
+			"old_bar_group_jobs",
+			"bar/group/jobs",
+			func(m *Manager, previous *Manager) (*Migration, error) {
+				return m.MigrateGroupRole(previous, "bar", "jobs", time.Hour, now)
+			},
+			[]string{
+				"GetRole",
+				"GetRole",
+				"CreateRole",
+				"ListAttachedRolePolicies",
+				"ListAttachedRolePolicies",
+				"ListRolePolicies",
+				"TagRole",
+			},
+			"k8s-sa_bar_group_jobs",
+		},
+		{
+			"another's role",
+			"old_bar",
+			"other",
+			func(m *Manager, previous *Manager) (*Migration, error) {
+				return m.MigrateNamespaceRole(previous, "bar", time.Hour, now)
+			},
+			[]string{"GetRole"},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &recordingClient{
+				respond: func(params url.Values) (string, bool) {
+					if params.Get("Action") != "GetRole" {
+						return "", false
+					}
+					if params.Get("RoleName") != tt.previous {
+						return "NoSuchEntity", true
+					}
+					return role(tt.previous, tt.stack), false
+				},
+			}
+			m := &Manager{
+				client: awsiam.New(awsiam.Options{
+					Region:      "eu-west-1",
+					Credentials: aws.AnonymousCredentials{},
+					HTTPClient:  client,
+				}),
+				rolePrefix:     "k8s-sa",
+				accountId:      "123456789012",
+				clusterName:    "cluster",
+				controllerName: "iam-service-account-controller",
+				ctx:            context.TODO(),
+			}
+
+			migration, err := tt.migrate(m, m.WithNaming("old", "cluster"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actions := client.actions(); !reflect.DeepEqual(actions, tt.wantActions) {
+				t.Fatalf("got actions %v, want %v", actions, tt.wantActions)
+			}
+			if tt.wantCreated == "" {
+				if migration != nil {
+					t.Errorf("got migration %v, want none", migration)
+				}
+				return
+			}
+			if got := client.requests[2].Get("RoleName"); got != tt.wantCreated {
+				t.Errorf("got created %s, want %s", got, tt.wantCreated)
+			}
+			wantTo := "arn:aws:iam::123456789012:role/" + tt.wantCreated
+			if !migration.Copied || migration.Deleted || migration.To != wantTo {
+				t.Errorf("got migration %+v, want copied to %s", migration, wantTo)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package iam
+
+import (
+	"fmt"
+	"strings"
+
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+)
+
+// makeNamespaceRoleName returns the name of the role shared by the k8s ServiceAccounts of the
+// namespace. This is a string with the format:
+// (prefix_)namespace
+// It can't clash with the roles of ServiceAccounts, as ServiceAccount names can't contain "_".
+func (m *Manager) makeNamespaceRoleName(namespace string) string {
+	if m.rolePrefix == "" {
+		return namespace
+	}
+	return fmt.Sprintf("%s_%s", m.rolePrefix, namespace)
+}
+
+// IsSharedRoleARN returns true if the ARN is of a role the controller names for a namespace or a
+// role group, in any AWS account, rather than for a single ServiceAccount.
+func (m *Manager) IsSharedRoleARN(arn string) bool {
+	parts := strings.SplitN(arn, ":role/", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "arn:aws:iam::") {
+		return false
+	}
+	name := parts[1]
+	if m.rolePrefix != "" {
+		if !strings.HasPrefix(name, m.rolePrefix+"_") {
+			return false
+		}
+		name = strings.TrimPrefix(name, m.rolePrefix+"_")
+	}
+	// Namespace and ServiceAccount names can't contain "_", see makeGroupRoleName
+	nameParts := strings.Split(name, "_")
+	switch len(nameParts) {
+	case 1:
+		return true
+	case 3:
+		return nameParts[1] == "group"
+	default:
+		return false
+	}
+}
+
+// MakeNamespaceRoleARN returns the AWS ARN for the role shared by the k8s ServiceAccounts of the
+// namespace. As with MakeRoleARN, the role may or may not exist in AWS.
+func (m *Manager) MakeNamespaceRoleARN(namespace string) string {
+	return m.MakeNamespaceRoleARNInAccount(namespace, m.accountId)
+}
+
+// MakeNamespaceRoleARNInAccount returns the AWS ARN the role shared by the k8s ServiceAccounts of
+// the namespace has in the AWS account, see MakeNamespaceRoleARN.
+func (m *Manager) MakeNamespaceRoleARNInAccount(namespace string, accountID string) string {
+	return fmt.Sprintf("arn:aws:iam::%s:role/%s", accountID, m.makeNamespaceRoleName(namespace))
+}
+
+// GetNamespaceRole will fetch the AWS IAM Role shared by the k8s ServiceAccounts of the namespace.
+func (m *Manager) GetNamespaceRole(namespace string) (*awsiamtypes.Role, error) {
+	return m.getRole(m.makeNamespaceRoleName(namespace))
+}
+
+// makeNamespaceAccessStatement returns the trust policy statement allowing the k8s ServiceAccounts
+// of the namespace to assume the role with web identity tokens from the OIDC provider. If
+// serviceAccounts is empty, every ServiceAccount of the namespace is trusted.
+func (m *Manager) makeNamespaceAccessStatement(
+	provider string,
+	namespace string,
+	serviceAccounts []string,
+	audience string,
+) (Statement, error) {
+	if err := validateUserInput(append([]string{namespace}, serviceAccounts...)...); err != nil {
+		return Statement{}, err
+	}
+
+	if len(serviceAccounts) == 0 {
+		return m.makeSubjectsStatement(
+			provider,
+			StringList{fmt.Sprintf("system:serviceaccount:%s:*", namespace)},
+			true,
+			audience,
+		), nil
+	}
+
+	var subjects StringList
+	for _, name := range serviceAccounts {
+		subjects = append(subjects, fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name))
+	}
+	return m.makeSubjectsStatement(provider, subjects, false, audience), nil
+}
+
+// CreateNamespaceRole will create the AWS IAM Role shared by the k8s ServiceAccounts of the
+// namespace, trusting serviceAccounts or every ServiceAccount of the namespace if empty.
+func (m *Manager) CreateNamespaceRole(
+	namespace string,
+	serviceAccounts []string,
+	spec RoleSpec,
+) error {
+	statement, err := m.makeNamespaceAccessStatement(
+		m.oidcProvider,
+		namespace,
+		serviceAccounts,
+		spec.Audience,
+	)
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	accessPolicy, err := makeTrustPolicy([]Statement{statement})
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+
+	return m.createRole(m.makeNamespaceRoleName(namespace), namespace, accessPolicy, spec)
+}
+
+// SyncNamespaceTrustPolicy updates the AssumeRolePolicyDocument of the AWS IAM Role shared by the
+// k8s ServiceAccounts of the namespace so that it trusts serviceAccounts, or every ServiceAccount
+// of the namespace if empty. Other clusters sharing the role are handled as in SyncTrustPolicy.
+func (m *Manager) SyncNamespaceTrustPolicy(
+	role *awsiamtypes.Role,
+	namespace string,
+	serviceAccounts []string,
+	audience string,
+) error {
+	statement, err := m.makeNamespaceAccessStatement(
+		m.oidcProvider,
+		namespace,
+		serviceAccounts,
+		audience,
+	)
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	return m.syncTrustPolicy(role, statement)
+}
+
+// SyncNamespaceAttachedPolicies converges the managed policies attached to the AWS IAM Role shared
+// by the k8s ServiceAccounts of the namespace with desired, see SyncAttachedPolicies.
+func (m *Manager) SyncNamespaceAttachedPolicies(
+	namespace string,
+	desired []string,
+	grantable []string,
+) error {
+	return m.syncAttachedPolicies(m.makeNamespaceRoleName(namespace), desired, grantable)
+}
+
+// DeleteNamespaceRole will delete the AWS IAM Role shared by the k8s ServiceAccounts of the
+// namespace, as DeleteRole does for the role of a ServiceAccount.
+func (m *Manager) DeleteNamespaceRole(namespace string) error {
+	return m.deleteRole(m.makeNamespaceRoleName(namespace), namespace)
+}
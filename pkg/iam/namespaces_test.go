@@ -0,0 +1,115 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	awsiam "github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+func TestMakeNamespaceRoleName(t *testing.T) {
+	var tests = []struct {
+		namespace string
+		prefix    string
+		want      string
+	}{
+		{"default", "k8s-sa", "k8s-sa_default"},
+		{"default", "", "default"},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%s,%s", tt.namespace, tt.prefix, tt.want)
+		m := Manager{rolePrefix: tt.prefix}
+		t.Run(testname, func(t *testing.T) {
+			ans := m.makeNamespaceRoleName(tt.namespace)
+			if ans != tt.want {
+				t.Errorf("got %s, want %s", ans, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeNamespaceAccessStatement(t *testing.T) {
+	var tests = []struct {
+		namespace       string
+		serviceAccounts []string
+		want            string
+		wantErr         bool
+	}{
+		{
+			"default",
+			nil,
+			`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/ABCD"},"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"oidc.eks.eu-west-1.amazonaws.com/id/ABCD:aud":"sts.amazonaws.com"},"StringLike":{"oidc.eks.eu-west-1.amazonaws.com/id/ABCD:sub":"system:serviceaccount:default:*"}}}]}`,
+			false,
+		},
+		{
+			"default",
+			[]string{"api", "worker"},
+			`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/ABCD"},"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"oidc.eks.eu-west-1.amazonaws.com/id/ABCD:aud":"sts.amazonaws.com","oidc.eks.eu-west-1.amazonaws.com/id/ABCD:sub":["system:serviceaccount:default:api","system:serviceaccount:default:worker"]}}}]}`,
+			false,
+		},
+		{"default", []string{"*"}, "", true},
+		{"default:*", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%v,%t", tt.namespace, tt.serviceAccounts, tt.wantErr)
+		m := Manager{
+			client:         awsiam.New(awsiam.Options{}),
+			rolePrefix:     "k8s-sa",
+			accountId:      "123456789012",
+			oidcProvider:   "oidc.eks.eu-west-1.amazonaws.com/id/ABCD",
+			clusterName:    "cluster",
+			controllerName: "iam-service-account-controller",
+			ctx:            context.TODO(),
+		}
+		t.Run(testname, func(t *testing.T) {
+			statement, err := m.makeNamespaceAccessStatement(
+				m.oidcProvider,
+				tt.namespace,
+				tt.serviceAccounts,
+				"sts.amazonaws.com",
+			)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			ans, err := makeTrustPolicy([]Statement{statement})
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if ans != tt.want {
+				t.Errorf("got %s, want %s", ans, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSharedRoleARN(t *testing.T) {
+	var tests = []struct {
+		arn    string
+		prefix string
+		want   bool
+	}{
+		{"arn:aws:iam::123456789012:role/k8s-sa_default", "k8s-sa", true},
+		{"arn:aws:iam::210987654321:role/k8s-sa_default_group_jobs", "k8s-sa", true},
+		{"arn:aws:iam::123456789012:role/k8s-sa_default_app", "k8s-sa", false},
+		{"arn:aws:iam::123456789012:role/other_default", "k8s-sa", false},
+		{"arn:aws:iam::123456789012:role/default", "", true},
+		{"arn:aws:iam::123456789012:role/default_app", "", false},
+		{"k8s-sa_default", "k8s-sa", false},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%s,%t", tt.arn, tt.prefix, tt.want)
+		m := Manager{rolePrefix: tt.prefix}
+		t.Run(testname, func(t *testing.T) {
+			if got := m.IsSharedRoleARN(tt.arn); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
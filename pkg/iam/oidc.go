@@ -0,0 +1,72 @@
+package iam
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/smithy-go"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+	"github.com/ovotech/iam-service-account-controller/pkg/ref"
+)
+
+// NormaliseOIDCProvider returns the OIDC provider in the form used in IAM policies: the issuer URL
+// without the scheme or trailing slash, e.g. "oidc.eks.eu-west-1.amazonaws.com/id/ABCD".
+func NormaliseOIDCProvider(provider string) string {
+	provider = strings.TrimSpace(provider)
+	provider = strings.TrimPrefix(provider, "https://")
+	provider = strings.TrimPrefix(provider, "http://")
+	return strings.TrimRight(provider, "/")
+}
+
+// EnsureOIDCProvider checks the cluster's OIDC provider exists as an IAM OIDC identity provider in
+// the manager's account, as the roles' trust policies would be useless otherwise. If create is
+// true, a missing identity provider is created with the thumbprints and client IDs.
+func (m *Manager) EnsureOIDCProvider(create bool, thumbprints []string, clientIDs []string) error {
+	providerARN := m.makeProviderARN(m.oidcProvider)
+
+	_, err := m.client.GetOpenIDConnectProvider(
+		m.ctx,
+		&iam.GetOpenIDConnectProviderInput{OpenIDConnectProviderArn: &providerARN},
+	)
+	if err == nil {
+		return nil
+	}
+
+	var ae smithy.APIError
+	if !errors.As(err, &ae) || ae.ErrorCode() != "NoSuchEntity" {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	if !create {
+		return &iamerrors.IAMError{
+			Code:    iamerrors.NotFoundErrorCode,
+			Message: fmt.Sprintf("IAM OIDC identity provider '%s' doesn't exist", providerARN),
+		}
+	}
+	if len(thumbprints) == 0 {
+		return &iamerrors.IAMError{
+			Code:    iamerrors.OtherErrorCode,
+			Message: "a thumbprint is required to create the IAM OIDC identity provider",
+		}
+	}
+
+	_, err = m.client.CreateOpenIDConnectProvider(
+		m.ctx,
+		&iam.CreateOpenIDConnectProviderInput{
+			Url:            ref.String("https://" + m.oidcProvider),
+			ThumbprintList: thumbprints,
+			ClientIDList:   clientIDs,
+			Tags: []awsiamtypes.Tag{
+				{Key: ref.String(managedByTagKey), Value: ref.String(m.controllerName)},
+				{Key: ref.String(clusterTagKey), Value: &m.clusterName},
+			},
+		},
+	)
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+
+	return nil
+}
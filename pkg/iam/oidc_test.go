@@ -0,0 +1,36 @@
+package iam
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNormaliseOIDCProvider(t *testing.T) {
+	var tests = []struct {
+		provider string
+		want     string
+	}{
+		{
+			"oidc.eks.eu-west-1.amazonaws.com/id/ABCD",
+			"oidc.eks.eu-west-1.amazonaws.com/id/ABCD",
+		},
+		{
+			"https://oidc.eks.eu-west-1.amazonaws.com/id/ABCD",
+			"oidc.eks.eu-west-1.amazonaws.com/id/ABCD",
+		},
+		{
+			" https://oidc.eks.eu-west-1.amazonaws.com/id/ABCD/\n",
+			"oidc.eks.eu-west-1.amazonaws.com/id/ABCD",
+		},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%q,%s", tt.provider, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			ans := NormaliseOIDCProvider(tt.provider)
+			if ans != tt.want {
+				t.Errorf("got %s, want %s", ans, tt.want)
+			}
+		})
+	}
+}
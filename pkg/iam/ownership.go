@@ -0,0 +1,87 @@
+package iam
+
+import (
+	"fmt"
+
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+)
+
+// serviceAccountStack is the stack tagged on the role of the k8s ServiceAccount namespace/name.
+func serviceAccountStack(name string, namespace string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+// groupStack is the stack tagged on the role of the role group owned by the namespace.
+func groupStack(namespace string, group string) string {
+	return fmt.Sprintf("%s/group/%s", namespace, group)
+}
+
+// CheckOwnership returns nil if the AWS IAM Role for the k8s ServiceAccount namespace/name is
+// owned by this cluster, i.e. it can be updated and deleted. It returns a NotManaged error if the
+// role isn't managed by the controller, or a NotOwned error if it's managed for another cluster
+// or k8s identity, e.g. because clusters sharing the AWS account use the same role prefix.
+func (m *Manager) CheckOwnership(role *awsiamtypes.Role, name string, namespace string) error {
+	return m.checkOwnership(role, serviceAccountStack(name, namespace))
+}
+
+// CheckNamespaceRoleOwnership checks that the AWS IAM Role shared by the k8s ServiceAccounts of
+// the namespace is owned by this cluster, as CheckOwnership does for the role of a ServiceAccount.
+func (m *Manager) CheckNamespaceRoleOwnership(role *awsiamtypes.Role, namespace string) error {
+	return m.checkOwnership(role, namespace)
+}
+
+// CheckGroupRoleOwnership checks that the AWS IAM Role of the role group owned by the namespace
+// is owned by this cluster, as CheckOwnership does for the role of a ServiceAccount.
+func (m *Manager) CheckGroupRoleOwnership(
+	role *awsiamtypes.Role,
+	namespace string,
+	group string,
+) error {
+	return m.checkOwnership(role, groupStack(namespace, group))
+}
+
+// checkOwnership checks that the role is managed by the controller for the stack, and by this
+// cluster. Roles of another cluster are owned too once shared with this one, or if the other
+// cluster is one of the manager's shared clusters, so they can be shared in the first place.
+func (m *Manager) checkOwnership(role *awsiamtypes.Role, stack string) error {
+	if !m.IsManaged(role) {
+		return &iamerrors.IAMError{
+			Code:    iamerrors.NotManagedErrorCode,
+			Message: "Role not managed by controller",
+		}
+	}
+
+	tags := roleTags(role)
+	if tags[stackTagKey] != stack {
+		return &iamerrors.IAMError{
+			Code: iamerrors.NotOwnedErrorCode,
+			Message: fmt.Sprintf(
+				"Role managed for '%s', not '%s'",
+				tags[stackTagKey],
+				stack,
+			),
+		}
+	}
+	if !m.isClusterRole(role) && !m.isSharedCluster(tags[clusterTagKey]) {
+		return &iamerrors.IAMError{
+			Code: iamerrors.NotOwnedErrorCode,
+			Message: fmt.Sprintf(
+				"Role managed by cluster '%s', not '%s'",
+				tags[clusterTagKey],
+				m.clusterName,
+			),
+		}
+	}
+	return nil
+}
+
+// isSharedCluster returns true if the cluster is one this cluster shares its roles with.
+func (m *Manager) isSharedCluster(cluster string) bool {
+	for _, shared := range m.sharedClusters {
+		if shared == cluster {
+			return true
+		}
+	}
+	return false
+}
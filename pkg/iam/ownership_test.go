@@ -0,0 +1,123 @@
+package iam
+
+import (
+	"context"
+	"testing"
+
+	awsiam "github.com/aws/aws-sdk-go-v2/service/iam"
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+)
+
+func TestCheckOwnership(t *testing.T) {
+	m := Manager{
+		client:         awsiam.New(awsiam.Options{}),
+		rolePrefix:     "k8s-sa",
+		accountId:      "123456789012",
+		oidcProvider:   "oidc.eks.eu-west-1.amazonaws.com/id/BLUE",
+		clusterName:    "blue",
+		sharedClusters: []string{"green"},
+		controllerName: "iam-service-account-controller",
+		ctx:            context.TODO(),
+	}
+	controller := "iam-service-account-controller"
+
+	var tests = []struct {
+		name string
+		tags map[string]string
+		want string
+	}{
+		{
+			"owned",
+			map[string]string{managedByTagKey: controller, stackTagKey: "bar/app", clusterTagKey: "blue"},
+			"",
+		},
+		{
+			"not managed",
+			map[string]string{stackTagKey: "bar/app", clusterTagKey: "blue"},
+			iamerrors.NotManagedErrorCode,
+		},
+		{
+			"managed by another controller",
+			map[string]string{managedByTagKey: "other", stackTagKey: "bar/app", clusterTagKey: "blue"},
+			iamerrors.NotManagedErrorCode,
+		},
+		{
+			"another stack",
+			map[string]string{managedByTagKey: controller, stackTagKey: "bar", clusterTagKey: "blue"},
+			iamerrors.NotOwnedErrorCode,
+		},
+		{
+			"no stack",
+			map[string]string{managedByTagKey: controller, clusterTagKey: "blue"},
+			iamerrors.NotOwnedErrorCode,
+		},
+		{
+			"another cluster",
+			map[string]string{managedByTagKey: controller, stackTagKey: "bar/app", clusterTagKey: "red"},
+			iamerrors.NotOwnedErrorCode,
+		},
+		{
+			"no cluster",
+			map[string]string{managedByTagKey: controller, stackTagKey: "bar/app"},
+			iamerrors.NotOwnedErrorCode,
+		},
+		{
+			"shared with this cluster",
+			map[string]string{
+				managedByTagKey:                   controller,
+				stackTagKey:                       "bar/app",
+				clusterTagKey:                     "red",
+				clusterProviderTagPrefix + "blue": "oidc.eks.eu-west-1.amazonaws.com/id/BLUE",
+			},
+			"",
+		},
+		{
+			"shared cluster",
+			map[string]string{managedByTagKey: controller, stackTagKey: "bar/app", clusterTagKey: "green"},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role := &awsiamtypes.Role{}
+			for key, value := range tt.tags {
+				key, value := key, value
+				role.Tags = append(role.Tags, awsiamtypes.Tag{Key: &key, Value: &value})
+			}
+			err := m.CheckOwnership(role, "app", "bar")
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("got %v, want nil", err)
+			case tt.want != "" && (err == nil || err.(*iamerrors.IAMError).Code != tt.want):
+				t.Errorf("got %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckGroupRoleOwnership(t *testing.T) {
+	m := Manager{
+		client:         awsiam.New(awsiam.Options{}),
+		clusterName:    "blue",
+		controllerName: "iam-service-account-controller",
+		ctx:            context.TODO(),
+	}
+	role := &awsiamtypes.Role{}
+	for key, value := range map[string]string{
+		managedByTagKey: "iam-service-account-controller",
+		stackTagKey:     "bar/group/workers",
+		clusterTagKey:   "blue",
+	} {
+		key, value := key, value
+		role.Tags = append(role.Tags, awsiamtypes.Tag{Key: &key, Value: &value})
+	}
+
+	if err := m.CheckGroupRoleOwnership(role, "bar", "workers"); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+	if err := m.CheckNamespaceRoleOwnership(role, "bar"); !iamerrors.IsNotOwned(err) {
+		t.Errorf("got %v, want NotOwned", err)
+	}
+}
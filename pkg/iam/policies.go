@@ -0,0 +1,392 @@
+package iam
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+)
+
+// PolicyTemplateData holds the values available to admin policy templates.
+type PolicyTemplateData struct {
+	Name      string
+	Namespace string
+	Cluster   string
+	AccountID string
+}
+
+// ListAttachedPolicies returns the ARNs of the managed policies attached to the AWS IAM Role for
+// the k8s ServiceAccount namespace/name.
+func (m *Manager) ListAttachedPolicies(name string, namespace string) ([]string, error) {
+	roleName := m.makeIAMRoleName(name, namespace)
+	return m.listAttachedPolicies(roleName)
+}
+
+func (m *Manager) listAttachedPolicies(roleName string) ([]string, error) {
+	var arns []string
+
+	paginator := iam.NewListAttachedRolePoliciesPaginator(
+		m.client,
+		&iam.ListAttachedRolePoliciesInput{RoleName: &roleName},
+	)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(m.ctx)
+		if err != nil {
+			return nil, &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+		}
+		for _, policy := range page.AttachedPolicies {
+			arns = append(arns, *policy.PolicyArn)
+		}
+	}
+
+	return arns, nil
+}
+
+// attachedPolicyTagPrefix is the prefix of the tags recording the managed policies attached to a
+// role by the controller, so they're detached once no longer granted even if their grant was
+// removed from the config. Tags are keyed by a hash of the policy ARN, which can be longer than a
+// tag key, and have the ARN as value.
+const attachedPolicyTagPrefix = "role.k8s.aws/attached/"
+
+// maxTagValueLen is the maximum length of the value of an IAM tag.
+const maxTagValueLen = 256
+
+// attachedPolicyTagKey returns the key of the tag recording that the controller attached the
+// policy.
+func attachedPolicyTagKey(arn string) string {
+	sum := sha256.Sum256([]byte(arn))
+	return attachedPolicyTagPrefix + hex.EncodeToString(sum[:8])
+}
+
+// recordedPolicies returns the ARNs of the policies the controller recorded attaching to the role.
+func recordedPolicies(role *awsiamtypes.Role) []string {
+	var arns []string
+	for key, value := range roleTags(role) {
+		if strings.HasPrefix(key, attachedPolicyTagPrefix) {
+			arns = append(arns, value)
+		}
+	}
+	sort.Strings(arns)
+	return arns
+}
+
+// policyChanges returns the policies to attach to a role, and to detach from it, to converge the
+// attached policies with desired. Only policies in grantable, or recorded as attached by the
+// controller, are ever detached, so policies attached to the role by other means are left alone.
+func policyChanges(
+	attached []string,
+	desired []string,
+	grantable []string,
+	recorded []string,
+) (attach []string, detach []string) {
+	for _, arn := range desired {
+		if !contains(attached, arn) {
+			attach = append(attach, arn)
+		}
+	}
+	for _, arn := range attached {
+		if contains(desired, arn) {
+			continue
+		}
+		if contains(grantable, arn) || contains(recorded, arn) {
+			detach = append(detach, arn)
+		}
+	}
+	return attach, detach
+}
+
+// SyncAttachedPolicies converges the managed policies attached to the AWS IAM Role for the k8s
+// ServiceAccount namespace/name with desired, see policyChanges. The policies the controller
+// attaches are recorded on the role, so they're detached once no longer desired even if they're
+// no longer in grantable.
+func (m *Manager) SyncAttachedPolicies(
+	name string,
+	namespace string,
+	desired []string,
+	grantable []string,
+) error {
+	return m.syncAttachedPolicies(m.makeIAMRoleName(name, namespace), desired, grantable)
+}
+
+func (m *Manager) syncAttachedPolicies(roleName string, desired []string, grantable []string) error {
+	role, err := m.getRole(roleName)
+	if err != nil {
+		return err
+	}
+	attached, err := m.listAttachedPolicies(roleName)
+	if err != nil {
+		return err
+	}
+	recorded := recordedPolicies(role)
+
+	attach, detach := policyChanges(attached, desired, grantable, recorded)
+	for _, arn := range attach {
+		if err := m.attachPolicy(roleName, arn); err != nil {
+			return err
+		}
+	}
+
+	// Record the desired policies, including those attached before they were recorded
+	record := map[string]string{}
+	for _, arn := range desired {
+		if !contains(recorded, arn) && len(arn) <= maxTagValueLen {
+			record[attachedPolicyTagKey(arn)] = arn
+		}
+	}
+	if err := m.tagRole(roleName, record); err != nil {
+		return err
+	}
+
+	for _, arn := range detach {
+		if err := m.detachPolicy(roleName, arn); err != nil {
+			return err
+		}
+	}
+
+	// Forget the recorded policies which are no longer desired, whether detached or not
+	var forget []string
+	for _, arn := range recorded {
+		if !contains(desired, arn) {
+			forget = append(forget, attachedPolicyTagKey(arn))
+		}
+	}
+	return m.untagRole(roleName, forget)
+}
+
+func (m *Manager) attachPolicy(roleName string, policyArn string) error {
+	_, err := m.client.AttachRolePolicy(
+		m.ctx,
+		&iam.AttachRolePolicyInput{PolicyArn: &policyArn, RoleName: &roleName},
+	)
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	m.recordChange("AttachRolePolicy")
+	return nil
+}
+
+// detachAllPolicies detaches every managed policy from the role. IAM refuses to delete a role that
+// still has policies attached.
+func (m *Manager) detachAllPolicies(roleName string) error {
+	attached, err := m.listAttachedPolicies(roleName)
+	if err != nil {
+		return err
+	}
+
+	for _, arn := range attached {
+		if err := m.detachPolicy(roleName, arn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) detachPolicy(roleName string, policyArn string) error {
+	_, err := m.client.DetachRolePolicy(
+		m.ctx,
+		&iam.DetachRolePolicyInput{PolicyArn: &policyArn, RoleName: &roleName},
+	)
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	m.recordChange("DetachRolePolicy")
+	return nil
+}
+
+// RenderPolicyTemplate renders an admin policy template for the k8s ServiceAccount namespace/name.
+// The template uses Go text/template syntax, e.g. "arn:aws:s3:::bucket/{{ .Namespace }}/*", and
+// must render to a valid JSON policy document.
+func (m *Manager) RenderPolicyTemplate(
+	policyTemplate string,
+	name string,
+	namespace string,
+) (string, error) {
+	rendered, err := m.renderTemplate(policyTemplate, name, namespace)
+	if err != nil {
+		return "", err
+	}
+
+	if !json.Valid([]byte(rendered)) {
+		return "", fmt.Errorf("policy template doesn't render to valid JSON")
+	}
+
+	return rendered, nil
+}
+
+// renderTemplate renders a template with the PolicyTemplateData of the k8s ServiceAccount
+// namespace/name.
+func (m *Manager) renderTemplate(text string, name string, namespace string) (string, error) {
+	tmpl, err := template.New("policy").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered bytes.Buffer
+	data := PolicyTemplateData{
+		Name:      name,
+		Namespace: namespace,
+		Cluster:   m.clusterName,
+		AccountID: m.accountId,
+	}
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+
+	return rendered.String(), nil
+}
+
+// SyncInlinePolicies converges the inline policies of the AWS IAM Role for the k8s ServiceAccount
+// namespace/name whose names start with prefix with desired, a map of policy name (without prefix)
+// to policy document. Inline policies with other names are left alone.
+func (m *Manager) SyncInlinePolicies(
+	name string,
+	namespace string,
+	prefix string,
+	desired map[string]string,
+) error {
+	roleName := m.makeIAMRoleName(name, namespace)
+
+	existing, err := m.listInlinePolicies(roleName)
+	if err != nil {
+		return err
+	}
+
+	for policyName, document := range desired {
+		fullName := prefix + policyName
+		if contains(existing, fullName) {
+			current, err := m.getInlinePolicy(roleName, fullName)
+			if err != nil {
+				return err
+			}
+			if equalPolicyDocuments(current, document) {
+				continue
+			}
+		}
+		policyDocument := document
+		_, err := m.client.PutRolePolicy(
+			m.ctx,
+			&iam.PutRolePolicyInput{
+				PolicyDocument: &policyDocument,
+				PolicyName:     &fullName,
+				RoleName:       &roleName,
+			},
+		)
+		if err != nil {
+			return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+		}
+		m.recordChange("PutRolePolicy")
+	}
+
+	for _, fullName := range existing {
+		if !strings.HasPrefix(fullName, prefix) {
+			continue
+		}
+		if _, ok := desired[strings.TrimPrefix(fullName, prefix)]; ok {
+			continue
+		}
+		if err := m.deleteInlinePolicy(roleName, fullName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) listInlinePolicies(roleName string) ([]string, error) {
+	var names []string
+
+	paginator := iam.NewListRolePoliciesPaginator(
+		m.client,
+		&iam.ListRolePoliciesInput{RoleName: &roleName},
+	)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(m.ctx)
+		if err != nil {
+			return nil, &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+		}
+		names = append(names, page.PolicyNames...)
+	}
+
+	return names, nil
+}
+
+// getInlinePolicy returns the decoded policy document of an inline policy of the role.
+func (m *Manager) getInlinePolicy(roleName string, policyName string) (string, error) {
+	output, err := m.client.GetRolePolicy(
+		m.ctx,
+		&iam.GetRolePolicyInput{PolicyName: &policyName, RoleName: &roleName},
+	)
+	if err != nil {
+		return "", &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+
+	// IAM returns policy documents URL-encoded
+	document, err := url.QueryUnescape(*output.PolicyDocument)
+	if err != nil {
+		return "", &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+
+	return document, nil
+}
+
+func (m *Manager) deleteInlinePolicy(roleName string, policyName string) error {
+	_, err := m.client.DeleteRolePolicy(
+		m.ctx,
+		&iam.DeleteRolePolicyInput{PolicyName: &policyName, RoleName: &roleName},
+	)
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	m.recordChange("DeleteRolePolicy")
+	return nil
+}
+
+// deleteAllInlinePolicies deletes every inline policy of the role. IAM refuses to delete a role
+// that still has inline policies.
+func (m *Manager) deleteAllInlinePolicies(roleName string) error {
+	names, err := m.listInlinePolicies(roleName)
+	if err != nil {
+		return err
+	}
+
+	for _, policyName := range names {
+		if err := m.deleteInlinePolicy(roleName, policyName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// equalPolicyDocuments returns true if both JSON policy documents are semantically equal,
+// regardless of formatting.
+func equalPolicyDocuments(a string, b string) bool {
+	var aValue, bValue interface{}
+	if err := json.Unmarshal([]byte(a), &aValue); err != nil {
+		return false
+	}
+	if err := json.Unmarshal([]byte(b), &bValue); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(aValue, bValue)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	awsiam "github.com/aws/aws-sdk-go-v2/service/iam"
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/ovotech/iam-service-account-controller/pkg/ref"
+)
+
+func TestRenderPolicyTemplate(t *testing.T) {
+	var tests = []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{
+			`{"Resource": "arn:aws:s3:::bucket/{{ .Namespace }}/{{ .Name }}/*"}`,
+			`{"Resource": "arn:aws:s3:::bucket/default/test/*"}`,
+			false,
+		},
+		{
+			`{"Resource": "arn:aws:sqs:*:{{ .AccountID }}:{{ .Cluster }}-{{ .Namespace }}-*"}`,
+			`{"Resource": "arn:aws:sqs:*:123456789012:cluster-default-*"}`,
+			false,
+		},
+		{`{"Resource": "{{ .Unknown }}"}`, "", true},
+		{`{"Resource": {{ .Namespace }}}`, "", true},
+	}
+
+	m := Manager{
+		client:         awsiam.New(awsiam.Options{}),
+		rolePrefix:     "k8s-sa",
+		accountId:      "123456789012",
+		oidcProvider:   "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_ABCD",
+		clusterName:    "cluster",
+		controllerName: "iam-service-account-controller",
+		ctx:            context.TODO(),
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%t", tt.template, tt.wantErr)
+		t.Run(testname, func(t *testing.T) {
+			ans, err := m.RenderPolicyTemplate(tt.template, "test", "default")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if ans != tt.want {
+				t.Errorf("got %s, want %s", ans, tt.want)
+			}
+		})
+	}
+}
+
+func TestEqualPolicyDocuments(t *testing.T) {
+	var tests = []struct {
+		a    string
+		b    string
+		want bool
+	}{
+		{`{"Version": "2012-10-17"}`, `{"Version":"2012-10-17"}`, true},
+		{`{"Version": "2012-10-17"}`, `{"Version": "2008-10-17"}`, false},
+		{`{"Version": "2012-10-17"}`, `not json`, false},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%s,%t", tt.a, tt.b, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			ans := equalPolicyDocuments(tt.a, tt.b)
+			if ans != tt.want {
+				t.Errorf("got %t, want %t", ans, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyChanges(t *testing.T) {
+	a := "arn:aws:iam::123456789012:policy/a"
+	b := "arn:aws:iam::123456789012:policy/b"
+	other := "arn:aws:iam::123456789012:policy/other"
+
+	var tests = []struct {
+		name       string
+		attached   []string
+		desired    []string
+		grantable  []string
+		recorded   []string
+		wantAttach []string
+		wantDetach []string
+	}{
+		{"attach", nil, []string{a}, []string{a, b}, nil, []string{a}, nil},
+		{"converged", []string{a}, []string{a}, []string{a, b}, []string{a}, nil, nil},
+		{"no longer requested", []string{a, b}, []string{a}, []string{a, b}, []string{a, b}, nil, []string{b}},
+		{"grant removed from the config", []string{a, b}, []string{a}, []string{a}, []string{a, b}, nil, []string{b}},
+		{"grant policy changed", []string{a}, []string{b}, []string{b}, []string{a}, []string{b}, []string{a}},
+		{"attached by other means", []string{a, other}, []string{a}, []string{a, b}, []string{a}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attach, detach := policyChanges(tt.attached, tt.desired, tt.grantable, tt.recorded)
+			if !reflect.DeepEqual(attach, tt.wantAttach) {
+				t.Errorf("got attach %v, want %v", attach, tt.wantAttach)
+			}
+			if !reflect.DeepEqual(detach, tt.wantDetach) {
+				t.Errorf("got detach %v, want %v", detach, tt.wantDetach)
+			}
+		})
+	}
+}
+
+func TestRecordedPolicies(t *testing.T) {
+	a := "arn:aws:iam::123456789012:policy/a"
+	key, value := attachedPolicyTagKey(a), a
+	role := &awsiamtypes.Role{Tags: []awsiamtypes.Tag{
+		{Key: &key, Value: &value},
+		{Key: ref.String(managedByTagKey), Value: ref.String("iam-service-account-controller")},
+	}}
+
+	if got := recordedPolicies(role); !reflect.DeepEqual(got, []string{a}) {
+		t.Errorf("got %v, want %v", got, []string{a})
+	}
+	if len(key) > 128 {
+		t.Errorf("tag key %s is longer than 128 characters", key)
+	}
+}
@@ -0,0 +1,299 @@
+package iam
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	policyVersion = "2012-10-17"
+	// MaxInlinePolicyLength is the most characters IAM allows in the inline policies of a role,
+	// not counting whitespace.
+	MaxInlinePolicyLength = 10240
+)
+
+// isValidUserInput matches the strings we accept from outside our trust boundary, such as
+// ServiceAccount names and namespaces, before putting them in policy documents.
+var isValidUserInput = regexp.MustCompile(`^[1-9a-z-]+$`).MatchString
+
+// IsValidUserInput returns true if the input is acceptable from a security point of view to be
+// used in the policies generated by the controller.
+func IsValidUserInput(input string) bool {
+	return isValidUserInput(input)
+}
+
+// validateUserInput returns an error if any of the inputs isn't acceptable to be used in the
+// policies generated by the controller.
+func validateUserInput(inputs ...string) error {
+	for _, input := range inputs {
+		if !isValidUserInput(input) {
+			return fmt.Errorf("unexpected user input '%s'", input)
+		}
+	}
+	return nil
+}
+
+// PolicyDocument is an AWS IAM policy document.
+type PolicyDocument struct {
+	Version   string      `json:"Version"`
+	Id        string      `json:"Id,omitempty"`
+	Statement []Statement `json:"Statement"`
+}
+
+// The keys of the objects of a policy document, by object. Unlike encoding/json, IAM matches keys
+// case-sensitively.
+var (
+	documentKeys  = []string{"Version", "Id", "Statement"}
+	statementKeys = []string{
+		"Sid",
+		"Effect",
+		"Principal",
+		"NotPrincipal",
+		"Action",
+		"NotAction",
+		"Resource",
+		"NotResource",
+		"Condition",
+	}
+	principalKeys = []string{"AWS", "Federated", "Service"}
+)
+
+// Statement is a statement of an AWS IAM policy document.
+type Statement struct {
+	Sid          string     `json:"Sid,omitempty"`
+	Effect       string     `json:"Effect"`
+	Principal    *Principal `json:"Principal,omitempty"`
+	NotPrincipal *Principal `json:"NotPrincipal,omitempty"`
+	Action       StringList `json:"Action,omitempty"`
+	NotAction    StringList `json:"NotAction,omitempty"`
+	Resource     StringList `json:"Resource,omitempty"`
+	NotResource  StringList `json:"NotResource,omitempty"`
+	Condition    Condition  `json:"Condition,omitempty"`
+}
+
+// Principal is the principal of a statement. The wildcard principal "*" is read as an AWS
+// principal of "*".
+type Principal struct {
+	AWS       StringList `json:"AWS,omitempty"`
+	Federated StringList `json:"Federated,omitempty"`
+	Service   StringList `json:"Service,omitempty"`
+}
+
+// Condition maps condition operators, e.g. StringEquals, to condition keys and their values.
+type Condition map[string]map[string]StringList
+
+// StringList is a list of strings which, as in IAM policy documents, can also be written as a
+// single string.
+type StringList []string
+
+// MarshalJSON writes lists of a single item as a string, as IAM does.
+func (l StringList) MarshalJSON() ([]byte, error) {
+	if len(l) == 1 {
+		return json.Marshal(l[0])
+	}
+	return json.Marshal([]string(l))
+}
+
+// UnmarshalJSON reads either a string or a list of strings.
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = StringList{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// UnmarshalJSON reads either the wildcard principal "*" or a principal object.
+func (p *Principal) UnmarshalJSON(data []byte) error {
+	var wildcard string
+	if err := json.Unmarshal(data, &wildcard); err == nil {
+		if wildcard != "*" {
+			return fmt.Errorf("invalid principal '%s'", wildcard)
+		}
+		*p = Principal{AWS: StringList{"*"}}
+		return nil
+	}
+
+	// use an alias type to avoid recursing into this method
+	type principal Principal
+	var object principal
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	*p = Principal(object)
+	return nil
+}
+
+// UnmarshalJSON reads a policy document whose Statement is either a single statement or a list
+// of statements.
+func (d *PolicyDocument) UnmarshalJSON(data []byte) error {
+	var document struct {
+		Version   string          `json:"Version"`
+		Id        string          `json:"Id"`
+		Statement json.RawMessage `json:"Statement"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return err
+	}
+
+	d.Version = document.Version
+	d.Id = document.Id
+	d.Statement = nil
+	if len(document.Statement) == 0 {
+		return nil
+	}
+
+	var single Statement
+	if err := json.Unmarshal(document.Statement, &single); err == nil {
+		d.Statement = []Statement{single}
+		return nil
+	}
+	return json.Unmarshal(document.Statement, &d.Statement)
+}
+
+// ParsePolicyDocument reads a JSON policy document. Documents with unknown or duplicate keys are
+// rejected, as encoding/json would read them differently from IAM: it matches keys
+// case-insensitively, drops unknown keys and keeps the last of duplicate keys. What is read is
+// then exactly what String writes. Documents IAM would refuse as too long are rejected before
+// being read, as they come from users.
+func ParsePolicyDocument(document string) (*PolicyDocument, error) {
+	if length := policyLength(document); length > MaxInlinePolicyLength {
+		return nil, fmt.Errorf(
+			"invalid policy document: %d characters, longer than the %d IAM allows",
+			length,
+			MaxInlinePolicyLength,
+		)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(document))
+	value, err := decodeStrict(decoder)
+	if err == nil {
+		err = checkPolicyKeys(value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy document: %s", err)
+	}
+
+	var policy PolicyDocument
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return nil, fmt.Errorf("invalid policy document: %s", err)
+	}
+	return &policy, nil
+}
+
+// policyLength returns the length of the policy document as IAM counts it, in characters without
+// whitespace.
+func policyLength(document string) int {
+	length := 0
+	for _, r := range document {
+		if !unicode.IsSpace(r) {
+			length++
+		}
+	}
+	return length
+}
+
+// decodeStrict reads the next JSON value from the decoder as json.Unmarshal would into an
+// interface{}, but returns an error on duplicate keys.
+func decodeStrict(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		object := map[string]interface{}{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := object[key.(string)]; ok {
+				return nil, fmt.Errorf("duplicate key '%s'", key)
+			}
+			if object[key.(string)], err = decodeStrict(decoder); err != nil {
+				return nil, err
+			}
+		}
+		_, err := decoder.Token()
+		return object, err
+
+	case json.Delim('['):
+		list := []interface{}{}
+		for decoder.More() {
+			item, err := decodeStrict(decoder)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		_, err := decoder.Token()
+		return list, err
+	}
+	return token, nil
+}
+
+// checkPolicyKeys returns an error if the document, the statements or their principals have keys
+// which aren't exactly those of PolicyDocument, Statement and Principal. Values of the wrong type
+// are left to json.Unmarshal.
+func checkPolicyKeys(document interface{}) error {
+	object, ok := document.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if err := checkKeys(object, documentKeys); err != nil {
+		return err
+	}
+
+	statements, ok := object["Statement"].([]interface{})
+	if !ok {
+		statements = []interface{}{object["Statement"]}
+	}
+	for _, value := range statements {
+		statement, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if err := checkKeys(statement, statementKeys); err != nil {
+			return err
+		}
+		for _, key := range []string{"Principal", "NotPrincipal"} {
+			if principal, ok := statement[key].(map[string]interface{}); ok {
+				if err := checkKeys(principal, principalKeys); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkKeys returns an error if the object has a key which isn't one of keys.
+func checkKeys(object map[string]interface{}, keys []string) error {
+	for key := range object {
+		if !contains(keys, key) {
+			return fmt.Errorf("unknown key '%s'", key)
+		}
+	}
+	return nil
+}
+
+// String returns the JSON policy document.
+func (d *PolicyDocument) String() (string, error) {
+	document, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	return string(document), nil
+}
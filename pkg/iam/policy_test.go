@@ -0,0 +1,165 @@
+package iam
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePolicyDocument(t *testing.T) {
+	var tests = []struct {
+		document string
+		want     *PolicyDocument
+		wantErr  bool
+	}{
+		{
+			`{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}}`,
+			&PolicyDocument{
+				Version: "2012-10-17",
+				Statement: []Statement{
+					{Effect: "Allow", Action: StringList{"s3:GetObject"}, Resource: StringList{"*"}},
+				},
+			},
+			false,
+		},
+		{
+			`{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": ["s3:GetObject", "s3:PutObject"]}]}`,
+			&PolicyDocument{
+				Version: "2012-10-17",
+				Statement: []Statement{
+					{
+						Effect:    "Allow",
+						Principal: &Principal{AWS: StringList{"*"}},
+						Action:    StringList{"s3:GetObject", "s3:PutObject"},
+					},
+				},
+			},
+			false,
+		},
+		{
+			`{"Version": "2012-10-17", "Id": "app", "Statement": {"Effect": "Allow", "Principal": {"Federated": "arn"}}}`,
+			&PolicyDocument{
+				Version: "2012-10-17",
+				Id:      "app",
+				Statement: []Statement{
+					{Effect: "Allow", Principal: &Principal{Federated: StringList{"arn"}}},
+				},
+			},
+			false,
+		},
+		{`{"Version": "2012-10-17", "Statement": [{"Principal": "nobody"}]}`, nil, true},
+		{`not a policy`, nil, true},
+		// keys encoding/json would read differently from IAM
+		{`{"Version": "2012-10-17", "Statement": [{"effect": "Allow"}]}`, nil, true},
+		{`{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Actions": "*"}}`, nil, true},
+		{`{"Version": "2012-10-17", "Statement": [{"Principal": {"aws": "*"}}]}`, nil, true},
+		{`{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Effect": "Allow"}]}`, nil, true},
+		{
+			`{"Version": "2012-10-17", "Statement": [{"Condition": {"StringEquals": {"a": "b", "a": "c"}}}]}`,
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%t", tt.document, tt.wantErr)
+		t.Run(testname, func(t *testing.T) {
+			ans, err := ParsePolicyDocument(tt.document)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(ans, tt.want) {
+				t.Errorf("got %+v, want %+v", ans, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePolicyDocumentDisagreement(t *testing.T) {
+	// encoding/json reads the last Statement, IAM may apply the first one
+	raw := `{
+		"Version": "2012-10-17",
+		"Statement": [{"Effect": "Allow", "Action": "*", "Resource": "*"}],
+		"Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}]
+	}`
+	if _, err := ParsePolicyDocument(raw); err == nil {
+		t.Errorf("got no error for %s", raw)
+	}
+
+	// what is written from a parsed document reads back the same
+	valid := `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Action": ["s3:GetObject"], "Resource": "*"}}`
+	policy, err := ParsePolicyDocument(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, err := policy.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip, err := ParsePolicyDocument(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(roundTrip, policy) {
+		t.Errorf("got %+v, want %+v", roundTrip, policy)
+	}
+}
+
+func TestStringListMarshalJSON(t *testing.T) {
+	var tests = []struct {
+		list StringList
+		want string
+	}{
+		{StringList{"s3:GetObject"}, `"s3:GetObject"`},
+		{StringList{"s3:GetObject", "s3:PutObject"}, `["s3:GetObject","s3:PutObject"]`},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%v,%s", tt.list, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			ans, err := tt.list.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(ans) != tt.want {
+				t.Errorf("got %s, want %s", ans, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePolicyDocumentLength(t *testing.T) {
+	statement := `{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::%s"}`
+	document := func(resourceLength int) string {
+		return fmt.Sprintf(
+			`{"Version": "2012-10-17", "Statement": [`+statement+`]}`,
+			strings.Repeat("a", resourceLength),
+		)
+	}
+	// The length of the document without the resource, as IAM counts it
+	base := len(strings.Join(strings.Fields(document(0)), ""))
+
+	var tests = []struct {
+		name     string
+		document string
+		wantErr  bool
+	}{
+		{"at the limit", document(MaxInlinePolicyLength - base), false},
+		{"over the limit", document(MaxInlinePolicyLength - base + 1), true},
+		{
+			"whitespace doesn't count",
+			strings.Replace(document(MaxInlinePolicyLength-base), ",", ",\n    ", -1),
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePolicyDocument(tt.document)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
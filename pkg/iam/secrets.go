@@ -0,0 +1,66 @@
+package iam
+
+import "fmt"
+
+// MakeSecretsAccessPolicy returns a policy document allowing the k8s ServiceAccount namespace/name
+// to read the AWS Secrets Manager secrets under secretsPath. The path is a template with the same
+// values as admin policy templates, e.g. "{{ .Cluster }}/{{ .Namespace }}/{{ .Name }}/*". If
+// kmsKeyARN isn't empty, the policy also allows decrypting secrets with that KMS key through
+// Secrets Manager.
+func (m *Manager) MakeSecretsAccessPolicy(
+	name string,
+	namespace string,
+	secretsPath string,
+	kmsKeyARN string,
+) (string, error) {
+	// name and namespace end up in the policy's resources, we must make sure they can't be used
+	// to widen the policy to other secrets
+	if err := validateUserInput(name, namespace); err != nil {
+		return "", err
+	}
+
+	path, err := m.renderTemplate(secretsPath, name, namespace)
+	if err != nil {
+		return "", err
+	}
+
+	policy := PolicyDocument{
+		Version: policyVersion,
+		Statement: []Statement{
+			{
+				Sid:    "SecretsManagerRead",
+				Effect: "Allow",
+				Action: StringList{
+					"secretsmanager:DescribeSecret",
+					"secretsmanager:GetSecretValue",
+				},
+				Resource: StringList{
+					fmt.Sprintf(
+						"arn:aws:secretsmanager:%s:%s:secret:%s",
+						m.region,
+						m.accountId,
+						path,
+					),
+				},
+			},
+		},
+	}
+
+	if kmsKeyARN != "" {
+		policy.Statement = append(policy.Statement, Statement{
+			Sid:      "SecretsManagerDecrypt",
+			Effect:   "Allow",
+			Action:   StringList{"kms:Decrypt"},
+			Resource: StringList{kmsKeyARN},
+			Condition: Condition{
+				"StringEquals": {
+					"kms:ViaService": StringList{
+						fmt.Sprintf("secretsmanager.%s.amazonaws.com", m.region),
+					},
+				},
+			},
+		})
+	}
+
+	return policy.String()
+}
@@ -0,0 +1,62 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	awsiam "github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+func TestMakeSecretsAccessPolicy(t *testing.T) {
+	var tests = []struct {
+		name      string
+		namespace string
+		path      string
+		kmsKeyARN string
+		want      string
+		wantErr   bool
+	}{
+		{
+			"test",
+			"default",
+			"{{ .Cluster }}/{{ .Namespace }}/{{ .Name }}/*",
+			"",
+			`{"Version":"2012-10-17","Statement":[{"Sid":"SecretsManagerRead","Effect":"Allow","Action":["secretsmanager:DescribeSecret","secretsmanager:GetSecretValue"],"Resource":"arn:aws:secretsmanager:eu-west-1:123456789012:secret:cluster/default/test/*"}]}`,
+			false,
+		},
+		{
+			"test",
+			"default",
+			"{{ .Namespace }}/*",
+			"arn:aws:kms:eu-west-1:123456789012:key/abcd",
+			`{"Version":"2012-10-17","Statement":[{"Sid":"SecretsManagerRead","Effect":"Allow","Action":["secretsmanager:DescribeSecret","secretsmanager:GetSecretValue"],"Resource":"arn:aws:secretsmanager:eu-west-1:123456789012:secret:default/*"},{"Sid":"SecretsManagerDecrypt","Effect":"Allow","Action":"kms:Decrypt","Resource":"arn:aws:kms:eu-west-1:123456789012:key/abcd","Condition":{"StringEquals":{"kms:ViaService":"secretsmanager.eu-west-1.amazonaws.com"}}}]}`,
+			false,
+		},
+		{"*", "default", "{{ .Namespace }}/{{ .Name }}", "", "", true},
+	}
+
+	m := Manager{
+		client:         awsiam.New(awsiam.Options{}),
+		rolePrefix:     "k8s-sa",
+		region:         "eu-west-1",
+		accountId:      "123456789012",
+		oidcProvider:   "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_ABCD",
+		clusterName:    "cluster",
+		controllerName: "iam-service-account-controller",
+		ctx:            context.TODO(),
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%s,%s,%t", tt.name, tt.namespace, tt.path, tt.wantErr)
+		t.Run(testname, func(t *testing.T) {
+			ans, err := m.MakeSecretsAccessPolicy(tt.name, tt.namespace, tt.path, tt.kmsKeyARN)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if ans != tt.want {
+				t.Errorf("got %s, want %s", ans, tt.want)
+			}
+		})
+	}
+}
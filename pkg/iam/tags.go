@@ -0,0 +1,58 @@
+package iam
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+)
+
+// roleTags returns the tags of the role as a map of key to value.
+func roleTags(role *awsiamtypes.Role) map[string]string {
+	tags := map[string]string{}
+	for _, tag := range role.Tags {
+		tags[*tag.Key] = *tag.Value
+	}
+	return tags
+}
+
+// tagRole adds the tags to the role, overwriting the values of existing keys.
+func (m *Manager) tagRole(roleName string, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	awsTags := make([]awsiamtypes.Tag, 0, len(tags))
+	for _, key := range keys {
+		key, value := key, tags[key]
+		awsTags = append(awsTags, awsiamtypes.Tag{Key: &key, Value: &value})
+	}
+
+	_, err := m.client.TagRole(m.ctx, &iam.TagRoleInput{RoleName: &roleName, Tags: awsTags})
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	m.recordChange("TagRole")
+	return nil
+}
+
+// untagRole removes the tags with the given keys from the role.
+func (m *Manager) untagRole(roleName string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := m.client.UntagRole(m.ctx, &iam.UntagRoleInput{RoleName: &roleName, TagKeys: keys})
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	m.recordChange("UntagRole")
+	return nil
+}
@@ -0,0 +1,321 @@
+package iam
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+)
+
+// clusterProviderTagPrefix is the prefix of the per-cluster tags tracking which clusters trust a
+// role. Each cluster tags the role with its name after the prefix and its OIDC provider as value,
+// so that several clusters can share a role, e.g. when migrating workloads between clusters.
+const clusterProviderTagPrefix = "role.k8s.aws/cluster/"
+
+// makeAccessPolicy returns a string of an IAM Access Policy that allows AssumeRoleWithWebIdentity
+// for the k8s ServiceAccount with given namespace/name, with web identity tokens issued for
+// audience.
+func (m *Manager) makeAccessPolicy(name string, namespace string, audience string) (string, error) {
+	if err := validateUserInput(name, namespace); err != nil {
+		return "", err
+	}
+
+	return makeTrustPolicy(
+		[]Statement{m.makeAccessStatement(m.oidcProvider, name, namespace, audience)},
+	)
+}
+
+// makeAccessStatement returns the trust policy statement allowing the k8s ServiceAccount with
+// given namespace/name to assume the role with web identity tokens from the OIDC provider.
+func (m *Manager) makeAccessStatement(
+	provider string,
+	name string,
+	namespace string,
+	audience string,
+) Statement {
+	return m.makeSubjectsStatement(
+		provider,
+		StringList{fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)},
+		false,
+		audience,
+	)
+}
+
+// makeSubjectsStatement returns the trust policy statement allowing the web identity token
+// subjects to assume the role with tokens from the OIDC provider. If wildcard is true, the
+// subjects are matched with StringLike.
+func (m *Manager) makeSubjectsStatement(
+	provider string,
+	subjects StringList,
+	wildcard bool,
+	audience string,
+) Statement {
+	statement := Statement{
+		Effect: "Allow",
+		Principal: &Principal{
+			Federated: StringList{m.makeProviderARN(provider)},
+		},
+		Action: StringList{"sts:AssumeRoleWithWebIdentity"},
+		Condition: Condition{
+			"StringEquals": {
+				provider + ":aud": StringList{audience},
+			},
+		},
+	}
+
+	if wildcard {
+		statement.Condition["StringLike"] = map[string]StringList{provider + ":sub": subjects}
+	} else {
+		statement.Condition["StringEquals"][provider+":sub"] = subjects
+	}
+	return statement
+}
+
+// makeProviderARN returns the ARN of the IAM OIDC identity provider in the manager's account.
+func (m *Manager) makeProviderARN(provider string) string {
+	return fmt.Sprintf("arn:aws:iam::%s:oidc-provider/%s", m.accountId, provider)
+}
+
+// statementProvider returns the OIDC provider trusted by a trust policy statement, or an empty
+// string if the statement doesn't trust exactly one OIDC provider of the manager's account.
+func (m *Manager) statementProvider(statement Statement) string {
+	if statement.Principal == nil || len(statement.Principal.Federated) != 1 {
+		return ""
+	}
+
+	prefix := m.makeProviderARN("")
+	if !strings.HasPrefix(statement.Principal.Federated[0], prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(statement.Principal.Federated[0], prefix)
+}
+
+// makeTrustPolicy returns the trust policy document with the statements, ordered by the OIDC
+// provider they trust so that every cluster writes the same document.
+func makeTrustPolicy(statements []Statement) (string, error) {
+	sorted := append([]Statement(nil), statements...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return statementPrincipal(sorted[i]) < statementPrincipal(sorted[j])
+	})
+
+	policy := PolicyDocument{Version: policyVersion, Statement: sorted}
+	document, err := policy.String()
+	if err != nil {
+		return "", err
+	}
+
+	// Make sure the document reads back as the policy we meant, so nothing in the inputs can
+	// change its structure
+	roundTrip, err := ParsePolicyDocument(document)
+	if err != nil {
+		return "", err
+	}
+	if !reflect.DeepEqual(*roundTrip, policy) {
+		return "", fmt.Errorf("trust policy doesn't round-trip")
+	}
+
+	return document, nil
+}
+
+func statementPrincipal(statement Statement) string {
+	if statement.Principal == nil {
+		return ""
+	}
+	return strings.Join(statement.Principal.Federated, ",")
+}
+
+// clusterProviders returns the OIDC providers of the clusters which share the role, keyed by
+// cluster name.
+func clusterProviders(role *awsiamtypes.Role) map[string]string {
+	providers := map[string]string{}
+	for key, value := range roleTags(role) {
+		if strings.HasPrefix(key, clusterProviderTagPrefix) {
+			providers[strings.TrimPrefix(key, clusterProviderTagPrefix)] = value
+		}
+	}
+	return providers
+}
+
+// otherClusterProviders returns the OIDC providers of the other clusters sharing the role.
+func (m *Manager) otherClusterProviders(role *awsiamtypes.Role) []string {
+	var providers []string
+	for cluster, provider := range clusterProviders(role) {
+		if cluster != m.clusterName && provider != m.oidcProvider {
+			providers = append(providers, provider)
+		}
+	}
+	return providers
+}
+
+// isClusterRole returns true if the role was created by this cluster, or is shared with it.
+func (m *Manager) isClusterRole(role *awsiamtypes.Role) bool {
+	tags := roleTags(role)
+	_, shared := tags[clusterProviderTagPrefix+m.clusterName]
+	return tags[clusterTagKey] == m.clusterName || shared
+}
+
+// StaleOIDCProviders returns the OIDC providers trusted by the role for this cluster other than
+// the cluster's current OIDC provider, e.g. because the cluster was rebuilt and its OIDC issuer
+// changed. These are removed by SyncTrustPolicy, except for the previous OIDC providers which are
+// kept until they are removed from the manager's configuration.
+func (m *Manager) StaleOIDCProviders(role *awsiamtypes.Role) ([]string, error) {
+	if !m.isClusterRole(role) {
+		return nil, nil
+	}
+
+	// IAM returns policy documents URL-encoded
+	document, err := url.QueryUnescape(*role.AssumeRolePolicyDocument)
+	if err != nil {
+		return nil, &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	current, err := ParsePolicyDocument(document)
+	if err != nil {
+		return nil, &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+
+	others := m.otherClusterProviders(role)
+
+	var stale []string
+	for _, statement := range current.Statement {
+		provider := m.statementProvider(statement)
+		if provider == "" || provider == m.oidcProvider || contains(others, provider) {
+			continue
+		}
+		if !contains(stale, provider) {
+			stale = append(stale, provider)
+		}
+	}
+	return stale, nil
+}
+
+// IsPreviousOIDCProvider returns true if the roles of this cluster keep trusting the OIDC provider
+// while rotating to the current one.
+func (m *Manager) IsPreviousOIDCProvider(provider string) bool {
+	return contains(m.previousOIDCProviders, provider)
+}
+
+// makeSharedTrustPolicy returns the trust policy of the role with this cluster's statement
+// replaced by own (or removed if own is nil). The statements of the other clusters sharing the
+// role are kept as they are, as well as this cluster's statements for its previous OIDC providers
+// while rotating. Any other statement is dropped.
+func (m *Manager) makeSharedTrustPolicy(
+	role *awsiamtypes.Role,
+	own *Statement,
+) (string, error) {
+	// IAM returns policy documents URL-encoded
+	document, err := url.QueryUnescape(*role.AssumeRolePolicyDocument)
+	if err != nil {
+		return "", err
+	}
+	current, err := ParsePolicyDocument(document)
+	if err != nil {
+		return "", err
+	}
+
+	keep := m.otherClusterProviders(role)
+	if own != nil && m.isClusterRole(role) {
+		keep = append(keep, m.previousOIDCProviders...)
+	}
+
+	var statements []Statement
+	if own != nil {
+		statements = append(statements, *own)
+	}
+	for _, statement := range current.Statement {
+		provider := m.statementProvider(statement)
+		if provider != m.oidcProvider && contains(keep, provider) {
+			statements = append(statements, statement)
+		}
+	}
+
+	return makeTrustPolicy(statements)
+}
+
+// SyncTrustPolicy updates the AssumeRolePolicyDocument of the AWS IAM Role for the k8s
+// ServiceAccount namespace/name so that it trusts this cluster's OIDC provider, along with the
+// providers of the other clusters sharing the role. It also makes sure the role is tagged as
+// shared with this cluster.
+func (m *Manager) SyncTrustPolicy(
+	role *awsiamtypes.Role,
+	name string,
+	namespace string,
+	audience string,
+) error {
+	if err := validateUserInput(name, namespace); err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+
+	return m.syncTrustPolicy(role, m.makeAccessStatement(m.oidcProvider, name, namespace, audience))
+}
+
+// syncTrustPolicy updates the AssumeRolePolicyDocument of the role so that this cluster's
+// statement is own, see SyncTrustPolicy.
+func (m *Manager) syncTrustPolicy(role *awsiamtypes.Role, own Statement) error {
+	accessPolicy, err := m.makeSharedTrustPolicy(role, &own)
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+
+	if err := m.updateTrustPolicy(role, accessPolicy); err != nil {
+		return err
+	}
+
+	tagKey := clusterProviderTagPrefix + m.clusterName
+	if roleTags(role)[tagKey] != m.oidcProvider {
+		return m.tagRole(*role.RoleName, map[string]string{tagKey: m.oidcProvider})
+	}
+	return nil
+}
+
+// releaseTrustPolicy removes this cluster from a role shared with other clusters. It returns
+// false, without changing anything, if no other cluster shares the role.
+func (m *Manager) releaseTrustPolicy(role *awsiamtypes.Role) (bool, error) {
+	if len(m.otherClusterProviders(role)) == 0 {
+		return false, nil
+	}
+
+	accessPolicy, err := m.makeSharedTrustPolicy(role, nil)
+	if err != nil {
+		return false, &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+
+	if err := m.updateTrustPolicy(role, accessPolicy); err != nil {
+		return false, err
+	}
+
+	return true, m.untagRole(
+		*role.RoleName,
+		[]string{clusterProviderTagPrefix + m.clusterName},
+	)
+}
+
+// updateTrustPolicy sets the AssumeRolePolicyDocument of the role, if it's not already the same.
+func (m *Manager) updateTrustPolicy(role *awsiamtypes.Role, accessPolicy string) error {
+	// IAM returns policy documents URL-encoded
+	current, err := url.QueryUnescape(*role.AssumeRolePolicyDocument)
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	if equalPolicyDocuments(current, accessPolicy) {
+		return nil
+	}
+
+	_, err = m.client.UpdateAssumeRolePolicy(
+		m.ctx,
+		&iam.UpdateAssumeRolePolicyInput{
+			PolicyDocument: &accessPolicy,
+			RoleName:       role.RoleName,
+		},
+	)
+	if err != nil {
+		return &iamerrors.IAMError{Code: iamerrors.OtherErrorCode, Message: err.Error()}
+	}
+	m.recordChange("UpdateAssumeRolePolicy")
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+
+	awsiam "github.com/aws/aws-sdk-go-v2/service/iam"
+	awsiamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/ovotech/iam-service-account-controller/pkg/ref"
+)
+
+func TestMakeAccessPolicy(t *testing.T) {
+	var tests = []struct {
+		name      string
+		namespace string
+		audience  string
+		want      string
+		wantErr   bool
+	}{
+		{
+			"test",
+			"default",
+			"sts.amazonaws.com",
+			`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/ABCD"},"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"oidc.eks.eu-west-1.amazonaws.com/id/ABCD:aud":"sts.amazonaws.com","oidc.eks.eu-west-1.amazonaws.com/id/ABCD:sub":"system:serviceaccount:default:test"}}}]}`,
+			false,
+		},
+		{
+			"test",
+			"default",
+			`custom", "other`,
+			`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/ABCD"},"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"oidc.eks.eu-west-1.amazonaws.com/id/ABCD:aud":"custom\", \"other","oidc.eks.eu-west-1.amazonaws.com/id/ABCD:sub":"system:serviceaccount:default:test"}}}]}`,
+			false,
+		},
+		{`test"`, "default", "sts.amazonaws.com", "", true},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%s,%s,%t", tt.name, tt.namespace, tt.audience, tt.wantErr)
+		m := Manager{
+			client:         awsiam.New(awsiam.Options{}),
+			rolePrefix:     "k8s-sa",
+			accountId:      "123456789012",
+			oidcProvider:   "oidc.eks.eu-west-1.amazonaws.com/id/ABCD",
+			clusterName:    "cluster",
+			controllerName: "iam-service-account-controller",
+			ctx:            context.TODO(),
+		}
+		t.Run(testname, func(t *testing.T) {
+			ans, err := m.makeAccessPolicy(tt.name, tt.namespace, tt.audience)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if ans != tt.want {
+				t.Errorf("got %s, want %s", ans, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeSharedTrustPolicy(t *testing.T) {
+	blue := `{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/BLUE"},"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"oidc.eks.eu-west-1.amazonaws.com/id/BLUE:aud":"sts.amazonaws.com","oidc.eks.eu-west-1.amazonaws.com/id/BLUE:sub":"system:serviceaccount:default:test"}}}`
+	green := `{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/GREEN"},"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"oidc.eks.eu-west-1.amazonaws.com/id/GREEN:aud":"sts.amazonaws.com","oidc.eks.eu-west-1.amazonaws.com/id/GREEN:sub":"system:serviceaccount:default:test"}}}`
+	stale := `{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/STALE"},"Action":"sts:AssumeRoleWithWebIdentity"}`
+
+	var tests = []struct {
+		current string
+		tags    map[string]string
+		withOwn bool
+		want    string
+	}{
+		// a role trusted only by this cluster
+		{
+			`{"Version":"2012-10-17","Statement":[` + blue + `]}`,
+			map[string]string{"role.k8s.aws/cluster/blue": "oidc.eks.eu-west-1.amazonaws.com/id/BLUE"},
+			true,
+			`{"Version":"2012-10-17","Statement":[` + blue + `]}`,
+		},
+		// the green cluster's statement is kept, the stale one is dropped
+		{
+			`{"Version":"2012-10-17","Statement":[` + stale + `,` + green + `]}`,
+			map[string]string{"role.k8s.aws/cluster/green": "oidc.eks.eu-west-1.amazonaws.com/id/GREEN"},
+			true,
+			`{"Version":"2012-10-17","Statement":[` + blue + `,` + green + `]}`,
+		},
+		// releasing the role leaves the green cluster's statement
+		{
+			`{"Version":"2012-10-17","Statement":[` + blue + `,` + green + `]}`,
+			map[string]string{
+				"role.k8s.aws/cluster/blue":  "oidc.eks.eu-west-1.amazonaws.com/id/BLUE",
+				"role.k8s.aws/cluster/green": "oidc.eks.eu-west-1.amazonaws.com/id/GREEN",
+			},
+			false,
+			`{"Version":"2012-10-17","Statement":[` + green + `]}`,
+		},
+	}
+
+	m := Manager{
+		client:         awsiam.New(awsiam.Options{}),
+		rolePrefix:     "k8s-sa",
+		accountId:      "123456789012",
+		oidcProvider:   "oidc.eks.eu-west-1.amazonaws.com/id/BLUE",
+		clusterName:    "blue",
+		controllerName: "iam-service-account-controller",
+		ctx:            context.TODO(),
+	}
+
+	for i, tt := range tests {
+		testname := fmt.Sprintf("%d,%t", i, tt.withOwn)
+		t.Run(testname, func(t *testing.T) {
+			role := &awsiamtypes.Role{
+				RoleName:                 ref.String("k8s-sa_default_test"),
+				AssumeRolePolicyDocument: ref.String(url.QueryEscape(tt.current)),
+			}
+			for key, value := range tt.tags {
+				role.Tags = append(role.Tags, awsiamtypes.Tag{Key: ref.String(key), Value: ref.String(value)})
+			}
+
+			var own *Statement
+			if tt.withOwn {
+				statement := m.makeAccessStatement(m.oidcProvider, "test", "default", "sts.amazonaws.com")
+				own = &statement
+			}
+
+			ans, err := m.makeSharedTrustPolicy(role, own)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalPolicyDocuments(ans, tt.want) {
+				t.Errorf("got %s, want %s", ans, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaleOIDCProviders(t *testing.T) {
+	current := `{"Version":"2012-10-17","Statement":[` +
+		`{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/NEW"},"Action":"sts:AssumeRoleWithWebIdentity"},` +
+		`{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/OLD"},"Action":"sts:AssumeRoleWithWebIdentity"},` +
+		`{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/GREEN"},"Action":"sts:AssumeRoleWithWebIdentity"}]}`
+
+	var tests = []struct {
+		clusterTag string
+		want       []string
+	}{
+		{"blue", []string{"oidc.eks.eu-west-1.amazonaws.com/id/OLD"}},
+		{"other", nil},
+	}
+
+	m := Manager{
+		client:         awsiam.New(awsiam.Options{}),
+		rolePrefix:     "k8s-sa",
+		accountId:      "123456789012",
+		oidcProvider:   "oidc.eks.eu-west-1.amazonaws.com/id/NEW",
+		clusterName:    "blue",
+		controllerName: "iam-service-account-controller",
+		ctx:            context.TODO(),
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%v", tt.clusterTag, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			role := &awsiamtypes.Role{
+				RoleName:                 ref.String("k8s-sa_default_test"),
+				AssumeRolePolicyDocument: ref.String(url.QueryEscape(current)),
+				Tags: []awsiamtypes.Tag{
+					{Key: ref.String("role.k8s.aws/cluster"), Value: ref.String(tt.clusterTag)},
+					{
+						Key:   ref.String("role.k8s.aws/cluster/green"),
+						Value: ref.String("oidc.eks.eu-west-1.amazonaws.com/id/GREEN"),
+					},
+				},
+			}
+
+			ans, err := m.StaleOIDCProviders(role)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ans, tt.want) {
+				t.Errorf("got %v, want %v", ans, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// roleARNKeyPrefix starts the work queue keys of managed ServiceAccounts whose role-arn
+	// annotation no longer references their role, which can't be mistaken for the namespace/name
+	// keys of ServiceAccounts.
+	roleARNKeyPrefix = "rolearn:"
+	// The policies for a managed ServiceAccount whose role-arn annotation no longer references its
+	// role, see -role-arn-change-policy
+	roleARNRestore = "restore"
+	roleARNCleanup = "cleanup"
+)
+
+// isValidRoleARNChangePolicy returns true if the policy is one the controller can apply.
+func isValidRoleARNChangePolicy(policy string) bool {
+	return policy == roleARNRestore || policy == roleARNCleanup
+}
+
+// roleARNKey returns the work queue key of a ServiceAccount whose role-arn annotation changed.
+func roleARNKey(sa *corev1.ServiceAccount) string {
+	return roleARNKeyPrefix + sa.ObjectMeta.Namespace + "/" + sa.ObjectMeta.Name
+}
+
+// parseRoleARNKey returns the namespace/name key of the ServiceAccount of a work queue key, or
+// false if the key isn't of a ServiceAccount whose role-arn annotation changed.
+func parseRoleARNKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, roleARNKeyPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(key, roleARNKeyPrefix), true
+}
+
+// handleRoleARNChange enqueues a managed ServiceAccount whose role-arn annotation referenced the
+// role the controller manages for it, and was removed or changed to something else. Without this,
+// enqueueServiceAccount would skip it and the role would be left behind without any record.
+func (c *Controller) handleRoleARNChange(old, new interface{}) {
+	oldSA := old.(*corev1.ServiceAccount)
+	newSA := new.(*corev1.ServiceAccount)
+	ns, err := c.namespace(newSA.ObjectMeta.Namespace)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	oldARN, dropped := roleARNDropped(oldSA, newSA, ns)
+	if !dropped {
+		return
+	}
+
+	cfg, err := c.loadAdminConfig()
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	expectedARN, err := c.expectedRoleARN(oldSA, cfg)
+	if err != nil || oldARN != expectedARN {
+		return
+	}
+	c.workqueue.Add(roleARNKey(newSA))
+}
+
+// roleARNDropped returns the former role-arn annotation of a ServiceAccount, and true if the update
+// removed it or changed it while the ServiceAccount's role is still managed. Observed
+// ServiceAccounts don't need the annotation, and those managed through their namespace get it back
+// on their next sync.
+func roleARNDropped(
+	old *corev1.ServiceAccount,
+	new *corev1.ServiceAccount,
+	ns *corev1.Namespace,
+) (string, bool) {
+	oldARN, hadARN := old.ObjectMeta.Annotations[roleAnnotationKey]
+	newARN, hasARN := new.ObjectMeta.Annotations[roleAnnotationKey]
+	if !hadARN || (hasARN && newARN == oldARN) {
+		return "", false
+	}
+
+	mode, managed := managementModeOf(new, ns)
+	if !managed || mode == modeObserve || (!hasARN && isManagedByNamespace(new, ns)) {
+		return "", false
+	}
+	return oldARN, true
+}
+
+// syncRoleARN applies the -role-arn-change-policy to a managed ServiceAccount whose role-arn
+// annotation no longer references its role: either the annotation is restored, or the role is
+// cleaned up with the -unmanaged-role-policy. Nothing is done if the annotation was fixed in the
+// meantime.
+func (c *Controller) syncRoleARN(serviceAccountKey string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(serviceAccountKey)
+	if err != nil || !isValidUserInput(namespace) || !isValidUserInput(name) {
+		utilruntime.HandleError(fmt.Errorf("Invalid resource key: %s", serviceAccountKey))
+		return nil
+	}
+
+	sa, err := c.serviceAccountsLister.ServiceAccounts(namespace).Get(name)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	ns, err := c.namespace(namespace)
+	if err != nil {
+		return err
+	}
+	if mode, managed := managementModeOf(sa, ns); !managed || mode == modeObserve {
+		return nil
+	}
+
+	// IAMServiceAccountRoles annotate their ServiceAccount themselves
+	r, err := c.roleResourceFor(namespace, name)
+	if err != nil || r != nil {
+		return err
+	}
+
+	cfg, err := c.loadAdminConfig()
+	if err != nil {
+		return err
+	}
+	expectedARN, err := c.expectedRoleARN(sa, cfg)
+	if err != nil {
+		return err
+	}
+	currentARN, ok := sa.ObjectMeta.Annotations[roleAnnotationKey]
+	if currentARN == expectedARN {
+		return nil
+	}
+	change := "removed"
+	if ok {
+		change = fmt.Sprintf("changed to '%s'", currentARN)
+	}
+
+	if roleARNChangePolicy == roleARNRestore {
+		klog.Infof(
+			"ServiceAccount '%s' role-arn annotation %s, restoring '%s'",
+			serviceAccountKey,
+			change,
+			expectedARN,
+		)
+		if err := c.patchAnnotations(sa, map[string]interface{}{roleAnnotationKey: expectedARN}); err != nil {
+			message := fmt.Sprintf(MessageAnnotationFailed, err.Error())
+			c.recorder.Event(sa, corev1.EventTypeWarning, SyncFailed, message)
+			return err
+		}
+		c.recorder.Event(
+			sa,
+			corev1.EventTypeNormal,
+			RoleARNRestored,
+			fmt.Sprintf(
+				MessageRoleARNRestored,
+				roleAnnotationKey,
+				change,
+				expectedARN,
+				managedAnnotationKey,
+			),
+		)
+		return nil
+	}
+
+	// Shared roles are still used by the other ServiceAccounts
+	if _, inGroup, _ := roleGroupOf(sa); inGroup || cfg.hasNamespaceRole(namespace) {
+		return nil
+	}
+	manager, err := c.managerFor(namespace, cfg)
+	if err != nil {
+		return err
+	}
+	role, err := manager.GetRole(name, namespace)
+	switch {
+	case iamerrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return err
+	// Roles owned by another cluster, or which aren't managed, are left alone
+	case manager.CheckOwnership(role, name, namespace) != nil,
+		iam.RoleMode(role) == iam.CreateOnlyMode:
+		return nil
+	}
+
+	klog.Infof(
+		"ServiceAccount '%s' role-arn annotation %s, applying the '%s' policy to IAM Role '%s'",
+		serviceAccountKey,
+		change,
+		unmanagedRolePolicy,
+		*role.RoleName,
+	)
+	c.recorder.Event(
+		sa,
+		corev1.EventTypeWarning,
+		RoleARNChanged,
+		fmt.Sprintf(MessageRoleARNChanged, roleAnnotationKey, change, *role.Arn),
+	)
+	return c.applyUnmanagedRolePolicy(manager, sa, role)
+}
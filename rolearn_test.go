@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRoleARNDropped(t *testing.T) {
+	arn := "arn:aws:iam::123456789012:role/k8s-sa_bar_app"
+	managedNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "bar",
+			Annotations: map[string]string{managedAnnotationKey: "true"},
+		},
+	}
+
+	var tests = []struct {
+		name string
+		old  map[string]string
+		new  map[string]string
+		ns   *corev1.Namespace
+		want bool
+	}{
+		{
+			"removed",
+			map[string]string{managedAnnotationKey: "true", roleAnnotationKey: arn},
+			map[string]string{managedAnnotationKey: "true"},
+			nil,
+			true,
+		},
+		{
+			"changed",
+			map[string]string{managedAnnotationKey: "true", roleAnnotationKey: arn},
+			map[string]string{managedAnnotationKey: "true", roleAnnotationKey: arn + "-other"},
+			nil,
+			true,
+		},
+		{
+			"unchanged",
+			map[string]string{managedAnnotationKey: "true", roleAnnotationKey: arn},
+			map[string]string{managedAnnotationKey: "true", roleAnnotationKey: arn},
+			nil,
+			false,
+		},
+		{
+			"added",
+			map[string]string{managedAnnotationKey: "true"},
+			map[string]string{managedAnnotationKey: "true", roleAnnotationKey: arn},
+			nil,
+			false,
+		},
+		{
+			"no longer managed",
+			map[string]string{managedAnnotationKey: "true", roleAnnotationKey: arn},
+			map[string]string{},
+			nil,
+			false,
+		},
+		{
+			"observed",
+			map[string]string{managedAnnotationKey: "observe", roleAnnotationKey: arn},
+			map[string]string{managedAnnotationKey: "observe"},
+			nil,
+			false,
+		},
+		{"removed in managed namespace", map[string]string{roleAnnotationKey: arn}, nil, managedNamespace, false},
+		{
+			"changed in managed namespace",
+			map[string]string{roleAnnotationKey: arn},
+			map[string]string{roleAnnotationKey: arn + "-other"},
+			managedNamespace,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%t", tt.name, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			serviceAccount := func(annotations map[string]string) *corev1.ServiceAccount {
+				return &corev1.ServiceAccount{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "app",
+						Namespace:   "bar",
+						Annotations: annotations,
+					},
+				}
+			}
+
+			oldARN, dropped := roleARNDropped(serviceAccount(tt.old), serviceAccount(tt.new), tt.ns)
+			if dropped != tt.want {
+				t.Errorf("got %t, want %t", dropped, tt.want)
+			}
+			if dropped && oldARN != arn {
+				t.Errorf("got former ARN %s, want %s", oldARN, arn)
+			}
+		})
+	}
+}
+
+func TestParseRoleARNKey(t *testing.T) {
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "bar"}}
+	key, ok := parseRoleARNKey(roleARNKey(sa))
+	if !ok || key != "bar/app" {
+		t.Errorf("got %s,%t, want bar/app,true", key, ok)
+	}
+	if _, ok := parseRoleARNKey(unmanagedKey(sa)); ok {
+		t.Errorf("got an unmanaged key parsed as a role-arn key")
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+const (
+	// roleGroupKeyPrefix starts the work queue keys of role groups, which can't be mistaken for the
+	// namespace/name keys of ServiceAccounts.
+	roleGroupKeyPrefix = "rolegroup:"
+)
+
+// roleGroup identifies a role group by the namespace owning it and its name.
+type roleGroup struct {
+	namespace string
+	name      string
+}
+
+func (g roleGroup) String() string {
+	return g.namespace + "/" + g.name
+}
+
+// roleGroupOf returns the role group the ServiceAccount joins, if any. The annotation names either
+// a group of the ServiceAccount's namespace, e.g. "jobs", or a group owned by another namespace,
+// e.g. "batch/jobs".
+func roleGroupOf(sa *corev1.ServiceAccount) (roleGroup, bool, error) {
+	if sa == nil {
+		return roleGroup{}, false, nil
+	}
+	value, ok := sa.ObjectMeta.Annotations[roleGroupAnnotationKey]
+	if !ok || value == "" {
+		return roleGroup{}, false, nil
+	}
+
+	group := roleGroup{namespace: sa.ObjectMeta.Namespace, name: value}
+	if parts := strings.SplitN(value, "/", 2); len(parts) == 2 {
+		group = roleGroup{namespace: parts[0], name: parts[1]}
+	}
+	// Both end up in the role's name and ARN
+	if !isValidUserInput(group.namespace) || !isValidUserInput(group.name) {
+		return roleGroup{}, false, fmt.Errorf("invalid role group '%s'", value)
+	}
+	return group, true, nil
+}
+
+// roleGroupKey returns the work queue key of the role group.
+func roleGroupKey(group roleGroup) string {
+	return roleGroupKeyPrefix + group.String()
+}
+
+// parseRoleGroupKey returns the role group of a work queue key, or false if the key isn't a role
+// group's.
+func parseRoleGroupKey(key string) (roleGroup, bool) {
+	if !strings.HasPrefix(key, roleGroupKeyPrefix) {
+		return roleGroup{}, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, roleGroupKeyPrefix), "/", 2)
+	if len(parts) != 2 {
+		return roleGroup{}, false
+	}
+	return roleGroup{namespace: parts[0], name: parts[1]}, true
+}
+
+// enqueueFormerRoleGroup enqueues the role group a ServiceAccount left, so it stops trusting it.
+func (c *Controller) enqueueFormerRoleGroup(old, new interface{}) {
+	oldGroup, wasInGroup, _ := roleGroupOf(old.(*corev1.ServiceAccount))
+	newGroup, inGroup, _ := roleGroupOf(new.(*corev1.ServiceAccount))
+	if wasInGroup && (!inGroup || oldGroup != newGroup) {
+		c.workqueue.Add(roleGroupKey(oldGroup))
+	}
+}
+
+// enqueueGrantedRoleGroup enqueues the role group a grant ConfigMap is for, if it is one, so
+// ServiceAccounts join or leave the group as soon as the grant changes.
+func (c *Controller) enqueueGrantedRoleGroup(cm *corev1.ConfigMap) {
+	name, ok := cm.ObjectMeta.Labels[roleGroupGrantLabelKey]
+	if !ok {
+		return
+	}
+	c.workqueue.Add(roleGroupKey(roleGroup{namespace: cm.ObjectMeta.Namespace, name: name}))
+}
+
+// grantAllows returns true if an entry of a role group grant allows the ServiceAccount to join the
+// group. Entries are either a namespace, allowing all of its ServiceAccounts, or namespace/name.
+func grantAllows(from []string, sa *corev1.ServiceAccount) bool {
+	for _, entry := range from {
+		if entry == sa.ObjectMeta.Namespace ||
+			entry == sa.ObjectMeta.Namespace+"/"+sa.ObjectMeta.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// roleGroupGrants returns the entries of the grants letting ServiceAccounts of other namespaces
+// join the role group. Grants are ConfigMaps of the namespace owning the group, labelled with the
+// group's name, so only users who can write to that namespace can let others in.
+func (c *Controller) roleGroupGrants(group roleGroup) ([]string, error) {
+	selector := labels.SelectorFromSet(labels.Set{roleGroupGrantLabelKey: group.name})
+	cms, err := c.namespaceConfigMapsLister.ConfigMaps(group.namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var from []string
+	for _, cm := range cms {
+		from = append(from, strings.FieldsFunc(cm.Data[roleGroupGrantKey], func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})...)
+	}
+	return from, nil
+}
+
+// roleGroupMembers returns the ServiceAccounts which joined the role group, sorted by namespace
+// and name. ServiceAccounts of other namespaces which aren't allowed by a grant are refused with a
+// warning event.
+func (c *Controller) roleGroupMembers(
+	group roleGroup,
+	cfg *adminConfig,
+) ([]*corev1.ServiceAccount, error) {
+	serviceAccounts, err := c.serviceAccountsLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var from []string
+	var fromLoaded bool
+	var members []*corev1.ServiceAccount
+	for _, sa := range serviceAccounts {
+		if saGroup, inGroup, _ := roleGroupOf(sa); !inGroup || saGroup != group {
+			continue
+		}
+		// ServiceAccount names end up in the role's trust policy
+		if !isValidUserInput(sa.ObjectMeta.Name) || !isValidUserInput(sa.ObjectMeta.Namespace) {
+			continue
+		}
+
+		ns, err := c.namespace(sa.ObjectMeta.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		wanted, err := c.wantsRole(sa, ns, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if !wanted {
+			continue
+		}
+
+		if sa.ObjectMeta.Namespace != group.namespace {
+			if !fromLoaded {
+				if from, err = c.roleGroupGrants(group); err != nil {
+					return nil, err
+				}
+				fromLoaded = true
+			}
+			if !grantAllows(from, sa) {
+				klog.Infof(
+					"ServiceAccount '%s/%s' isn't allowed to join role group '%s'",
+					sa.ObjectMeta.Namespace,
+					sa.ObjectMeta.Name,
+					group,
+				)
+				c.recorder.Event(
+					sa,
+					corev1.EventTypeWarning,
+					RoleGroupRefused,
+					fmt.Sprintf(
+						MessageRoleGroupRefused,
+						fmt.Sprintf("no grant in namespace '%s' allows it", group.namespace),
+					),
+				)
+				continue
+			}
+		}
+
+		members = append(members, sa)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].ObjectMeta.Namespace != members[j].ObjectMeta.Namespace {
+			return members[i].ObjectMeta.Namespace < members[j].ObjectMeta.Namespace
+		}
+		return members[i].ObjectMeta.Name < members[j].ObjectMeta.Name
+	})
+	return members, nil
+}
+
+// recordGroupEvent records an event on every member of a role group, which has no object of its
+// own.
+func (c *Controller) recordGroupEvent(
+	members []*corev1.ServiceAccount,
+	eventtype string,
+	reason string,
+	message string,
+) {
+	for _, sa := range members {
+		c.recorder.Event(sa, eventtype, reason, message)
+	}
+}
+
+// syncRoleGroup converges the role shared by the members of the role group. The role is deleted
+// when the group has no members anymore.
+func (c *Controller) syncRoleGroup(group roleGroup) error {
+	// See syncHandler, they end up in the role's name
+	if !isValidUserInput(group.namespace) || !isValidUserInput(group.name) {
+		klog.Infof("Role group '%s' contains unexpected user input", group)
+		return nil
+	}
+
+	cfg, err := c.loadAdminConfig()
+	if err != nil {
+		return err
+	}
+
+	members, err := c.roleGroupMembers(group, cfg)
+	if err != nil {
+		return err
+	}
+
+	// The role lives in the AWS account of the namespace owning the group
+	manager, err := c.managerFor(group.namespace, cfg)
+	if err != nil {
+		c.recordGroupEvent(
+			members,
+			corev1.EventTypeWarning,
+			SyncFailed,
+			fmt.Sprintf(MessageAccountUnavailable, err.Error()),
+		)
+		return err
+	}
+
+	// The role from before a migration is migrated while the group has members, and deleted
+	// along with it otherwise
+	if len(members) > 0 {
+		if err := c.migrateGroupRole(manager, group, members); err != nil {
+			return err
+		}
+	} else if migrateRoles {
+		previous := manager.WithNaming(previousRolePrefix, previousClusterName)
+		err := previous.DeleteGroupRole(group.namespace, group.name)
+		if err := ignoreForeignRole(err, group.String()); err != nil {
+			return err
+		}
+	}
+
+	role, err := manager.GetGroupRole(group.namespace, group.name)
+	if err == nil {
+		err = manager.CheckGroupRoleOwnership(role, group.namespace, group.name)
+	}
+	switch {
+	case iamerrors.IsNotManaged(err):
+		c.recordGroupEvent(members, corev1.EventTypeWarning, SyncWarning, MessageUnmanagedRole)
+		for _, sa := range members {
+			c.writeStatus(sa, StatusUnmanaged, MessageUnmanagedRole, nil)
+		}
+		return nil
+
+	case iamerrors.IsNotOwned(err):
+		message := notOwnedMessage(role, err)
+		c.recordGroupEvent(members, corev1.EventTypeWarning, RoleNotOwned, message)
+		for _, sa := range members {
+			c.writeStatus(sa, StatusUnmanaged, message, nil)
+		}
+		return nil
+
+	case err == nil && len(members) == 0:
+		klog.Infof("Role group '%s' has no members, will delete its IAM Role", group)
+		return manager.DeleteGroupRole(group.namespace, group.name)
+
+	case iamerrors.IsNotFound(err) && len(members) == 0:
+		return nil
+
+	case err != nil && !iamerrors.IsNotFound(err):
+		return err
+	}
+
+	var refs []iam.ServiceAccountRef
+	for _, sa := range members {
+		refs = append(
+			refs,
+			iam.ServiceAccountRef{Name: sa.ObjectMeta.Name, Namespace: sa.ObjectMeta.Namespace},
+		)
+	}
+
+	ns, err := c.namespace(group.namespace)
+	if err != nil {
+		return err
+	}
+	spec := roleGroupSpec(group, ns, cfg)
+
+	if role == nil {
+		klog.Infof("No IAM Role for role group '%s'; creating it", group)
+		if err := manager.CreateGroupRole(group.namespace, group.name, refs, spec); err != nil {
+			c.recordGroupEvent(
+				members,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				fmt.Sprintf(MessageRoleCreationFailed, err.Error()),
+			)
+			return err
+		}
+		// The role's ID is only known once it exists
+		if role, err = manager.GetGroupRole(group.namespace, group.name); err != nil {
+			return err
+		}
+	} else {
+		if err := manager.SyncGroupTrustPolicy(role, refs, spec.Audience); err != nil {
+			c.recordGroupEvent(
+				members,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				fmt.Sprintf(MessageTrustPolicySyncFailed, err.Error()),
+			)
+			return err
+		}
+		if err := manager.SyncRoleMetadata(role, spec, cfg.RoleMetadata.managedTag); err != nil {
+			c.recordGroupEvent(
+				members,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				fmt.Sprintf(MessageRoleMetadataSyncFailed, err.Error()),
+			)
+			return err
+		}
+	}
+
+	// Only the members of the owning namespace choose the group's permissions, within what the
+	// namespace is allowed
+	var requested []string
+	for _, sa := range members {
+		if sa.ObjectMeta.Namespace != group.namespace {
+			continue
+		}
+		for _, grant := range splitAnnotationList(sa.ObjectMeta.Annotations[policyGrantsAnnotationKey]) {
+			if !contains(requested, grant) {
+				requested = append(requested, grant)
+			}
+		}
+	}
+	arns, refused := cfg.resolvePolicyGrants(requested, group.namespace)
+	if len(refused) > 0 {
+		c.recordGroupEvent(
+			members,
+			corev1.EventTypeWarning,
+			PolicyGrantRefused,
+			fmt.Sprintf(MessagePolicyGrantRefused, strings.Join(refused, ", ")),
+		)
+	}
+	err = manager.SyncGroupAttachedPolicies(
+		group.namespace,
+		group.name,
+		arns,
+		cfg.grantablePolicies(),
+	)
+	if err != nil {
+		c.recordGroupEvent(
+			members,
+			corev1.EventTypeWarning,
+			SyncFailed,
+			fmt.Sprintf(MessagePermissionsSyncFailed, err.Error()),
+		)
+		return err
+	}
+
+	// The members need the role's ARN to assume it
+	for _, sa := range members {
+		var applied []string
+		if sa.ObjectMeta.Namespace == group.namespace {
+			applied = append(applied, policyGrantsAnnotationKey)
+		}
+		c.writeStatus(sa, StatusSynced, c.sharedRoleStatus(sa, role, applied...), role)
+		val, ok := sa.ObjectMeta.Annotations[roleAnnotationKey]
+		if ok && (val == *role.Arn || !c.isPreviousRoleARN(sa, cfg, val)) {
+			continue
+		}
+		if err := c.annotateRoleARN(sa, *role.Arn); err != nil {
+			c.recorder.Event(
+				sa,
+				corev1.EventTypeWarning,
+				SyncFailed,
+				fmt.Sprintf(MessageAnnotationFailed, err.Error()),
+			)
+			return err
+		}
+	}
+
+	c.recordGroupEvent(members, corev1.EventTypeNormal, SyncSuccess, MessageResourceSynced)
+	return nil
+}
+
+// roleGroupSpec returns the spec of the role of the role group, tagged with the labels of the
+// namespace owning it. ns may be nil if the namespace doesn't exist.
+func roleGroupSpec(group roleGroup, ns *corev1.Namespace, cfg *adminConfig) iam.RoleSpec {
+	spec := iam.RoleSpec{
+		Audience: defaultAudience,
+		Description: fmt.Sprintf(
+			"IAM role shared by the ServiceAccounts of role group %s, managed by %s",
+			group,
+			controllerName,
+		),
+		DefaultDescription: true,
+		MaxSessionDuration: iam.DefaultMaxSessionDuration,
+	}
+	var namespaceLabels map[string]string
+	if ns != nil {
+		namespaceLabels = ns.ObjectMeta.Labels
+	}
+	spec.Tags = cfg.RoleMetadata.namespaceTags(namespaceLabels)
+	return spec
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRoleGroupOf(t *testing.T) {
+	var tests = []struct {
+		annotation string
+		want       roleGroup
+		wantOK     bool
+		wantErr    bool
+	}{
+		{"", roleGroup{}, false, false},
+		{"jobs", roleGroup{namespace: "team-a", name: "jobs"}, true, false},
+		{"batch/jobs", roleGroup{namespace: "batch", name: "jobs"}, true, false},
+		{"batch/jobs/x", roleGroup{}, false, true},
+		{"Jobs", roleGroup{}, false, true},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%q,%t,%t", tt.annotation, tt.wantOK, tt.wantErr)
+		t.Run(testname, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "runner",
+					Namespace:   "team-a",
+					Annotations: map[string]string{roleGroupAnnotationKey: tt.annotation},
+				},
+			}
+			group, ok, err := roleGroupOf(sa)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if ok != tt.wantOK || group != tt.want {
+				t.Errorf("got %v,%t, want %v,%t", group, ok, tt.want, tt.wantOK)
+			}
+			if ok {
+				parsed, parsedOK := parseRoleGroupKey(roleGroupKey(group))
+				if !parsedOK || parsed != group {
+					t.Errorf("got key for %v, want key for %v", parsed, group)
+				}
+			}
+		})
+	}
+}
+
+func TestGrantAllows(t *testing.T) {
+	var tests = []struct {
+		from      []string
+		name      string
+		namespace string
+		want      bool
+	}{
+		{[]string{"team-b"}, "runner", "team-b", true},
+		{[]string{"team-b/runner"}, "runner", "team-b", true},
+		{[]string{"team-b/other"}, "runner", "team-b", false},
+		{[]string{"team-c"}, "runner", "team-b", false},
+		{nil, "runner", "team-b", false},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%v,%s,%s,%t", tt.from, tt.name, tt.namespace, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: tt.name, Namespace: tt.namespace},
+			}
+			ans := grantAllows(tt.from, sa)
+			if ans != tt.want {
+				t.Errorf("got %t, want %t", ans, tt.want)
+			}
+		})
+	}
+}
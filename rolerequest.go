@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	securityv1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/apis/security/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// roleRequest is what is requested for the IAM Role of a ServiceAccount, either through the
+// ServiceAccount's annotations or through an IAMServiceAccountRole. Both are synced the same way.
+type roleRequest struct {
+	name                  string
+	namespace             string
+	audience              string
+	description           string
+	maxSessionDuration    string
+	tags                  []string
+	policyGrants          []string
+	policyTemplates       []string
+	secretsAccess         bool
+	assumeRoles           []string
+	inlinePolicy          string
+	inlinePolicyConfigMap string
+	// mode is how the controller manages the role
+	mode managementMode
+	// unmanaged is why the existing role is left alone, if it isn't managed by the controller
+	unmanaged string
+	// object is the object events about the request are recorded on
+	object runtime.Object
+	// refusals are the messages of the warning events recorded while syncing the request, for
+	// the requests reporting them in their status
+	refusals []string
+}
+
+// serviceAccountRequest returns the request made by the annotations of a ServiceAccount.
+func serviceAccountRequest(sa *corev1.ServiceAccount) *roleRequest {
+	annotations := sa.ObjectMeta.Annotations
+	return &roleRequest{
+		name:                  sa.ObjectMeta.Name,
+		namespace:             sa.ObjectMeta.Namespace,
+		audience:              annotations[audienceAnnotationKey],
+		description:           annotations[roleDescriptionAnnotationKey],
+		maxSessionDuration:    annotations[maxSessionDurationAnnotationKey],
+		tags:                  splitAnnotationList(annotations[roleTagsAnnotationKey]),
+		policyGrants:          splitAnnotationList(annotations[policyGrantsAnnotationKey]),
+		policyTemplates:       splitAnnotationList(annotations[policyTemplatesAnnotationKey]),
+		secretsAccess:         annotations[secretsAccessAnnotationKey] == "true",
+		assumeRoles:           splitAnnotationList(annotations[assumeRolesAnnotationKey]),
+		inlinePolicy:          annotations[inlinePolicyAnnotationKey],
+		inlinePolicyConfigMap: annotations[inlinePolicyConfigMapAnnotationKey],
+		mode:                  modeFull,
+		object:                sa,
+	}
+}
+
+// resourceRequest returns the request made by an IAMServiceAccountRole.
+func resourceRequest(r *securityv1alpha1.IAMServiceAccountRole) *roleRequest {
+	req := &roleRequest{
+		name:                  r.Spec.ServiceAccountName,
+		namespace:             r.ObjectMeta.Namespace,
+		audience:              r.Spec.Audience,
+		description:           r.Spec.Description,
+		policyGrants:          r.Spec.PolicyGrants,
+		policyTemplates:       r.Spec.PolicyTemplates,
+		secretsAccess:         r.Spec.SecretsAccess,
+		assumeRoles:           r.Spec.AssumeRoles,
+		inlinePolicy:          r.Spec.InlinePolicy,
+		inlinePolicyConfigMap: r.Spec.InlinePolicyConfigMap,
+		mode:                  modeFull,
+		object:                r,
+	}
+	if r.Spec.MaxSessionDuration != nil {
+		req.maxSessionDuration = r.Spec.MaxSessionDuration.Duration.String()
+	}
+	for key, value := range r.Spec.Tags {
+		req.tags = append(req.tags, fmt.Sprintf("%s=%s", key, value))
+	}
+	// Map order is random, keep the refusals stable between syncs
+	sort.Strings(req.tags)
+	return req
+}
+
+// roleAudience returns the audience of the web identity tokens the ServiceAccount uses to assume
+// its IAM Role.
+func (req *roleRequest) roleAudience() string {
+	if req.audience != "" {
+		return req.audience
+	}
+	return defaultAudience
+}
+
+// refuse records a warning event about part of the request that can't be applied.
+func (c *Controller) refuse(req *roleRequest, reason string, message string) {
+	req.refusals = append(req.refusals, message)
+	c.recorder.Event(req.object, corev1.EventTypeWarning, reason, message)
+}
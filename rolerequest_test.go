@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	securityv1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/apis/security/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestResourceRequest checks an IAMServiceAccountRole requests the same role as the equivalent
+// ServiceAccount annotations.
+func TestResourceRequest(t *testing.T) {
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bar",
+			Namespace: "foo",
+			Annotations: map[string]string{
+				audienceAnnotationKey:              "vault",
+				roleDescriptionAnnotationKey:       "Uploads invoices",
+				maxSessionDurationAnnotationKey:    "2h0m0s",
+				roleTagsAnnotationKey:              "team/tier=1,team/owner=payments",
+				policyGrantsAnnotationKey:          "s3-read, sqs",
+				policyTemplatesAnnotationKey:       "own-bucket",
+				secretsAccessAnnotationKey:         "true",
+				assumeRolesAnnotationKey:           "arn:aws:iam::123456789012:role/reports",
+				inlinePolicyConfigMapAnnotationKey: "bar-policy",
+			},
+		},
+	}
+	r := &securityv1alpha1.IAMServiceAccountRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "bar-role", Namespace: "foo"},
+		Spec: securityv1alpha1.IAMServiceAccountRoleSpec{
+			ServiceAccountName:    "bar",
+			Audience:              "vault",
+			Description:           "Uploads invoices",
+			MaxSessionDuration:    &metav1.Duration{Duration: 2 * time.Hour},
+			Tags:                  map[string]string{"team/tier": "1", "team/owner": "payments"},
+			PolicyGrants:          []string{"s3-read", "sqs"},
+			PolicyTemplates:       []string{"own-bucket"},
+			SecretsAccess:         true,
+			AssumeRoles:           []string{"arn:aws:iam::123456789012:role/reports"},
+			InlinePolicyConfigMap: "bar-policy",
+		},
+	}
+
+	fromAnnotations := serviceAccountRequest(sa)
+	fromResource := resourceRequest(r)
+	if fromResource.object != r {
+		t.Errorf("events of the request aren't recorded on the IAMServiceAccountRole")
+	}
+
+	// Only the order of the tags and the object events are recorded on may differ
+	fromAnnotations.object, fromResource.object = nil, nil
+	fromAnnotations.tags = []string{"team/owner=payments", "team/tier=1"}
+	if !reflect.DeepEqual(fromAnnotations, fromResource) {
+		t.Errorf("got %+v, want %+v", fromResource, fromAnnotations)
+	}
+}
+
+func TestRoleAudience(t *testing.T) {
+	if got := (&roleRequest{}).roleAudience(); got != defaultAudience {
+		t.Errorf("got %s, want %s", got, defaultAudience)
+	}
+	if got := (&roleRequest{audience: "vault"}).roleAudience(); got != "vault" {
+		t.Errorf("got %s, want vault", got)
+	}
+}
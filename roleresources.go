@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	securityv1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/apis/security/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// roleResourceKeyPrefix starts the work queue keys of IAMServiceAccountRoles, which can't be
+	// mistaken for the namespace/name keys of ServiceAccounts.
+	roleResourceKeyPrefix = "iamserviceaccountrole:"
+	// roleResourceFinalizer keeps an IAMServiceAccountRole until the controller deleted its role.
+	roleResourceFinalizer = "security.kaluza.com/iam-role"
+)
+
+// roleResourceKey returns the work queue key of the IAMServiceAccountRole.
+func roleResourceKey(r *securityv1alpha1.IAMServiceAccountRole) string {
+	return roleResourceKeyPrefix + r.ObjectMeta.Namespace + "/" + r.ObjectMeta.Name
+}
+
+// parseRoleResourceKey returns the namespace/name key of the IAMServiceAccountRole of a work queue
+// key, or false if the key isn't an IAMServiceAccountRole's.
+func parseRoleResourceKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, roleResourceKeyPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(key, roleResourceKeyPrefix), true
+}
+
+// enqueueRoleResource puts an IAMServiceAccountRole onto the work queue.
+func (c *Controller) enqueueRoleResource(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	r, ok := obj.(*securityv1alpha1.IAMServiceAccountRole)
+	if !ok {
+		return
+	}
+	c.workqueue.Add(roleResourceKey(r))
+}
+
+// handleRoleResourceUpdate enqueues an IAMServiceAccountRole when its spec changes, it is being
+// deleted, or on resyncs. Updates of its status alone don't need another sync.
+func (c *Controller) handleRoleResourceUpdate(old, new interface{}) {
+	oldResource := old.(*securityv1alpha1.IAMServiceAccountRole)
+	newResource := new.(*securityv1alpha1.IAMServiceAccountRole)
+	if oldResource.ObjectMeta.ResourceVersion != newResource.ObjectMeta.ResourceVersion &&
+		oldResource.ObjectMeta.Generation == newResource.ObjectMeta.Generation &&
+		newResource.ObjectMeta.DeletionTimestamp == nil {
+		return
+	}
+	c.enqueueRoleResource(new)
+}
+
+// enqueueAllRoleResources puts every IAMServiceAccountRole of the namespace, or of all namespaces
+// if it is empty, onto the work queue.
+func (c *Controller) enqueueAllRoleResources(namespace string) {
+	resources, err := c.roleResourcesLister.IAMServiceAccountRoles(namespace).List(
+		labels.Everything(),
+	)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, r := range resources {
+		c.enqueueRoleResource(r)
+	}
+}
+
+// roleResourceFor returns the IAMServiceAccountRole declaring the role of the ServiceAccount, or
+// nil if the ServiceAccount's role is requested through its annotations, if at all.
+func (c *Controller) roleResourceFor(
+	namespace string,
+	serviceAccountName string,
+) (*securityv1alpha1.IAMServiceAccountRole, error) {
+	resources, err := c.roleResourcesLister.IAMServiceAccountRoles(namespace).List(
+		labels.Everything(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return owningRoleResource(resources, serviceAccountName), nil
+}
+
+// owningRoleResource returns the IAMServiceAccountRole which declares the role of the ServiceAccount
+// among resources of its namespace. If several declare it, the oldest one wins and the others are
+// reported as conflicting.
+func owningRoleResource(
+	resources []*securityv1alpha1.IAMServiceAccountRole,
+	serviceAccountName string,
+) *securityv1alpha1.IAMServiceAccountRole {
+	var candidates []*securityv1alpha1.IAMServiceAccountRole
+	for _, r := range resources {
+		if roleResourceServiceAccount(r) == serviceAccountName {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i].ObjectMeta, candidates[j].ObjectMeta
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		return a.Name < b.Name
+	})
+	return candidates[0]
+}
+
+// roleResourceServiceAccount returns the name of the ServiceAccount whose role the
+// IAMServiceAccountRole manages. It is the one the role was created for, if any, so a changed
+// spec doesn't leave the role behind.
+func roleResourceServiceAccount(r *securityv1alpha1.IAMServiceAccountRole) string {
+	if r.Status.ServiceAccountName != "" {
+		return r.Status.ServiceAccountName
+	}
+	return r.Spec.ServiceAccountName
+}
+
+// syncRoleResource converges the IAM Role declared by an IAMServiceAccountRole with its spec, and
+// reports the outcome in its status. The role is deleted along with the IAMServiceAccountRole.
+func (c *Controller) syncRoleResource(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("Invalid resource key: %s", key))
+		return nil
+	}
+
+	r, err := c.roleResourcesLister.IAMServiceAccountRoles(namespace).Get(name)
+	if err != nil {
+		// The finalizer makes sure the role is gone before the IAMServiceAccountRole is
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	// Never modify objects from the informer cache
+	r = r.DeepCopy()
+
+	saName := roleResourceServiceAccount(r)
+	// The ServiceAccount name is input from outside our trust boundary, see syncHandler
+	if !isValidUserInput(namespace) || !isValidUserInput(saName) {
+		message := fmt.Sprintf(MessageInvalidServiceAccountName, saName)
+		c.recorder.Event(r, corev1.EventTypeWarning, SyncFailed, message)
+		if r.ObjectMeta.DeletionTimestamp != nil {
+			return c.removeRoleResourceFinalizer(r)
+		}
+		return c.updateRoleResourceStatus(r, SyncFailed, message)
+	}
+
+	owner, err := c.roleResourceFor(namespace, saName)
+	if err != nil {
+		return err
+	}
+	if owner.ObjectMeta.Name != r.ObjectMeta.Name {
+		if r.ObjectMeta.DeletionTimestamp != nil {
+			return c.removeRoleResourceFinalizer(r)
+		}
+		message := fmt.Sprintf(MessageRoleResourceConflict, owner.ObjectMeta.Name)
+		c.recorder.Event(r, corev1.EventTypeWarning, RoleResourceConflict, message)
+		return c.updateRoleResourceStatus(r, RoleResourceConflict, message)
+	}
+
+	cfg, err := c.loadAdminConfig()
+	if err != nil {
+		c.recorder.Event(
+			r,
+			corev1.EventTypeWarning,
+			SyncFailed,
+			fmt.Sprintf(MessageAdminConfigInvalid, err.Error()),
+		)
+		return err
+	}
+
+	manager, err := c.managerFor(namespace, cfg)
+	if err != nil {
+		message := fmt.Sprintf(MessageAccountUnavailable, err.Error())
+		c.recorder.Event(r, corev1.EventTypeWarning, SyncFailed, message)
+		if statusErr := c.updateRoleResourceStatus(r, SyncFailed, message); statusErr != nil {
+			utilruntime.HandleError(statusErr)
+		}
+		return err
+	}
+
+	// The IAMServiceAccountRole is being deleted, and its role with it
+	if r.ObjectMeta.DeletionTimestamp != nil {
+		if !contains(r.ObjectMeta.Finalizers, roleResourceFinalizer) {
+			return nil
+		}
+		klog.Infof(
+			"IAMServiceAccountRole '%s' is being deleted, will delete its IAM Role",
+			key,
+		)
+		err := manager.DeleteRole(saName, namespace)
+		if err := ignoreForeignRole(err, key); err != nil {
+			return err
+		}
+		// and so is its role before a migration, which would otherwise never be deleted
+		if migrateRoles {
+			previous := manager.WithNaming(previousRolePrefix, previousClusterName)
+			err := previous.DeleteRole(saName, namespace)
+			if err := ignoreForeignRole(err, key); err != nil {
+				return err
+			}
+		}
+		if err := c.removeRoleResourceFinalizer(r); err != nil {
+			return err
+		}
+		c.recorder.Forget(r)
+		// The ServiceAccount's annotations, or another IAMServiceAccountRole, can now manage its role
+		c.enqueueAllRoleResources(namespace)
+		if sa, err := c.serviceAccountsLister.ServiceAccounts(namespace).Get(saName); err == nil {
+			c.enqueueServiceAccount(sa)
+		}
+		return nil
+	}
+
+	if r.Spec.ServiceAccountName != saName {
+		message := fmt.Sprintf(MessageServiceAccountChanged, saName)
+		c.recorder.Event(r, corev1.EventTypeWarning, ServiceAccountChanged, message)
+		return c.updateRoleResourceStatus(r, ServiceAccountChanged, message)
+	}
+
+	// Admins decide which namespaces and ServiceAccounts can have a role at all
+	namespaceLabels, err := c.namespaceLabels(namespace)
+	if err != nil {
+		return err
+	}
+	if reason := cfg.Eligibility.refusal(saName, namespace, namespaceLabels); reason != "" {
+		message := fmt.Sprintf(MessageServiceAccountNotEligible, reason)
+		c.recorder.Event(r, corev1.EventTypeWarning, ServiceAccountNotEligible, message)
+		return c.updateRoleResourceStatus(r, ServiceAccountNotEligible, message)
+	}
+
+	// ServiceAccounts sharing a role can't have their own, as the admins or the group decided
+	sa, err := c.serviceAccountsLister.ServiceAccounts(namespace).Get(saName)
+	switch {
+	case k8serrors.IsNotFound(err):
+		sa = nil
+	case err != nil:
+		return err
+	}
+	if message := sharedRoleRefusal(sa, namespace, cfg); message != "" {
+		c.recorder.Event(r, corev1.EventTypeWarning, RoleResourceShared, message)
+		return c.updateRoleResourceStatus(r, RoleResourceShared, message)
+	}
+
+	// Make sure the role doesn't outlive the IAMServiceAccountRole before creating it
+	if !contains(r.ObjectMeta.Finalizers, roleResourceFinalizer) {
+		r.ObjectMeta.Finalizers = append(r.ObjectMeta.Finalizers, roleResourceFinalizer)
+		r, err = c.securityclientset.SecurityV1alpha1().IAMServiceAccountRoles(namespace).Update(
+			context.TODO(),
+			r,
+			metav1.UpdateOptions{},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := c.migrateResourceRole(manager, r, saName); err != nil {
+		if statusErr := c.updateRoleResourceStatus(r, SyncFailed, err.Error()); statusErr != nil {
+			utilruntime.HandleError(statusErr)
+		}
+		return err
+	}
+
+	req := resourceRequest(r)
+	role, err := c.syncRole(manager, req, cfg)
+	if err != nil {
+		if statusErr := c.updateRoleResourceStatus(r, SyncFailed, err.Error()); statusErr != nil {
+			utilruntime.HandleError(statusErr)
+		}
+		return err
+	}
+	if role == nil {
+		return c.updateRoleResourceStatus(r, SyncWarning, req.unmanaged)
+	}
+
+	r.Status.RoleARN = *role.Arn
+	r.Status.RoleID = *role.RoleId
+	r.Status.ServiceAccountName = saName
+
+	// The ServiceAccount may not exist yet, it gets the annotation once it does. The ARN of a
+	// shared role it used before, or of its role before a migration, was set by the controller
+	// and is replaced.
+	if sa != nil {
+		current, ok := sa.ObjectMeta.Annotations[roleAnnotationKey]
+		if !ok || (current != *role.Arn &&
+			(manager.IsSharedRoleARN(current) || c.isPreviousRoleARN(sa, cfg, current))) {
+			if err := c.annotateRoleARN(sa, *role.Arn); err != nil {
+				c.recorder.Event(
+					r,
+					corev1.EventTypeWarning,
+					SyncFailed,
+					fmt.Sprintf(MessageAnnotationFailed, err.Error()),
+				)
+				return err
+			}
+		}
+	}
+
+	if err := c.updateRoleResourceStatus(
+		r,
+		SyncSuccess,
+		MessageResourceSynced,
+		req.refusals...,
+	); err != nil {
+		return err
+	}
+	c.recorder.Event(r, corev1.EventTypeNormal, SyncSuccess, MessageResourceSynced)
+	return nil
+}
+
+// sharedRoleRefusal returns why the ServiceAccount of an IAMServiceAccountRole can't have its own
+// role, if it shares one: its namespace has a shared role, or it joins a role group. sa is nil if
+// the ServiceAccount doesn't exist yet.
+func sharedRoleRefusal(sa *corev1.ServiceAccount, namespace string, cfg *adminConfig) string {
+	if cfg.hasNamespaceRole(namespace) {
+		return fmt.Sprintf(MessageRoleResourceNamespaceRole, namespace)
+	}
+	if sa == nil {
+		return ""
+	}
+	if group, inGroup, _ := roleGroupOf(sa); inGroup {
+		return fmt.Sprintf(MessageRoleResourceRoleGroup, group)
+	}
+	return ""
+}
+
+// removeRoleResourceFinalizer lets the IAMServiceAccountRole be deleted.
+func (c *Controller) removeRoleResourceFinalizer(r *securityv1alpha1.IAMServiceAccountRole) error {
+	var finalizers []string
+	for _, finalizer := range r.ObjectMeta.Finalizers {
+		if finalizer != roleResourceFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	if len(finalizers) == len(r.ObjectMeta.Finalizers) {
+		return nil
+	}
+
+	r.ObjectMeta.Finalizers = finalizers
+	_, err := c.securityclientset.SecurityV1alpha1().IAMServiceAccountRoles(
+		r.ObjectMeta.Namespace,
+	).Update(context.TODO(), r, metav1.UpdateOptions{})
+	return err
+}
+
+// updateRoleResourceStatus sets the conditions of the IAMServiceAccountRole from the outcome of
+// its sync, and writes its status if it changed.
+func (c *Controller) updateRoleResourceStatus(
+	r *securityv1alpha1.IAMServiceAccountRole,
+	reason string,
+	message string,
+	refusals ...string,
+) error {
+	status := r.Status.DeepCopy()
+	setRoleResourceConditions(status, r.ObjectMeta.Generation, reason, message, refusals)
+	if reflect.DeepEqual(*status, r.Status) {
+		return nil
+	}
+
+	r.Status = *status
+	_, err := c.securityclientset.SecurityV1alpha1().IAMServiceAccountRoles(
+		r.ObjectMeta.Namespace,
+	).UpdateStatus(context.TODO(), r, metav1.UpdateOptions{})
+	return err
+}
+
+// setRoleResourceConditions sets the Ready and Degraded conditions of the status. The role is
+// ready if its sync ended with reason SyncSuccess, and degraded if it isn't ready or parts of the
+// spec were refused.
+func setRoleResourceConditions(
+	status *securityv1alpha1.IAMServiceAccountRoleStatus,
+	generation int64,
+	reason string,
+	message string,
+	refusals []string,
+) {
+	status.ObservedGeneration = generation
+
+	ready := metav1.Condition{
+		Type:               securityv1alpha1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	}
+	degraded := metav1.Condition{
+		Type:               securityv1alpha1.ConditionDegraded,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	}
+	switch {
+	case reason != SyncSuccess:
+	case len(refusals) > 0:
+		ready.Status = metav1.ConditionTrue
+		degraded.Reason = RequestRefused
+		degraded.Message = strings.Join(refusals, "; ")
+	default:
+		ready.Status = metav1.ConditionTrue
+		degraded.Status = metav1.ConditionFalse
+	}
+
+	meta.SetStatusCondition(&status.Conditions, ready)
+	meta.SetStatusCondition(&status.Conditions, degraded)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	securityv1alpha1 "github.com/ovotech/iam-service-account-controller/pkg/apis/security/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOwningRoleResource(t *testing.T) {
+	now := time.Now()
+	resource := func(name string, serviceAccountName string, age time.Duration) *securityv1alpha1.IAMServiceAccountRole {
+		return &securityv1alpha1.IAMServiceAccountRole{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "foo",
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+			},
+			Spec: securityv1alpha1.IAMServiceAccountRoleSpec{ServiceAccountName: serviceAccountName},
+		}
+	}
+	renamed := resource("renamed", "other", 3*time.Hour)
+	renamed.Status.ServiceAccountName = "bar"
+
+	var tests = []struct {
+		resources []*securityv1alpha1.IAMServiceAccountRole
+		want      string
+	}{
+		{nil, ""},
+		{[]*securityv1alpha1.IAMServiceAccountRole{resource("a", "baz", time.Hour)}, ""},
+		{[]*securityv1alpha1.IAMServiceAccountRole{resource("a", "bar", time.Hour)}, "a"},
+		{
+			[]*securityv1alpha1.IAMServiceAccountRole{
+				resource("a", "bar", time.Hour),
+				resource("b", "bar", 2*time.Hour),
+			},
+			"b",
+		},
+		{
+			[]*securityv1alpha1.IAMServiceAccountRole{
+				resource("b", "bar", time.Hour),
+				resource("a", "bar", time.Hour),
+			},
+			"a",
+		},
+		{
+			[]*securityv1alpha1.IAMServiceAccountRole{resource("a", "bar", time.Hour), renamed},
+			"renamed",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			owner := owningRoleResource(tt.resources, "bar")
+			var got string
+			if owner != nil {
+				got = owner.ObjectMeta.Name
+			}
+			if got != tt.want {
+				t.Errorf("got owner %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetRoleResourceConditions(t *testing.T) {
+	var tests = []struct {
+		reason       string
+		refusals     []string
+		wantReady    metav1.ConditionStatus
+		wantDegraded metav1.ConditionStatus
+	}{
+		{SyncSuccess, nil, metav1.ConditionTrue, metav1.ConditionFalse},
+		{SyncSuccess, []string{"Policy grants not allowed"}, metav1.ConditionTrue, metav1.ConditionTrue},
+		{SyncFailed, nil, metav1.ConditionFalse, metav1.ConditionTrue},
+		{ServiceAccountNotEligible, nil, metav1.ConditionFalse, metav1.ConditionTrue},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%v", tt.reason, tt.refusals)
+		t.Run(testname, func(t *testing.T) {
+			status := &securityv1alpha1.IAMServiceAccountRoleStatus{}
+			setRoleResourceConditions(status, 3, tt.reason, "message", tt.refusals)
+
+			if status.ObservedGeneration != 3 {
+				t.Errorf("got observed generation %d, want 3", status.ObservedGeneration)
+			}
+			ready := meta.FindStatusCondition(status.Conditions, securityv1alpha1.ConditionReady)
+			if ready == nil || ready.Status != tt.wantReady {
+				t.Errorf("got Ready %+v, want %s", ready, tt.wantReady)
+			}
+			degraded := meta.FindStatusCondition(status.Conditions, securityv1alpha1.ConditionDegraded)
+			if degraded == nil || degraded.Status != tt.wantDegraded {
+				t.Errorf("got Degraded %+v, want %s", degraded, tt.wantDegraded)
+			}
+		})
+	}
+}
+
+func TestSetRoleResourceConditionsTransition(t *testing.T) {
+	status := &securityv1alpha1.IAMServiceAccountRoleStatus{}
+	setRoleResourceConditions(status, 1, SyncSuccess, MessageResourceSynced, nil)
+	before := status.DeepCopy()
+
+	// Syncing again with the same outcome must not change the status, so it isn't written again
+	setRoleResourceConditions(status, 1, SyncSuccess, MessageResourceSynced, nil)
+	if !reflect.DeepEqual(before.Conditions, status.Conditions) {
+		t.Errorf("got %+v, want %+v", status.Conditions, before.Conditions)
+	}
+}
+
+func TestParseRoleResourceKey(t *testing.T) {
+	r := &securityv1alpha1.IAMServiceAccountRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "bar", Namespace: "foo"},
+	}
+	key, ok := parseRoleResourceKey(roleResourceKey(r))
+	if !ok || key != "foo/bar" {
+		t.Errorf("got %q, %t, want foo/bar", key, ok)
+	}
+	if _, ok := parseRoleResourceKey("foo/bar"); ok {
+		t.Errorf("ServiceAccount key parsed as an IAMServiceAccountRole key")
+	}
+}
+
+func TestSharedRoleRefusal(t *testing.T) {
+	cfg := &adminConfig{NamespaceRoles: map[string]namespaceRole{"shared": {}}}
+	serviceAccount := func(namespace string, annotations map[string]string) *corev1.ServiceAccount {
+		return &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "app",
+				Namespace:   namespace,
+				Annotations: annotations,
+			},
+		}
+	}
+
+	var tests = []struct {
+		name      string
+		sa        *corev1.ServiceAccount
+		namespace string
+		want      string
+	}{
+		{"own role", serviceAccount("foo", nil), "foo", ""},
+		{"not created yet", nil, "foo", ""},
+		{
+			"namespace role",
+			serviceAccount("shared", nil),
+			"shared",
+			fmt.Sprintf(MessageRoleResourceNamespaceRole, "shared"),
+		},
+		{
+			"namespace role, not created yet",
+			nil,
+			"shared",
+			fmt.Sprintf(MessageRoleResourceNamespaceRole, "shared"),
+		},
+		{
+			"role group",
+			serviceAccount("foo", map[string]string{roleGroupAnnotationKey: "jobs"}),
+			"foo",
+			fmt.Sprintf(MessageRoleResourceRoleGroup, "foo/jobs"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sharedRoleRefusal(tt.sa, tt.namespace, cfg); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
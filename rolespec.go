@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// maxDescriptionLength is the longest role description IAM allows.
+	maxDescriptionLength = 1000
+	// maxTagKeyLength and maxTagValueLength are the longest tag keys and values IAM allows.
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// tagPattern matches the tag keys and values IAM allows.
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// roleMetadata is the admin-defined limits of the metadata users can request for their roles.
+type roleMetadata struct {
+	// MaxSessionDuration is the longest maximum session duration users can request, e.g. "4h".
+	// Users can't request more than the IAM default of 1h if it isn't set.
+	MaxSessionDuration metav1.Duration `json:"maxSessionDuration"`
+	// UserTagPrefix is the prefix of the tag keys users can put on their roles, e.g. "team/".
+	// Users can't tag their roles if it isn't set.
+	UserTagPrefix string `json:"userTagPrefix"`
+	// NamespaceLabelTags are the keys of the namespace labels copied to the tags of the roles in
+	// the namespace, e.g. "team" or "cost-centre". Users can't set these tags themselves.
+	NamespaceLabelTags []string `json:"namespaceLabelTags"`
+}
+
+// validate returns an error if the limits can't be applied to IAM Roles.
+func (r *roleMetadata) validate() error {
+	duration := r.MaxSessionDuration.Duration
+	if duration != 0 &&
+		(duration < iam.DefaultMaxSessionDuration*time.Second ||
+			duration > iam.MaxMaxSessionDuration*time.Second) {
+		return fmt.Errorf("maxSessionDuration '%s' must be between 1h and 12h", duration)
+	}
+	if iam.IsReservedTagKey(r.UserTagPrefix) {
+		return fmt.Errorf("userTagPrefix '%s' is reserved", r.UserTagPrefix)
+	}
+	for _, key := range r.NamespaceLabelTags {
+		if key == "" || len(key) > maxTagKeyLength || iam.IsReservedTagKey(key) {
+			return fmt.Errorf("namespaceLabelTags key '%s' can't be a tag key", key)
+		}
+	}
+	return nil
+}
+
+// managedTag returns true if the controller manages the tag with this key, i.e. users can set it
+// or it's copied from a namespace label.
+func (r *roleMetadata) managedTag(key string) bool {
+	if r.UserTagPrefix != "" && strings.HasPrefix(key, r.UserTagPrefix) {
+		return true
+	}
+	return contains(r.NamespaceLabelTags, key)
+}
+
+// namespaceTags returns the tags copied from the labels of a namespace to its roles.
+func (r *roleMetadata) namespaceTags(namespaceLabels map[string]string) map[string]string {
+	tags := map[string]string{}
+	for _, key := range r.NamespaceLabelTags {
+		if value, ok := namespaceLabels[key]; ok {
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+// maxSessionDuration returns the longest maximum session duration users can request.
+func (r *roleMetadata) maxSessionDuration() time.Duration {
+	if r.MaxSessionDuration.Duration == 0 {
+		return iam.DefaultMaxSessionDuration * time.Second
+	}
+	return r.MaxSessionDuration.Duration
+}
+
+// roleSpec returns the spec of the IAM Role requested for the ServiceAccount, within the limits of
+// the admin configuration, tagged with the labels of its namespace. The role has controllerTags
+// tags of the controller, see iam.Manager.ControllerTags. Requested values which aren't allowed
+// are left out of the spec and returned as refused.
+func roleSpec(
+	req *roleRequest,
+	namespaceLabels map[string]string,
+	controllerTags int,
+	cfg *adminConfig,
+) (iam.RoleSpec, []string) {
+	spec := iam.RoleSpec{
+		Audience: req.roleAudience(),
+		Description: fmt.Sprintf(
+			"IAM role for ServiceAccount %s/%s, managed by %s",
+			req.namespace,
+			req.name,
+			controllerName,
+		),
+		DefaultDescription: true,
+		MaxSessionDuration: iam.DefaultMaxSessionDuration,
+		Tags:               map[string]string{},
+		Mode:               string(req.mode),
+	}
+	var refused []string
+
+	if description := req.description; description != "" {
+		if len(description) > maxDescriptionLength {
+			refused = append(
+				refused,
+				fmt.Sprintf("description is longer than %d characters", maxDescriptionLength),
+			)
+		} else {
+			spec.Description = description
+			spec.DefaultDescription = false
+		}
+	}
+
+	if value := req.maxSessionDuration; value != "" {
+		duration, err := time.ParseDuration(value)
+		switch {
+		case err != nil:
+			refused = append(refused, fmt.Sprintf("invalid max session duration '%s'", value))
+		case duration < iam.DefaultMaxSessionDuration*time.Second ||
+			duration > cfg.RoleMetadata.maxSessionDuration():
+			refused = append(
+				refused,
+				fmt.Sprintf(
+					"max session duration '%s' must be between 1h and %s",
+					value,
+					cfg.RoleMetadata.maxSessionDuration(),
+				),
+			)
+		default:
+			spec.MaxSessionDuration = int32(duration / time.Second)
+		}
+	}
+
+	// The user's tags share the role's tags with the controller's, the namespace's and those
+	// recording the attached policies
+	namespaceTags := cfg.RoleMetadata.namespaceTags(namespaceLabels)
+	policyARNs, _ := cfg.resolvePolicyGrants(req.policyGrants, req.namespace)
+	maxUserTags := iam.MaxRoleTags - controllerTags - len(namespaceTags) - len(policyARNs)
+	for _, tag := range req.tags {
+		key, value, err := parseTag(tag, cfg.RoleMetadata.UserTagPrefix)
+		if err != nil {
+			refused = append(refused, err.Error())
+			continue
+		}
+		if contains(cfg.RoleMetadata.NamespaceLabelTags, key) {
+			refused = append(refused, fmt.Sprintf("tag '%s' is set from the namespace's labels", key))
+			continue
+		}
+		if _, ok := spec.Tags[key]; !ok && len(spec.Tags) >= maxUserTags {
+			refused = append(
+				refused,
+				fmt.Sprintf("tag '%s' is over the limit of %d tags per role", key, iam.MaxRoleTags),
+			)
+			continue
+		}
+		spec.Tags[key] = value
+	}
+
+	for key, value := range namespaceTags {
+		spec.Tags[key] = value
+	}
+
+	return spec, refused
+}
+
+// parseTag reads a "key=value" tag requested by a user, whose key must start with prefix.
+func parseTag(tag string, prefix string) (string, string, error) {
+	parts := strings.SplitN(tag, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("invalid tag '%s'", tag)
+	}
+	key, value := parts[0], parts[1]
+
+	switch {
+	case prefix == "" || !strings.HasPrefix(key, prefix) || iam.IsReservedTagKey(key):
+		return "", "", fmt.Errorf("tag key '%s' is not allowed", key)
+	case !tagPattern.MatchString(key):
+		return "", "", fmt.Errorf("tag key '%s' has characters IAM doesn't allow", key)
+	case !tagPattern.MatchString(value):
+		return "", "", fmt.Errorf("value of tag '%s' has characters IAM doesn't allow", key)
+	case len(key) > maxTagKeyLength:
+		return "", "", fmt.Errorf("tag key '%s' is longer than %d characters", key, maxTagKeyLength)
+	case len(value) > maxTagValueLength:
+		return "", "", fmt.Errorf(
+			"value of tag '%s' is longer than %d characters",
+			key,
+			maxTagValueLength,
+		)
+	}
+	return key, value, nil
+}
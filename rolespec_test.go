@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRoleSpec(t *testing.T) {
+	cfg := &adminConfig{
+		RoleMetadata: roleMetadata{
+			MaxSessionDuration: metav1.Duration{Duration: 4 * time.Hour},
+			UserTagPrefix:      "team/",
+			NamespaceLabelTags: []string{"cost-centre", "team/owner"},
+		},
+	}
+	namespaceLabels := map[string]string{"cost-centre": "42", "environment": "prod"}
+
+	var tests = []struct {
+		annotations            map[string]string
+		wantMaxSessionDuration int32
+		wantTags               map[string]string
+		wantRefused            int
+	}{
+		{map[string]string{}, 3600, map[string]string{"cost-centre": "42"}, 0},
+		{
+			map[string]string{maxSessionDurationAnnotationKey: "2h"},
+			7200,
+			map[string]string{"cost-centre": "42"},
+			0,
+		},
+		{
+			map[string]string{maxSessionDurationAnnotationKey: "5h"},
+			3600,
+			map[string]string{"cost-centre": "42"},
+			1,
+		},
+		{
+			map[string]string{maxSessionDurationAnnotationKey: "30m"},
+			3600,
+			map[string]string{"cost-centre": "42"},
+			1,
+		},
+		{
+			map[string]string{maxSessionDurationAnnotationKey: "soon"},
+			3600,
+			map[string]string{"cost-centre": "42"},
+			1,
+		},
+		{
+			map[string]string{roleTagsAnnotationKey: "team/tier=1, team/region=eu"},
+			3600,
+			map[string]string{"cost-centre": "42", "team/tier": "1", "team/region": "eu"},
+			0,
+		},
+		{
+			map[string]string{roleTagsAnnotationKey: "team/tier=1,environment=dev,team/x"},
+			3600,
+			map[string]string{"cost-centre": "42", "team/tier": "1"},
+			2,
+		},
+		{
+			map[string]string{roleTagsAnnotationKey: "team/owner=payments"},
+			3600,
+			map[string]string{"cost-centre": "42"},
+			1,
+		},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%v", tt.annotations)
+		t.Run(testname, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Namespace:   "default",
+					Annotations: tt.annotations,
+				},
+			}
+			req := serviceAccountRequest(sa)
+			spec, refused := roleSpec(req, namespaceLabels, iam.ControllerTagCount, cfg)
+			if spec.MaxSessionDuration != tt.wantMaxSessionDuration {
+				t.Errorf("got %d, want %d", spec.MaxSessionDuration, tt.wantMaxSessionDuration)
+			}
+			if !reflect.DeepEqual(spec.Tags, tt.wantTags) {
+				t.Errorf("got tags %v, want %v", spec.Tags, tt.wantTags)
+			}
+			if len(refused) != tt.wantRefused {
+				t.Errorf("got refused %v, want %d refusals", refused, tt.wantRefused)
+			}
+		})
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	var tests = []struct {
+		tag     string
+		prefix  string
+		wantErr bool
+	}{
+		{"team/owner=payments", "team/", false},
+		{"team/owner=", "team/", false},
+		{"owner=payments", "team/", true},
+		{"team/owner=payments", "", true},
+		{"role.k8s.aws/cluster=other", "role.k8s.aws/", true},
+		{"team/owner", "team/", true},
+		{"team/owner=pay ments@é", "team/", false},
+		{"team/owner=payments;", "team/", true},
+		{"team/own*er=payments", "team/", true},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%s,%t", tt.tag, tt.prefix, tt.wantErr)
+		t.Run(testname, func(t *testing.T) {
+			_, _, err := parseTag(tt.tag, tt.prefix)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoleSpecTagLimit(t *testing.T) {
+	cfg := &adminConfig{
+		RoleMetadata: roleMetadata{
+			UserTagPrefix:      "team/",
+			NamespaceLabelTags: []string{"cost-centre"},
+		},
+	}
+	var tags []string
+	for i := 0; i < iam.MaxRoleTags; i++ {
+		tags = append(tags, fmt.Sprintf("team/tag-%d=%d", i, i))
+	}
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Namespace:   "default",
+			Annotations: map[string]string{roleTagsAnnotationKey: strings.Join(tags, ",")},
+		},
+	}
+
+	namespaceLabels := map[string]string{"cost-centre": "42"}
+	req := serviceAccountRequest(sa)
+	spec, refused := roleSpec(req, namespaceLabels, iam.ControllerTagCount, cfg)
+	if len(spec.Tags)+iam.ControllerTagCount != iam.MaxRoleTags {
+		t.Errorf("got %d tags, want %d", len(spec.Tags), iam.MaxRoleTags-iam.ControllerTagCount)
+	}
+	// The controller's tags and the namespace's leave room for all but 6 of the user's tags
+	if len(refused) != iam.ControllerTagCount+1 {
+		t.Errorf("got refused %v, want %d refusals", refused, iam.ControllerTagCount+1)
+	}
+	// Tags of the other clusters sharing the role, or of its migration, leave less room
+	_, refused = roleSpec(req, namespaceLabels, iam.ControllerTagCount+3, cfg)
+	if len(refused) != iam.ControllerTagCount+4 {
+		t.Errorf("got refused %v, want %d refusals", refused, iam.ControllerTagCount+4)
+	}
+}
+
+func TestRoleSpecDescription(t *testing.T) {
+	var tests = []struct {
+		description string
+		wantDefault bool
+	}{
+		{"", true},
+		{"Uploads invoices", false},
+		{strings.Repeat("a", maxDescriptionLength+1), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%.20s", tt.description), func(t *testing.T) {
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Namespace:   "default",
+					Annotations: map[string]string{roleDescriptionAnnotationKey: tt.description},
+				},
+			}
+			req := serviceAccountRequest(sa)
+			spec, _ := roleSpec(req, nil, iam.ControllerTagCount, &adminConfig{})
+			if spec.DefaultDescription != tt.wantDefault {
+				t.Errorf("got default %t, want %t", spec.DefaultDescription, tt.wantDefault)
+			}
+		})
+	}
+}
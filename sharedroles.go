@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ownRoleAnnotations are the annotations of a ServiceAccount configuring its own role, which don't
+// apply to a role it shares with other ServiceAccounts.
+var ownRoleAnnotations = []string{
+	audienceAnnotationKey,
+	roleDescriptionAnnotationKey,
+	maxSessionDurationAnnotationKey,
+	roleTagsAnnotationKey,
+	policyGrantsAnnotationKey,
+	policyTemplatesAnnotationKey,
+	secretsAccessAnnotationKey,
+	assumeRolesAnnotationKey,
+	inlinePolicyAnnotationKey,
+	inlinePolicyConfigMapAnnotationKey,
+}
+
+// ignoredAnnotations returns the annotations of the member of a shared role which don't apply to
+// the role, i.e. those configuring its own role except applied.
+func ignoredAnnotations(sa *corev1.ServiceAccount, applied ...string) []string {
+	var ignored []string
+	for _, key := range ownRoleAnnotations {
+		if _, ok := sa.ObjectMeta.Annotations[key]; ok && !contains(applied, key) {
+			ignored = append(ignored, key)
+		}
+	}
+	return ignored
+}
+
+// sharedRoleStatus returns the status message of the member of a shared role, which tells it the
+// annotations which don't apply to the role, if any, with a warning event.
+func (c *Controller) sharedRoleStatus(
+	sa *corev1.ServiceAccount,
+	role *awstypes.Role,
+	applied ...string,
+) string {
+	ignored := ignoredAnnotations(sa, applied...)
+	if len(ignored) == 0 {
+		return ""
+	}
+	message := fmt.Sprintf(MessageAnnotationsIgnored, strings.Join(ignored, ", "), *role.Arn)
+	c.recorder.Event(sa, corev1.EventTypeWarning, AnnotationsIgnored, message)
+	return message
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIgnoredAnnotations(t *testing.T) {
+	var tests = []struct {
+		name        string
+		annotations map[string]string
+		applied     []string
+		want        []string
+	}{
+		{"none", map[string]string{managedAnnotationKey: "true"}, nil, nil},
+		{
+			"own role",
+			map[string]string{
+				managedAnnotationKey:      "true",
+				audienceAnnotationKey:     "sts.example.com",
+				inlinePolicyAnnotationKey: "{}",
+			},
+			nil,
+			[]string{audienceAnnotationKey, inlinePolicyAnnotationKey},
+		},
+		{
+			"applied",
+			map[string]string{
+				policyGrantsAnnotationKey: "reader",
+				roleTagsAnnotationKey:     "team=foo",
+			},
+			[]string{policyGrantsAnnotationKey},
+			[]string{roleTagsAnnotationKey},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "foo",
+					Namespace:   "bar",
+					Annotations: tt.annotations,
+				},
+			}
+			got := ignoredAnnotations(sa, tt.applied...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+const (
+	// statusAnnotationPrefix starts the keys of the annotations the controller writes the status
+	// of a ServiceAccount's role to.
+	statusAnnotationPrefix        = "security.kaluza.com/iam-role-status-"
+	statusRoleARNAnnotationKey    = statusAnnotationPrefix + "arn"
+	statusRoleIDAnnotationKey     = statusAnnotationPrefix + "id"
+	statusSyncedAtAnnotationKey   = statusAnnotationPrefix + "synced-at"
+	statusReasonAnnotationKey     = statusAnnotationPrefix + "reason"
+	statusMessageAnnotationKey    = statusAnnotationPrefix + "message"
+	StatusSynced                  = "Synced"
+	StatusUnmanaged               = "Unmanaged"
+	StatusMisconfigured           = "Misconfigured"
+	StatusNotEligible             = "NotEligible"
+	StatusFailed                  = "Failed"
+	StatusObserved                = "Observed"
+	maxStatusMessageAnnotationLen = 1024
+	// statusSyncedAtInterval is how often the time of the last successful sync is updated when
+	// the status doesn't change, so resyncs don't patch every ServiceAccount.
+	statusSyncedAtInterval = time.Hour
+)
+
+// statusAnnotations returns the status annotations of a ServiceAccount after a sync ending with
+// reason. The role, which is nil unless the sync succeeded, and the time of the last successful
+// sync are kept from current otherwise. Unless the status changes, that time is only updated once
+// it's older than statusSyncedAtInterval, so most syncs which change nothing don't patch the
+// ServiceAccount. Annotations with a nil value are removed.
+func statusAnnotations(
+	current map[string]string,
+	reason string,
+	message string,
+	role *awstypes.Role,
+	now time.Time,
+) map[string]interface{} {
+	annotations := map[string]interface{}{
+		statusReasonAnnotationKey:  reason,
+		statusMessageAnnotationKey: nil,
+	}
+	// The same error in different syncs mustn't change the status
+	if message = withoutRequestIDs(message); message != "" {
+		if len(message) > maxStatusMessageAnnotationLen {
+			message = message[:maxStatusMessageAnnotationLen]
+		}
+		annotations[statusMessageAnnotationKey] = message
+	}
+	if role != nil {
+		annotations[statusRoleARNAnnotationKey] = *role.Arn
+		annotations[statusRoleIDAnnotationKey] = *role.RoleId
+	}
+
+	// Only write what changes
+	for key, value := range annotations {
+		currentValue, ok := current[key]
+		if (value == nil && !ok) || (ok && value == currentValue) {
+			delete(annotations, key)
+		}
+	}
+	syncedAt, err := time.Parse(time.RFC3339, current[statusSyncedAtAnnotationKey])
+	stale := err != nil || now.Sub(syncedAt) >= statusSyncedAtInterval
+	if role != nil && (len(annotations) > 0 || stale) {
+		annotations[statusSyncedAtAnnotationKey] = now.UTC().Format(time.RFC3339)
+	}
+	return annotations
+}
+
+// writeStatus writes the outcome of the sync of the ServiceAccount's role to its status
+// annotations, so users can see why it has no role without relying on events. Failing to write
+// them doesn't fail the sync.
+func (c *Controller) writeStatus(
+	sa *corev1.ServiceAccount,
+	reason string,
+	message string,
+	role *awstypes.Role,
+) {
+	annotations := statusAnnotations(sa.ObjectMeta.Annotations, reason, message, role, time.Now())
+	if len(annotations) == 0 {
+		return
+	}
+	if err := c.patchAnnotations(sa, annotations); err != nil {
+		utilruntime.HandleError(err)
+	}
+}
+
+// clearStatus removes the status annotations of a ServiceAccount whose role is no longer managed.
+func (c *Controller) clearStatus(sa *corev1.ServiceAccount) {
+	annotations := map[string]interface{}{}
+	for key := range sa.ObjectMeta.Annotations {
+		if strings.HasPrefix(key, statusAnnotationPrefix) {
+			annotations[key] = nil
+		}
+	}
+	if len(annotations) == 0 {
+		return
+	}
+	if err := c.patchAnnotations(sa, annotations); err != nil {
+		utilruntime.HandleError(err)
+	}
+}
+
+// patchAnnotations sets the annotations of the ServiceAccount, and removes those with a nil value.
+func (c *Controller) patchAnnotations(
+	sa *corev1.ServiceAccount,
+	annotations map[string]interface{},
+) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.kubeclientset.CoreV1().ServiceAccounts(sa.ObjectMeta.Namespace).Patch(
+		context.TODO(),
+		sa.ObjectMeta.Name,
+		types.MergePatchType,
+		patch,
+		metav1.PatchOptions{},
+	)
+	return err
+}
+
+// onlyStatusChanged returns true if an update of a ServiceAccount only changed its status
+// annotations, which doesn't need another sync. Resyncs, which don't change anything, do.
+func onlyStatusChanged(old *corev1.ServiceAccount, new *corev1.ServiceAccount) bool {
+	if old.ObjectMeta.ResourceVersion == new.ObjectMeta.ResourceVersion {
+		return false
+	}
+	return reflect.DeepEqual(withoutStatus(old), withoutStatus(new))
+}
+
+// withoutStatus returns a copy of the ServiceAccount without its status annotations and the
+// metadata which changes on every update.
+func withoutStatus(sa *corev1.ServiceAccount) *corev1.ServiceAccount {
+	sa = sa.DeepCopy()
+	sa.ObjectMeta.ResourceVersion = ""
+	sa.ObjectMeta.ManagedFields = nil
+	for key := range sa.ObjectMeta.Annotations {
+		if strings.HasPrefix(key, statusAnnotationPrefix) {
+			delete(sa.ObjectMeta.Annotations, key)
+		}
+	}
+	if len(sa.ObjectMeta.Annotations) == 0 {
+		sa.ObjectMeta.Annotations = nil
+	}
+	return sa
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awstypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStatusAnnotations(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	role := &awstypes.Role{
+		Arn:    aws.String("arn:aws:iam::123456789012:role/k8s-sa_foo_bar"),
+		RoleId: aws.String("AROAEXAMPLE"),
+	}
+	synced := map[string]string{
+		statusRoleARNAnnotationKey:  "arn:aws:iam::123456789012:role/k8s-sa_foo_bar",
+		statusRoleIDAnnotationKey:   "AROAEXAMPLE",
+		statusSyncedAtAnnotationKey: "2021-06-01T11:55:00Z",
+		statusReasonAnnotationKey:   StatusSynced,
+	}
+
+	var tests = []struct {
+		current map[string]string
+		reason  string
+		message string
+		role    *awstypes.Role
+		want    map[string]interface{}
+	}{
+		{
+			nil,
+			StatusSynced,
+			"",
+			role,
+			map[string]interface{}{
+				statusRoleARNAnnotationKey:  "arn:aws:iam::123456789012:role/k8s-sa_foo_bar",
+				statusRoleIDAnnotationKey:   "AROAEXAMPLE",
+				statusSyncedAtAnnotationKey: "2021-06-01T12:00:00Z",
+				statusReasonAnnotationKey:   StatusSynced,
+			},
+		},
+		{synced, StatusSynced, "", role, map[string]interface{}{}},
+		{
+			map[string]string{
+				statusRoleARNAnnotationKey:  "arn:aws:iam::123456789012:role/k8s-sa_foo_bar",
+				statusRoleIDAnnotationKey:   "AROAEXAMPLE",
+				statusSyncedAtAnnotationKey: "2021-06-01T11:00:00Z",
+				statusReasonAnnotationKey:   StatusSynced,
+			},
+			StatusSynced,
+			"",
+			role,
+			map[string]interface{}{statusSyncedAtAnnotationKey: "2021-06-01T12:00:00Z"},
+		},
+		{
+			map[string]string{
+				statusRoleARNAnnotationKey: "arn:aws:iam::123456789012:role/k8s-sa_foo_bar",
+				statusRoleIDAnnotationKey:  "AROAEXAMPLE",
+				statusReasonAnnotationKey:  StatusSynced,
+			},
+			StatusSynced,
+			"",
+			role,
+			map[string]interface{}{statusSyncedAtAnnotationKey: "2021-06-01T12:00:00Z"},
+		},
+		{
+			synced,
+			StatusFailed,
+			"AccessDenied",
+			nil,
+			map[string]interface{}{
+				statusReasonAnnotationKey:  StatusFailed,
+				statusMessageAnnotationKey: "AccessDenied",
+			},
+		},
+		{
+			map[string]string{
+				statusReasonAnnotationKey:  StatusUnmanaged,
+				statusMessageAnnotationKey: MessageUnmanagedRole,
+			},
+			StatusUnmanaged,
+			MessageUnmanagedRole,
+			nil,
+			map[string]interface{}{},
+		},
+		{
+			map[string]string{
+				statusReasonAnnotationKey:  StatusMisconfigured,
+				statusMessageAnnotationKey: MessageMisconfiguredARN,
+			},
+			StatusSynced,
+			"",
+			role,
+			map[string]interface{}{
+				statusRoleARNAnnotationKey:  "arn:aws:iam::123456789012:role/k8s-sa_foo_bar",
+				statusRoleIDAnnotationKey:   "AROAEXAMPLE",
+				statusSyncedAtAnnotationKey: "2021-06-01T12:00:00Z",
+				statusReasonAnnotationKey:   StatusSynced,
+				statusMessageAnnotationKey:  nil,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%v,%s", tt.current, tt.reason)
+		t.Run(testname, func(t *testing.T) {
+			got := statusAnnotations(tt.current, tt.reason, tt.message, tt.role, now)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnlyStatusChanged(t *testing.T) {
+	serviceAccount := func(resourceVersion string, annotations map[string]string) *corev1.ServiceAccount {
+		return &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "bar",
+				Namespace:       "foo",
+				ResourceVersion: resourceVersion,
+				Annotations:     annotations,
+			},
+		}
+	}
+
+	var tests = []struct {
+		old  *corev1.ServiceAccount
+		new  *corev1.ServiceAccount
+		want bool
+	}{
+		{
+			serviceAccount("1", map[string]string{managedAnnotationKey: "true"}),
+			serviceAccount("1", map[string]string{managedAnnotationKey: "true"}),
+			false,
+		},
+		{
+			serviceAccount("1", nil),
+			serviceAccount("2", map[string]string{statusReasonAnnotationKey: StatusFailed}),
+			true,
+		},
+		{
+			serviceAccount("1", map[string]string{managedAnnotationKey: "true"}),
+			serviceAccount("2", map[string]string{
+				managedAnnotationKey:      "true",
+				statusReasonAnnotationKey: StatusSynced,
+			}),
+			true,
+		},
+		{
+			serviceAccount("1", map[string]string{statusReasonAnnotationKey: StatusSynced}),
+			serviceAccount("2", map[string]string{
+				managedAnnotationKey:      "true",
+				statusReasonAnnotationKey: StatusSynced,
+			}),
+			false,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			if got := onlyStatusChanged(tt.old, tt.new); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	awstypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/ovotech/iam-service-account-controller/pkg/iam"
+	iamerrors "github.com/ovotech/iam-service-account-controller/pkg/iam/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// unmanagedKeyPrefix starts the work queue keys of ServiceAccounts which stopped being
+	// managed, which can't be mistaken for the namespace/name keys of managed ServiceAccounts.
+	unmanagedKeyPrefix = "unmanaged:"
+	// The policies for the role of a ServiceAccount which stops being managed, see
+	// -unmanaged-role-policy
+	unmanagedRoleDelete = "delete"
+	unmanagedRoleRetain = "retain"
+	unmanagedRoleDetach = "detach"
+)
+
+// isValidUnmanagedRolePolicy returns true if the policy is one the controller can apply.
+func isValidUnmanagedRolePolicy(policy string) bool {
+	switch policy {
+	case unmanagedRoleDelete, unmanagedRoleRetain, unmanagedRoleDetach:
+		return true
+	default:
+		return false
+	}
+}
+
+// unmanagedKey returns the work queue key of a ServiceAccount which stopped being managed.
+func unmanagedKey(sa *corev1.ServiceAccount) string {
+	return unmanagedKeyPrefix + sa.ObjectMeta.Namespace + "/" + sa.ObjectMeta.Name
+}
+
+// parseUnmanagedKey returns the namespace/name key of the ServiceAccount of a work queue key, or
+// false if the key isn't of a ServiceAccount which stopped being managed.
+func parseUnmanagedKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, unmanagedKeyPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(key, unmanagedKeyPrefix), true
+}
+
+// enqueueUnmanaged enqueues a ServiceAccount which was managed with its own role and no longer is,
+// because its managed annotation, or its namespace's, was removed or set to "false". Roles which
+// the controller never changes, in observe or create-only mode, are left alone.
+func (c *Controller) enqueueUnmanaged(
+	oldSA *corev1.ServiceAccount,
+	newSA *corev1.ServiceAccount,
+	oldNamespace *corev1.Namespace,
+	newNamespace *corev1.Namespace,
+) {
+	oldMode, wasManaged := managementModeOf(oldSA, oldNamespace)
+	if _, managed := managementModeOf(newSA, newNamespace); !wasManaged || managed {
+		return
+	}
+	if oldMode == modeObserve || oldMode == modeCreateOnly {
+		return
+	}
+	c.workqueue.Add(unmanagedKey(newSA))
+}
+
+// handleServiceAccountUnmanaged enqueues the ServiceAccount if an update stopped it being managed.
+func (c *Controller) handleServiceAccountUnmanaged(old, new interface{}) {
+	newSA := new.(*corev1.ServiceAccount)
+	ns, err := c.namespace(newSA.ObjectMeta.Namespace)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.enqueueUnmanaged(old.(*corev1.ServiceAccount), newSA, ns, ns)
+}
+
+// syncUnmanaged applies the -unmanaged-role-policy to the role of a ServiceAccount which stopped
+// being managed, so it isn't left behind without any record. Nothing is done if the
+// ServiceAccount is managed again, or doesn't have a role of its own anymore.
+func (c *Controller) syncUnmanaged(serviceAccountKey string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(serviceAccountKey)
+	if err != nil || !isValidUserInput(namespace) || !isValidUserInput(name) {
+		utilruntime.HandleError(fmt.Errorf("Invalid resource key: %s", serviceAccountKey))
+		return nil
+	}
+
+	sa, err := c.serviceAccountsLister.ServiceAccounts(namespace).Get(name)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	ns, err := c.namespace(namespace)
+	if err != nil {
+		return err
+	}
+	if isManaged(sa, ns) {
+		return nil
+	}
+
+	// Roles of IAMServiceAccountRoles, role groups and namespaces aren't the ServiceAccount's own
+	r, err := c.roleResourceFor(namespace, name)
+	if err != nil || r != nil {
+		return err
+	}
+	cfg, err := c.loadAdminConfig()
+	if err != nil {
+		return err
+	}
+	if _, inGroup, _ := roleGroupOf(sa); inGroup || cfg.hasNamespaceRole(namespace) {
+		return nil
+	}
+
+	manager, err := c.managerFor(namespace, cfg)
+	if err != nil {
+		return err
+	}
+	role, err := manager.GetRole(name, namespace)
+	switch {
+	case iamerrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return err
+	// Roles owned by another cluster, or which aren't managed, are left alone
+	case manager.CheckOwnership(role, name, namespace) != nil,
+		iam.RoleMode(role) == iam.CreateOnlyMode:
+		return nil
+	}
+
+	klog.Infof(
+		"ServiceAccount '%s' is no longer managed, applying the '%s' policy to IAM Role '%s'",
+		serviceAccountKey,
+		unmanagedRolePolicy,
+		*role.RoleName,
+	)
+	return c.applyUnmanagedRolePolicy(manager, sa, role)
+}
+
+// applyUnmanagedRolePolicy deletes, retains or detaches the managed role of the ServiceAccount
+// according to -unmanaged-role-policy, with an event saying what happened.
+func (c *Controller) applyUnmanagedRolePolicy(
+	manager *iam.Manager,
+	sa *corev1.ServiceAccount,
+	role *awstypes.Role,
+) error {
+	var err error
+	var reason, message string
+	switch unmanagedRolePolicy {
+	case unmanagedRoleDelete:
+		err = manager.DeleteRole(sa.ObjectMeta.Name, sa.ObjectMeta.Namespace)
+		reason, message = RoleDeleted, fmt.Sprintf(MessageRoleDeleted, *role.Arn)
+	case unmanagedRoleRetain:
+		err = manager.ReleaseRole(role)
+		reason, message = RoleReleased, fmt.Sprintf(MessageRoleReleased, *role.Arn)
+	default:
+		err = manager.DetachRole(role)
+		reason, message = RoleDetached, fmt.Sprintf(
+			MessageRoleDetached,
+			*role.Arn,
+			managedAnnotationKey,
+		)
+	}
+	if err != nil {
+		c.recorder.Event(
+			sa,
+			corev1.EventTypeWarning,
+			SyncFailed,
+			fmt.Sprintf(MessageUnmanagedRoleFailed, unmanagedRolePolicy, err.Error()),
+		)
+		return err
+	}
+
+	c.recorder.Event(sa, corev1.EventTypeNormal, reason, message)
+	c.clearStatus(sa)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestParseUnmanagedKey(t *testing.T) {
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "bar"}}
+	key, ok := parseUnmanagedKey(unmanagedKey(sa))
+	if !ok || key != "bar/app" {
+		t.Errorf("got %s,%t, want bar/app,true", key, ok)
+	}
+
+	for _, key := range []string{"bar/app", "bar", "rolegroup:bar/group"} {
+		if _, ok := parseUnmanagedKey(key); ok {
+			t.Errorf("got %s parsed as an unmanaged key", key)
+		}
+	}
+}
+
+func TestEnqueueUnmanaged(t *testing.T) {
+	managedNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "bar",
+			Annotations: map[string]string{managedAnnotationKey: "true"},
+		},
+	}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "bar"}}
+
+	var tests = []struct {
+		name  string
+		old   map[string]string
+		new   map[string]string
+		oldNs *corev1.Namespace
+		newNs *corev1.Namespace
+		want  bool
+	}{
+		{"removed", map[string]string{managedAnnotationKey: "true"}, nil, namespace, namespace, true},
+		{
+			"set to false",
+			map[string]string{managedAnnotationKey: "adopt"},
+			map[string]string{managedAnnotationKey: "false"},
+			namespace,
+			namespace,
+			true,
+		},
+		{"namespace unmanaged", nil, nil, managedNamespace, namespace, true},
+		{
+			"mode changed",
+			map[string]string{managedAnnotationKey: "true"},
+			map[string]string{managedAnnotationKey: "create-only"},
+			namespace,
+			namespace,
+			false,
+		},
+		{"observed", map[string]string{managedAnnotationKey: "observe"}, nil, namespace, namespace, false},
+		{"create-only", map[string]string{managedAnnotationKey: "create-only"}, nil, namespace, namespace, false},
+		{"never managed", nil, nil, namespace, namespace, false},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%s,%t", tt.name, tt.want)
+		t.Run(testname, func(t *testing.T) {
+			c := &Controller{
+				workqueue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+			}
+			defer c.workqueue.ShutDown()
+			serviceAccount := func(annotations map[string]string) *corev1.ServiceAccount {
+				return &corev1.ServiceAccount{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "app",
+						Namespace:   "bar",
+						Annotations: annotations,
+					},
+				}
+			}
+
+			c.enqueueUnmanaged(serviceAccount(tt.old), serviceAccount(tt.new), tt.oldNs, tt.newNs)
+			if got := c.workqueue.Len() == 1; got != tt.want {
+				t.Errorf("got enqueued %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsValidUnmanagedRolePolicy(t *testing.T) {
+	for _, policy := range []string{"delete", "retain", "detach"} {
+		if !isValidUnmanagedRolePolicy(policy) {
+			t.Errorf("got %s invalid", policy)
+		}
+	}
+	if isValidUnmanagedRolePolicy("keep") {
+		t.Errorf("got keep valid")
+	}
+}